@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"ms-mqtt-adapter/internal/events"
+	"ms-mqtt-adapter/internal/mysensors"
+	"ms-mqtt-adapter/pkg/config"
+	"ms-mqtt-adapter/pkg/gateway"
+	"reflect"
+	"sort"
+)
+
+// controlArgs are the arguments of control commands, each command uses only some of them
+type controlArgs struct {
+	Gateway string `json:"gateway"`
+	NodeID  int    `json:"node_id"`
+	Device  string `json:"device"`
+	Entity  string `json:"entity"`
+	Level   string `json:"level"`
+}
+
+// reloadResult describes what reload_config applied
+type reloadResult struct {
+	Added           []string `json:"added,omitempty"`
+	Updated         []string `json:"updated,omitempty"`
+	Removed         []string `json:"removed,omitempty"`
+	LogLevel        string   `json:"log_level"`
+	RestartRequired bool     `json:"restart_required"`
+	Reasons         []string `json:"reasons,omitempty"` // Why a restart is required
+}
+
+// registerControlCommands adds the commands of the <prefix>/adapter/cmd/ namespace
+func (app *Application) registerControlCommands() {
+	app.mqttClient.RegisterControlCommand("republish_discovery", func(payload []byte) (interface{}, error) {
+		app.republishDiscovery()
+		app.mqttClient.RepublishStates()
+		return map[string]interface{}{"devices": len(app.getDevices())}, nil
+	})
+
+	app.mqttClient.RegisterControlCommand("sync", func(payload []byte) (interface{}, error) {
+		args, err := decodeControlArgs(payload)
+		if err != nil {
+			return nil, err
+		}
+		synced, err := app.syncMgr.Sync(args.Device, args.Entity)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"synced": synced}, nil
+	})
+
+	app.mqttClient.RegisterControlCommand("version_request", func(payload []byte) (interface{}, error) {
+		args, err := decodeControlArgs(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		var gatewayNames []string
+		if args.Gateway != "" {
+			if _, exists := app.gateways[args.Gateway]; !exists {
+				return nil, fmt.Errorf("unknown gateway %s", args.Gateway)
+			}
+			gatewayNames = []string{args.Gateway}
+		} else {
+			for gatewayName := range app.gateways {
+				gatewayNames = append(gatewayNames, gatewayName)
+			}
+			sort.Strings(gatewayNames)
+		}
+
+		for _, gatewayName := range gatewayNames {
+			if err := app.gateways[gatewayName].SendVersionRequest(); err != nil {
+				return nil, fmt.Errorf("failed to send version request to gateway %s: %w", gatewayName, err)
+			}
+		}
+		return map[string]interface{}{"gateways": gatewayNames}, nil
+	})
+
+	app.mqttClient.RegisterControlCommand("reboot_node", func(payload []byte) (interface{}, error) {
+		args, err := decodeControlArgs(payload)
+		if err != nil {
+			return nil, err
+		}
+		if args.NodeID < 1 || args.NodeID > 254 {
+			return nil, fmt.Errorf("invalid node ID %d", args.NodeID)
+		}
+
+		gatewayName := app.config.GetEffectiveGateway(args.Gateway, "")
+		gw, exists := app.gateways[gatewayName]
+		if !exists {
+			return nil, fmt.Errorf("unknown gateway %s", gatewayName)
+		}
+		if err := gw.Send(mysensors.NewInternalMessage(args.NodeID, mysensors.I_REBOOT, "")); err != nil {
+			return nil, fmt.Errorf("failed to send reboot: %w", err)
+		}
+		return map[string]interface{}{"gateway": gatewayName, "node_id": args.NodeID}, nil
+	})
+
+	app.mqttClient.RegisterControlCommand("assign_node_id", app.nodeIDCommand((*gateway.Gateway).ReserveNodeID))
+	app.mqttClient.RegisterControlCommand("retire_node_id", app.nodeIDCommand((*gateway.Gateway).RetireNodeID))
+
+	app.mqttClient.RegisterControlCommand("log_level", func(payload []byte) (interface{}, error) {
+		args, err := decodeControlArgs(payload)
+		if err != nil {
+			return nil, err
+		}
+		// Without a level the command only reports the current one
+		if args.Level != "" {
+			if err := events.SetLogLevel(args.Level); err != nil {
+				return nil, err
+			}
+			app.logger.Info("Log level changed", "level", events.LogLevel())
+		}
+		return map[string]interface{}{"level": events.LogLevel()}, nil
+	})
+
+	app.mqttClient.RegisterControlCommand("reload_config", func(payload []byte) (interface{}, error) {
+		return app.reloadConfig()
+	})
+}
+
+// nodeIDCommand returns a control command that changes a node ID in the registry of a gateway
+func (app *Application) nodeIDCommand(change func(gw *gateway.Gateway, nodeID int) error) func(payload []byte) (interface{}, error) {
+	return func(payload []byte) (interface{}, error) {
+		args, err := decodeControlArgs(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		gatewayName := app.config.GetEffectiveGateway(args.Gateway, "")
+		gw, exists := app.gateways[gatewayName]
+		if !exists {
+			return nil, fmt.Errorf("unknown gateway %s", gatewayName)
+		}
+		if err := change(gw, args.NodeID); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"gateway": gatewayName, "node_id": args.NodeID}, nil
+	}
+}
+
+// decodeControlArgs parses the JSON arguments of a control command, an empty payload has none
+func decodeControlArgs(payload []byte) (controlArgs, error) {
+	var args controlArgs
+	if len(payload) == 0 {
+		return args, nil
+	}
+	if err := json.Unmarshal(payload, &args); err != nil {
+		return args, fmt.Errorf("invalid payload: %w", err)
+	}
+	return args, nil
+}
+
+// reloadConfig re-reads the configuration file and applies the log level and device changes.
+// Other settings are only read at startup, so changes to them are reported as requiring a restart.
+func (app *Application) reloadConfig() (*reloadResult, error) {
+	cfg, err := config.LoadConfig(app.configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &reloadResult{}
+	if !reflect.DeepEqual(cfg.MySensors, app.config.MySensors) {
+		result.Reasons = append(result.Reasons, "mysensors settings changed")
+	}
+	if !reflect.DeepEqual(cfg.MQTT, app.config.MQTT) {
+		result.Reasons = append(result.Reasons, "mqtt settings changed")
+	}
+	if !reflect.DeepEqual(cfg.AdapterTopics, app.config.AdapterTopics) {
+		result.Reasons = append(result.Reasons, "adapter settings changed")
+	}
+
+	if err := events.SetLogLevel(cfg.LogLevel); err != nil {
+		app.logger.Warn("Keeping log level", "error", err)
+	}
+	result.LogLevel = events.LogLevel()
+
+	// Devices no longer in the file keep their subscriptions and discovery until a restart
+	newIDs := make(map[string]bool, len(cfg.Devices))
+	for _, device := range cfg.Devices {
+		newIDs[device.ID] = true
+	}
+	app.discoveryMu.Lock()
+	for id := range app.configuredDevices {
+		if !newIDs[id] {
+			result.Removed = append(result.Removed, id)
+		}
+	}
+	app.discoveryMu.Unlock()
+	sort.Strings(result.Removed)
+	if len(result.Removed) > 0 {
+		result.Reasons = append(result.Reasons, "devices removed")
+	}
+
+	for _, device := range cfg.Devices {
+		app.discoveryMu.Lock()
+		previous, exists := app.configuredDevices[device.ID]
+		if exists && reflect.DeepEqual(previous, device) {
+			app.discoveryMu.Unlock()
+			continue
+		}
+		app.addConfiguredDevice(device)
+		app.discoveryMu.Unlock()
+
+		if err := app.mqttClient.AddDevice(device); err != nil {
+			return nil, fmt.Errorf("failed to publish device %s: %w", device.ID, err)
+		}
+		app.addDevice(device)
+		app.registerDeviceCommandHandlers(device)
+		if exists {
+			result.Updated = append(result.Updated, device.ID)
+		} else {
+			result.Added = append(result.Added, device.ID)
+		}
+	}
+
+	result.RestartRequired = len(result.Reasons) > 0
+	app.logger.Info("Configuration reloaded", "added", len(result.Added), "updated", len(result.Updated),
+		"removed", len(result.Removed), "restart_required", result.RestartRequired)
+	return result, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"ms-mqtt-adapter/internal/events"
+	"ms-mqtt-adapter/internal/mysensors"
+	"ms-mqtt-adapter/pkg/config"
+	"ms-mqtt-adapter/pkg/discovery"
+	"ms-mqtt-adapter/pkg/transport"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runDiscover implements the "discover" subcommand: it listens to the configured gateways for a
+// while and writes a devices section built from the observed presentations and SET traffic
+func runDiscover(args []string) int {
+	flags := flag.NewFlagSet("discover", flag.ExitOnError)
+	configFile := flags.String("config", "config.yaml", "Configuration file path")
+	duration := flags.Duration("duration", 10*time.Minute, "How long to listen for network traffic")
+	output := flags.String("output", "discovered-devices.yaml", "File to write the generated devices section to")
+	requestPresentation := flags.Bool("request-presentation", true, "Ask all nodes to present themselves when connected")
+	flags.Parse(args)
+
+	cfg, err := config.LoadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	logger := events.NewLogger(cfg.LogLevel)
+	logger.Info("Starting network discovery", "duration", *duration, "output", *output)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(ctx, *duration)
+	defer cancelTimeout()
+
+	app := &Application{
+		config: cfg,
+		logger: logger,
+	}
+	if err := app.initializeTransports(); err != nil {
+		logger.Error("Failed to initialize transports", "error", err)
+		return 1
+	}
+
+	// Classification uses the configured overrides, but discovered nodes are not persisted
+	discoverer := discovery.NewDiscoverer(&cfg.AdapterTopics.AutoDiscovery, "", logger)
+
+	var wg sync.WaitGroup
+	for gatewayName, gatewayTransport := range app.transports {
+		wg.Add(1)
+		go func(gName string, t transport.Transport) {
+			defer wg.Done()
+
+			err := app.retryWithBackoff(ctx, fmt.Sprintf("MySensors gateway '%s'", gName), -1, func() error {
+				return t.Connect(ctx)
+			})
+			if err != nil {
+				return
+			}
+			defer t.Disconnect()
+
+			if *requestPresentation {
+				// Broadcast a presentation request so awake nodes announce their children
+				request := mysensors.NewInternalMessage(255, mysensors.I_PRESENTATION, "")
+				if err := t.Send(request); err != nil {
+					logger.Warn("Failed to request presentation", "gateway", gName, "error", err)
+				}
+			}
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case message := <-t.Receive():
+					if discoverer.HandleMessage(gName, message) || discoverer.RecordSet(gName, message) {
+						logger.Info("Observed node", "gateway", gName, "node_id", message.NodeID, "child_id", message.ChildID)
+					}
+				}
+			}
+		}(gatewayName, gatewayTransport)
+	}
+	wg.Wait()
+
+	data, err := renderDiscoveredDevices(discoverer)
+	if err != nil {
+		logger.Error("Failed to render discovered devices", "error", err)
+		return 1
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		logger.Error("Failed to write discovered devices", "error", err)
+		return 1
+	}
+
+	logger.Info("Discovery finished", "nodes", len(discoverer.Nodes()), "output", *output)
+	return 0
+}
+
+// discoveredDevice is the YAML form of a discovered device. It only holds what discovery fills
+// in, so that the output reads like a hand-written devices section.
+type discoveredDevice struct {
+	Name          string             `yaml:"name"`
+	ID            string             `yaml:"id"`
+	NodeID        int                `yaml:"node_id"`
+	Gateway       string             `yaml:"gateway,omitempty"`
+	Manufacturer  string             `yaml:"manufacturer,omitempty"`
+	Model         string             `yaml:"model,omitempty"`
+	SWVersion     string             `yaml:"sw_version,omitempty"`
+	HWVersion     string             `yaml:"hw_version,omitempty"`
+	SuggestedArea string             `yaml:"suggested_area,omitempty"`
+	Entities      []discoveredEntity `yaml:"entities"`
+}
+
+// discoveredEntity is the YAML form of a discovered entity. Defaults like the initial value,
+// unit and state class are left out, LoadConfig fills them in again.
+type discoveredEntity struct {
+	Name         string `yaml:"name"`
+	ID           string `yaml:"id"`
+	ChildID      int    `yaml:"child_id"`
+	EntityType   string `yaml:"entity_type"`
+	VariableType string `yaml:"variable_type,omitempty"`
+	ReadOnly     *bool  `yaml:"read_only,omitempty"`
+	Icon         string `yaml:"icon,omitempty"`
+	DeviceClass  string `yaml:"device_class,omitempty"`
+}
+
+func newDiscoveredDevice(device config.Device) discoveredDevice {
+	discovered := discoveredDevice{
+		Name:          device.Name,
+		ID:            device.ID,
+		NodeID:        device.NodeID,
+		Gateway:       device.Gateway,
+		Manufacturer:  device.Manufacturer,
+		Model:         device.Model,
+		SWVersion:     device.SWVersion,
+		HWVersion:     device.HWVersion,
+		SuggestedArea: device.SuggestedArea,
+	}
+	for _, entity := range device.Entities {
+		discovered.Entities = append(discovered.Entities, discoveredEntity{
+			Name:         entity.Name,
+			ID:           entity.ID,
+			ChildID:      entity.ChildID,
+			EntityType:   entity.EntityType,
+			VariableType: entity.VariableType,
+			ReadOnly:     entity.ReadOnly,
+			Icon:         entity.Icon,
+			DeviceClass:  entity.DeviceClass,
+		})
+	}
+	return discovered
+}
+
+// renderDiscoveredDevices builds a devices section in the schema read by config.LoadConfig.
+// Children that could not be classified are emitted as generic sensors with a TODO comment.
+func renderDiscoveredDevices(discoverer *discovery.Discoverer) ([]byte, error) {
+	var devices []discoveredDevice
+	comments := make(map[string]string) // "deviceID/entityID" -> comment
+
+	for _, node := range discoverer.Nodes() {
+		device, unclassified := discoverer.Draft(node)
+		if device.ID == "" {
+			continue
+		}
+
+		for _, child := range unclassified {
+			readOnly := true
+			entity := config.Entity{
+				Name:       fmt.Sprintf("Child %d", child.ChildID),
+				ID:         fmt.Sprintf("child_%d", child.ChildID),
+				ChildID:    child.ChildID,
+				EntityType: "sensor",
+				ReadOnly:   &readOnly,
+			}
+			if child.Description != "" {
+				entity.Name = child.Description
+			}
+
+			var seen []string
+			for _, varType := range child.VariableTypes {
+				seen = append(seen, varType.String())
+			}
+			if len(child.VariableTypes) > 0 {
+				entity.VariableType = child.VariableTypes[0].String()
+			}
+
+			comment := "TODO: could not classify this child"
+			if !child.Unpresented {
+				comment += fmt.Sprintf(", presented as %s", child.SensorType.String())
+			}
+			if len(seen) > 0 {
+				comment += fmt.Sprintf(", seen variables %s", strings.Join(seen, ", "))
+			}
+			comments[device.ID+"/"+entity.ID] = comment
+			device.Entities = append(device.Entities, entity)
+		}
+
+		if len(device.Entities) == 0 {
+			continue
+		}
+		devices = append(devices, newDiscoveredDevice(device))
+	}
+
+	var document yaml.Node
+	if err := document.Encode(struct {
+		Devices []discoveredDevice `yaml:"devices"`
+	}{Devices: devices}); err != nil {
+		return nil, fmt.Errorf("failed to encode devices: %w", err)
+	}
+	annotateEntities(&document, comments)
+	document.HeadComment = "Generated by ms-mqtt-adapter discover. Review names, icons and entity types before use."
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, fmt.Errorf("failed to marshal devices: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to marshal devices: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// annotateEntities attaches comments to entity nodes of an encoded devices document
+func annotateEntities(document *yaml.Node, comments map[string]string) {
+	devicesNode := mappingValue(document, "devices")
+	if devicesNode == nil {
+		return
+	}
+	for _, deviceNode := range devicesNode.Content {
+		deviceID := scalarValue(mappingValue(deviceNode, "id"))
+		entitiesNode := mappingValue(deviceNode, "entities")
+		if entitiesNode == nil {
+			continue
+		}
+		for _, entityNode := range entitiesNode.Content {
+			entityID := scalarValue(mappingValue(entityNode, "id"))
+			if comment, exists := comments[deviceID+"/"+entityID]; exists {
+				entityNode.HeadComment = comment
+			}
+		}
+	}
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func scalarValue(node *yaml.Node) string {
+	if node == nil {
+		return ""
+	}
+	return node.Value
+}
@@ -2,25 +2,36 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log/slog"
 	"math"
+	"math/rand"
 	"ms-mqtt-adapter/internal/events"
 	"ms-mqtt-adapter/internal/mysensors"
+	"ms-mqtt-adapter/pkg/command"
 	"ms-mqtt-adapter/pkg/config"
+	"ms-mqtt-adapter/pkg/cover"
+	"ms-mqtt-adapter/pkg/discovery"
+	"ms-mqtt-adapter/pkg/firmware"
 	"ms-mqtt-adapter/pkg/gateway"
 	"ms-mqtt-adapter/pkg/mqtt"
 	"ms-mqtt-adapter/pkg/tcp"
 	"ms-mqtt-adapter/pkg/transport"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "discover" {
+		os.Exit(runDiscover(os.Args[2:]))
+	}
+
 	configFile := flag.String("config", "config.yaml", "Configuration file path")
 	flag.Parse()
 
@@ -37,8 +48,9 @@ func main() {
 	defer cancel()
 
 	app := &Application{
-		config: cfg,
-		logger: logger,
+		config:     cfg,
+		configFile: *configFile,
+		logger:     logger,
 	}
 
 	if err := app.Run(ctx); err != nil {
@@ -50,14 +62,28 @@ func main() {
 }
 
 type Application struct {
-	config     *config.Config
-	logger     *slog.Logger
-	transports map[string]transport.Transport  // gatewayName -> transport
-	mqttClient *mqtt.Client
-	tcpServers map[string]*tcp.Server          // gatewayName -> tcpServer
-	gateways   map[string]*gateway.Gateway     // gatewayName -> gateway
-	syncMgr    *events.SyncManager
-	
+	config       *config.Config
+	configFile   string // Re-read by the reload_config control command
+	logger       *slog.Logger
+	transports   map[string]transport.Transport // gatewayName -> transport
+	mqttClient   *mqtt.Client
+	tcpServers   map[string]*tcp.Server      // gatewayName -> tcpServer
+	gateways     map[string]*gateway.Gateway // gatewayName -> gateway
+	syncMgr      *events.SyncManager
+	availability *events.AvailabilityMonitor
+	commands     *command.Tracker
+	firmware     *firmware.Server
+	covers       *cover.Manager
+
+	// Devices from configuration plus auto-discovered devices
+	devicesMu         sync.RWMutex
+	configuredNodes   map[string]bool          // "gateway:nodeID" of nodes defined in YAML
+	configuredDevices map[string]config.Device // Devices defined in YAML by ID
+	discoverer        *discovery.Discoverer
+	discoveryTimers   map[string]*time.Timer
+	republishTimer    *time.Timer // Pending republish after a Home Assistant birth message
+	discoveryMu       sync.Mutex
+
 	// Connection retry management
 	transportRetryCount map[string]int
 	mqttRetryCount      int
@@ -164,6 +190,28 @@ func (app *Application) getDefaultGateway() *gateway.Gateway {
 	return nil
 }
 
+// getDevices returns a snapshot of configured and auto-discovered devices
+func (app *Application) getDevices() []config.Device {
+	app.devicesMu.RLock()
+	defer app.devicesMu.RUnlock()
+	devices := make([]config.Device, len(app.config.Devices))
+	copy(devices, app.config.Devices)
+	return devices
+}
+
+// addDevice adds or replaces a device by ID
+func (app *Application) addDevice(device config.Device) {
+	app.devicesMu.Lock()
+	defer app.devicesMu.Unlock()
+	for i := range app.config.Devices {
+		if app.config.Devices[i].ID == device.ID {
+			app.config.Devices[i] = device
+			return
+		}
+	}
+	app.config.Devices = append(app.config.Devices, device)
+}
+
 func (app *Application) Run(ctx context.Context) error {
 	// Initialize retry counters
 	app.transportRetryCount = make(map[string]int)
@@ -173,6 +221,10 @@ func (app *Application) Run(ctx context.Context) error {
 		return fmt.Errorf("failed to initialize transports: %w", err)
 	}
 
+	if err := app.initializeDiscovery(); err != nil {
+		return fmt.Errorf("failed to initialize auto-discovery: %w", err)
+	}
+
 	if err := app.initializeMQTT(); err != nil {
 		return fmt.Errorf("failed to initialize MQTT: %w", err)
 	}
@@ -189,6 +241,18 @@ func (app *Application) Run(ctx context.Context) error {
 		return fmt.Errorf("failed to initialize sync manager: %w", err)
 	}
 
+	app.initializeAvailability()
+
+	app.initializeCommandTracker()
+
+	if err := app.initializeCovers(); err != nil {
+		return fmt.Errorf("failed to initialize relay covers: %w", err)
+	}
+
+	if err := app.initializeFirmware(); err != nil {
+		return fmt.Errorf("failed to initialize firmware server: %w", err)
+	}
+
 	if err := app.startWithRetry(ctx); err != nil {
 		return fmt.Errorf("failed to start application: %w", err)
 	}
@@ -200,12 +264,25 @@ func (app *Application) Run(ctx context.Context) error {
 		return fmt.Errorf("failed to publish discovery: %w", err)
 	}
 
-	// Perform initial sync if sync is enabled
-	if app.config.AdapterTopics.Sync.Enabled {
-		app.logger.Info("Performing initial device state sync")
-		app.syncMgr.SyncDeviceStates()
+	if app.firmware != nil {
+		topic := fmt.Sprintf("%s/firmware/set", app.config.AdapterTopics.TopicPrefix)
+		if err := app.mqttClient.Subscribe(topic, app.handleFirmwareCommand); err != nil {
+			return fmt.Errorf("failed to subscribe to firmware commands: %w", err)
+		}
+	}
+
+	app.registerControlCommands()
+	if err := app.mqttClient.StartControl(); err != nil {
+		return fmt.Errorf("failed to subscribe to control commands: %w", err)
 	}
 
+	// Publish device availability once discovery configs reference it
+	app.availability.Start(ctx)
+
+	// Perform initial sync on gateways with sync enabled
+	app.logger.Info("Performing initial device state sync")
+	app.syncMgr.SyncDeviceStates()
+
 	// Send initial version request to all gateways
 	app.logger.Info("Sending initial version requests to gateways")
 	for gatewayName, gw := range app.gateways {
@@ -254,8 +331,95 @@ func (app *Application) initializeTransports() error {
 	return nil
 }
 
+func (app *Application) initializeDiscovery() error {
+	app.configuredNodes = make(map[string]bool)
+	app.configuredDevices = make(map[string]config.Device)
+	for _, device := range app.config.Devices {
+		app.addConfiguredDevice(device)
+	}
+
+	if !app.config.AdapterTopics.AutoDiscovery.Enabled {
+		return nil
+	}
+
+	app.discoverer = discovery.NewDiscoverer(&app.config.AdapterTopics.AutoDiscovery, app.config.AdapterTopics.DataDir, app.logger)
+	app.discoveryTimers = make(map[string]*time.Timer)
+	if err := app.discoverer.Load(); err != nil {
+		return err
+	}
+
+	// Restore previously discovered devices so they work before nodes present themselves again
+	for _, device := range app.discoverer.Devices() {
+		if app.configuredNodes[fmt.Sprintf("%s:%d", device.Gateway, device.NodeID)] {
+			continue
+		}
+		app.addDevice(device)
+		app.logger.Info("Restored auto-discovered device", "device", device.Name, "gateway", device.Gateway, "node_id", device.NodeID)
+	}
+	return nil
+}
+
+// addConfiguredDevice records a device defined in YAML so that auto-discovery leaves its nodes alone
+func (app *Application) addConfiguredDevice(device config.Device) {
+	app.configuredDevices[device.ID] = device
+	for _, entity := range device.Entities {
+		nodeID := device.NodeID
+		if entity.NodeID != nil {
+			nodeID = *entity.NodeID
+		}
+		gatewayName := app.config.GetEffectiveGateway(device.Gateway, entity.Gateway)
+		app.configuredNodes[fmt.Sprintf("%s:%d", gatewayName, nodeID)] = true
+	}
+}
+
+// scheduleDiscoveredDevice publishes a discovered node once its presentation burst is over
+func (app *Application) scheduleDiscoveredDevice(gatewayName string, nodeID int) {
+	key := fmt.Sprintf("%s:%d", gatewayName, nodeID)
+
+	app.discoveryMu.Lock()
+	defer app.discoveryMu.Unlock()
+
+	if app.configuredNodes[key] {
+		return
+	}
+
+	if timer, exists := app.discoveryTimers[key]; exists {
+		timer.Stop()
+	}
+	app.discoveryTimers[key] = time.AfterFunc(2*time.Second, func() {
+		app.discoveryMu.Lock()
+		delete(app.discoveryTimers, key)
+		app.discoveryMu.Unlock()
+
+		app.publishDiscoveredDevice(gatewayName, nodeID)
+	})
+}
+
+func (app *Application) publishDiscoveredDevice(gatewayName string, nodeID int) {
+	device, ok := app.discoverer.Device(gatewayName, nodeID)
+	if !ok {
+		app.logger.Debug("Discovered node has no usable children yet", "gateway", gatewayName, "node_id", nodeID)
+		return
+	}
+
+	// The client rejects devices whose topics collide with those of another device
+	if err := app.mqttClient.AddDevice(device); err != nil {
+		app.logger.Error("Failed to publish auto-discovered device", "device", device.Name, "error", err)
+		return
+	}
+	app.addDevice(device)
+	app.registerDeviceCommandHandlers(device)
+
+	app.logger.Info("Published auto-discovered device", "device", device.Name, "gateway", gatewayName,
+		"node_id", nodeID, "entities", len(device.Entities))
+}
+
 func (app *Application) initializeMQTT() error {
-	app.mqttClient = mqtt.NewClient(&app.config.MQTT, &app.config.AdapterTopics, app.config.Devices, app.logger)
+	mqttClient, err := mqtt.NewClient(&app.config.MQTT, &app.config.AdapterTopics, app.getDevices(), app.logger)
+	if err != nil {
+		return err
+	}
+	app.mqttClient = mqttClient
 	return nil
 }
 
@@ -284,23 +448,252 @@ func (app *Application) initializeGateways() error {
 			NodeIDRange:            gatewayConfig.Gateway.NodeIDRange,
 			VersionRequestPeriod:   gatewayConfig.Gateway.VersionRequestPeriod,
 			RandomIDAssignment:     gatewayConfig.Gateway.RandomIDAssignment,
+			NodeRegistry:           gatewayConfig.Gateway.NodeRegistry,
+			SmartSleep:             gatewayConfig.Gateway.SmartSleep,
+		}
+
+		// Load the node registry before any ID request can be answered
+		var registry *gateway.NodeRegistry
+		if gatewayConf.NodeRegistry.Enabled != nil && *gatewayConf.NodeRegistry.Enabled {
+			registry = gateway.NewNodeRegistry(app.config.AdapterTopics.DataDir, gatewayName, app.logger)
+			if err := registry.Load(); err != nil {
+				return fmt.Errorf("failed to load node registry for gateway %s: %w", gatewayName, err)
+			}
+		}
+
+		gw := gateway.NewGateway(gatewayConf, registry, gatewayTransport, app.logger)
+		app.gateways[gatewayName] = gw
+
+		if sleepQueue := gw.SleepQueue(); sleepQueue != nil {
+			name := gatewayName
+			sleepQueue.SetChangeHandler(func(nodes []gateway.SleepingNode) {
+				depth := 0
+				for _, node := range nodes {
+					depth += len(node.Pending)
+				}
+				status := map[string]interface{}{
+					"depth": depth,
+					"nodes": nodes,
+				}
+				if err := app.mqttClient.PublishGatewaySleepQueue(app.config.AdapterTopics.TopicPrefix, name, status); err != nil {
+					app.logger.Error("Failed to publish sleep queue", "gateway", name, "error", err)
+				}
+			})
+		}
+
+		if registry != nil && gatewayConf.NodeRegistry.PublishMQTT {
+			name := gatewayName
+			registry.SetChangeHandler(func(nodes []gateway.NodeRecord) {
+				if err := app.mqttClient.PublishGatewayNodeRegistry(app.config.AdapterTopics.TopicPrefix, name, nodes); err != nil {
+					app.logger.Error("Failed to publish node registry", "gateway", name, "error", err)
+				}
+			})
 		}
-		
-		app.gateways[gatewayName] = gateway.NewGateway(gatewayConf, gatewayTransport, app.logger)
 	}
 	return nil
 }
 
 func (app *Application) initializeSyncManager() error {
-	// Use default transport for sync manager
-	defaultTransport := app.getDefaultTransport()
-	if defaultTransport == nil {
-		return fmt.Errorf("no transport available for sync manager")
+	if len(app.gateways) == 0 {
+		return fmt.Errorf("no gateway available for sync manager")
+	}
+	app.syncMgr = events.NewSyncManager(app.config, app.getDevices, app.mqttClient, app.gateways, app.logger)
+	return nil
+}
+
+// initializeAvailability sets up tracking of when the nodes of entities were last seen
+func (app *Application) initializeAvailability() {
+	app.availability = events.NewAvailabilityMonitor(app.config, app.getDevices, app.gateways, app.mqttClient, app.logger)
+}
+
+// initializeCommandTracker sets up confirmation of commands sent with the ACK bit
+func (app *Application) initializeCommandTracker() {
+	trackingConfig := &app.config.AdapterTopics.CommandTracking
+	if trackingConfig.Enabled != nil && !*trackingConfig.Enabled {
+		app.logger.Info("Command tracking disabled")
+		return
+	}
+	app.commands = command.NewTracker(trackingConfig, app.logger, app.handleCommandResult)
+}
+
+// initializeCovers loads the estimated positions of relay_cover entities
+func (app *Application) initializeCovers() error {
+	app.covers = cover.NewManager(app.config.AdapterTopics.DataDir, app.logger)
+	return app.covers.Load()
+}
+
+// handleCommandResult publishes the delivery status of a command and, for non-optimistic
+// entities, restores the last confirmed state when the node never acknowledged it
+func (app *Application) handleCommandResult(cmd command.Command, status command.Status) {
+	if relay, ok := cmd.Context.(*relayCommand); ok {
+		app.handleRelayCommandResult(cmd, status, relay)
+		return
+	}
+
+	result := map[string]interface{}{
+		"status":   status,
+		"gateway":  cmd.Gateway,
+		"payload":  cmd.Message.Payload,
+		"attempts": cmd.Attempts,
+	}
+	if err := app.mqttClient.PublishCommandResult(cmd.DeviceID, cmd.EntityID, result); err != nil {
+		app.logger.Error("Failed to publish command result", "device", cmd.DeviceID, "entity", cmd.EntityID, "error", err)
+	}
+
+	// Commands for entities with several variables carry the MySensors value of one attribute
+	device, entity, found := app.findEntity(cmd.DeviceID, cmd.EntityID)
+	attribute, isAttribute := entity.AttributeForVariable(cmd.Message.GetVariableType())
+	state := cmd.Message.Payload
+	if isAttribute {
+		state = attribute.DecodeCommand(cmd.Message.GetVariableType(), state)
+	}
+
+	// Answer MQTT 5 commands that asked for a response
+	var response mqtt.CommandResponse
+	switch status {
+	case command.StatusConfirmed:
+		response = mqtt.CommandResponse{Status: "confirmed", State: state}
+	case command.StatusSuperseded:
+		response = mqtt.CommandResponse{Status: "superseded", State: state}
+	case command.StatusFailed:
+		response = mqtt.CommandResponse{Status: "failed", Error: fmt.Sprintf("node did not confirm the command after %d attempts", cmd.Attempts)}
+	}
+	if target, ok := cmd.Context.(*mqtt.ResponseTarget); ok && response.Status != "" {
+		if err := app.mqttClient.RespondToCommand(cmd.DeviceID, cmd.EntityID, target, response); err != nil {
+			app.logger.Error("Failed to publish command response", "device", cmd.DeviceID, "entity", cmd.EntityID, "error", err)
+		}
+	}
+
+	if status != command.StatusFailed || app.mqttClient.IsOptimistic(cmd.DeviceID, cmd.EntityID) || !found {
+		return
+	}
+
+	if isAttribute {
+		attributeKey := fmt.Sprintf("%s_%s_entity_%s", device.ID, entity.ID, attribute.Name)
+		if state, exists := app.mqttClient.GetState(attributeKey); exists {
+			app.logger.Info("Restoring last confirmed state after failed command", "device", device.Name, "entity", entity.Name, "attribute", attribute.Name, "state", state)
+			if err := app.mqttClient.PublishAttributeState(device, entity, attribute.Name, state); err != nil {
+				app.logger.Error("Failed to restore entity state", "device", device.Name, "entity", entity.Name, "error", err)
+			}
+		}
+		return
+	}
+
+	compositeKey := fmt.Sprintf("%s_%s_entity", device.ID, entity.ID)
+	if state, exists := app.mqttClient.GetState(compositeKey); exists {
+		app.logger.Info("Restoring last confirmed state after failed command", "device", device.Name, "entity", entity.Name, "state", state)
+		if err := app.mqttClient.PublishEntityState(device, entity, state); err != nil {
+			app.logger.Error("Failed to restore entity state", "device", device.Name, "entity", entity.Name, "error", err)
+		}
 	}
-	app.syncMgr = events.NewSyncManager(app.config, app.mqttClient, defaultTransport, app.logger)
+}
+
+// handleRelayCommandResult publishes the delivery status of a relay switch of a relay_cover entity
+// and passes the outcome to the cover manager
+func (app *Application) handleRelayCommandResult(cmd command.Command, status command.Status, relay *relayCommand) {
+	result := map[string]interface{}{
+		"status":   status,
+		"gateway":  cmd.Gateway,
+		"child_id": cmd.Message.ChildID,
+		"payload":  cmd.Message.Payload,
+		"attempts": cmd.Attempts,
+	}
+	if err := app.mqttClient.PublishRelayCommandResult(cmd.DeviceID, cmd.EntityID, cmd.Message.ChildID, result); err != nil {
+		app.logger.Error("Failed to publish command result", "device", cmd.DeviceID, "entity", cmd.EntityID, "error", err)
+	}
+
+	// Superseded results arrive while the manager switches the newer relay command, so the manager
+	// is called from its own goroutine. It only acts on the last switch of each relay.
+	switch status {
+	case command.StatusConfirmed:
+		go app.covers.RelayConfirmed(relay.cover, relay.id)
+	case command.StatusFailed, command.StatusSuperseded:
+		go app.covers.RelayFailed(relay.cover, relay.id)
+	}
+}
+
+// findEntity looks up a device and entity by their IDs
+func (app *Application) findEntity(deviceID, entityID string) (config.Device, config.Entity, bool) {
+	for _, device := range app.getDevices() {
+		if device.ID != deviceID {
+			continue
+		}
+		for _, entity := range device.Entities {
+			if entity.ID == entityID {
+				return device, entity, true
+			}
+		}
+	}
+	return config.Device{}, config.Entity{}, false
+}
+
+// initializeFirmware loads OTA firmware images and assignments when enabled
+func (app *Application) initializeFirmware() error {
+	firmwareConfig := &app.config.AdapterTopics.Firmware
+	if !firmwareConfig.Enabled {
+		return nil
+	}
+
+	app.firmware = firmware.NewServer(firmwareConfig, app.config.AdapterTopics.DataDir, app.logger)
+	if err := app.firmware.Load(); err != nil {
+		return err
+	}
+
+	app.firmware.SetStatusHandler(func(status firmware.NodeStatus) {
+		if err := app.mqttClient.PublishFirmwareStatus(app.config.AdapterTopics.TopicPrefix, status.Gateway, status.NodeID, status); err != nil {
+			app.logger.Error("Failed to publish firmware status", "gateway", status.Gateway, "node_id", status.NodeID, "error", err)
+		}
+	})
 	return nil
 }
 
+// firmwareCommand is the payload of <prefix>/firmware/set
+type firmwareCommand struct {
+	Gateway string `json:"gateway"`
+	NodeID  int    `json:"node_id"`
+	Type    uint16 `json:"type"`
+	Version uint16 `json:"version"`
+	Reboot  bool   `json:"reboot"` // Reboot the node so it fetches the firmware right away
+	Reload  bool   `json:"reload"` // Re-read the firmware directory before assigning
+}
+
+func (app *Application) handleFirmwareCommand(topic, payload string) {
+	var cmd firmwareCommand
+	if err := json.Unmarshal([]byte(payload), &cmd); err != nil {
+		app.logger.Warn("Invalid firmware command", "topic", topic, "error", err)
+		return
+	}
+
+	if cmd.Reload {
+		if err := app.firmware.LoadImages(); err != nil {
+			app.logger.Error("Failed to reload firmware directory", "error", err)
+			return
+		}
+	}
+	if cmd.NodeID == 0 {
+		return
+	}
+
+	gatewayName := app.config.GetEffectiveGateway(cmd.Gateway, "")
+	gw, exists := app.gateways[gatewayName]
+	if !exists {
+		app.logger.Warn("Firmware command for unknown gateway", "gateway", gatewayName)
+		return
+	}
+
+	if err := app.firmware.Assign(gatewayName, cmd.NodeID, cmd.Type, cmd.Version); err != nil {
+		app.logger.Error("Failed to assign firmware", "gateway", gatewayName, "node_id", cmd.NodeID, "error", err)
+		return
+	}
+
+	if cmd.Reboot {
+		reboot := mysensors.NewInternalMessage(cmd.NodeID, mysensors.I_REBOOT, "")
+		if err := gw.Send(reboot); err != nil {
+			app.logger.Error("Failed to reboot node", "gateway", gatewayName, "node_id", cmd.NodeID, "error", err)
+		}
+	}
+}
+
 func (app *Application) startWithRetry(ctx context.Context) error {
 	// Start connection attempts concurrently
 	var wg sync.WaitGroup
@@ -366,6 +759,29 @@ func (app *Application) startWithRetry(ctx context.Context) error {
 		return fmt.Errorf("failed to start sync manager: %w", err)
 	}
 	
+	for gatewayName := range app.transports {
+		app.publishGatewayAvailability(gatewayName, true)
+	}
+
+	app.mqttClient.SetReconnectHandler(app.handleMQTTReconnect)
+
+	if *app.config.AdapterTopics.HomeAssistantDiscovery {
+		if err := app.mqttClient.Subscribe(app.config.AdapterTopics.HomeAssistantStatusTopic, app.handleHomeAssistantStatus); err != nil {
+			return fmt.Errorf("failed to subscribe to Home Assistant status: %w", err)
+		}
+	}
+
+	// Accept raw messages in the MySensors MQTT gateway format
+	for gatewayName, gatewayConfig := range app.config.MySensors {
+		if !gatewayConfig.RawTopics.Enabled {
+			continue
+		}
+		if err := app.mqttClient.Subscribe(gatewayConfig.RawTopics.InPrefix+"/#", app.rawMessageHandler(gatewayName, gatewayConfig.RawTopics.InPrefix)); err != nil {
+			return fmt.Errorf("failed to subscribe to raw topics for gateway %s: %w", gatewayName, err)
+		}
+		app.logger.Info("Raw MySensors topics enabled", "gateway", gatewayName, "out", gatewayConfig.RawTopics.OutPrefix, "in", gatewayConfig.RawTopics.InPrefix)
+	}
+
 	// Start connection monitoring and auto-reconnection
 	app.startConnectionMonitoring(ctx)
 	
@@ -373,6 +789,55 @@ func (app *Application) startWithRetry(ctx context.Context) error {
 	return nil
 }
 
+// handleMQTTReconnect republishes everything a broker without our session may have lost
+func (app *Application) handleMQTTReconnect() {
+	app.republishDiscovery()
+}
+
+// handleHomeAssistantStatus republishes discovery and states when Home Assistant comes online.
+// The republish is delayed by a random few seconds so that many integrations restarting
+// together do not flood Home Assistant, and repeated birth messages are coalesced.
+func (app *Application) handleHomeAssistantStatus(topic, payload string) {
+	if payload != "online" {
+		return
+	}
+
+	delay := time.Second + time.Duration(rand.Int63n(int64(4*time.Second)))
+	app.logger.Info("Home Assistant came online, scheduling republish", "delay", delay)
+
+	app.discoveryMu.Lock()
+	defer app.discoveryMu.Unlock()
+	if app.republishTimer != nil {
+		app.republishTimer.Stop()
+	}
+	app.republishTimer = time.AfterFunc(delay, func() {
+		app.republishDiscovery()
+		app.mqttClient.RepublishStates()
+	})
+}
+
+// republishDiscovery publishes discovery configs and availability topics again
+func (app *Application) republishDiscovery() {
+	if err := app.publishDiscovery(); err != nil {
+		app.logger.Error("Failed to republish discovery", "error", err)
+	}
+
+	for gatewayName, gatewayTransport := range app.transports {
+		app.publishGatewayAvailability(gatewayName, gatewayTransport.IsConnected())
+	}
+
+	if app.availability != nil {
+		app.availability.Republish()
+	}
+}
+
+// publishGatewayAvailability marks the entities behind a gateway available or unavailable
+func (app *Application) publishGatewayAvailability(gatewayName string, online bool) {
+	if err := app.mqttClient.PublishGatewayAvailability(gatewayName, online); err != nil {
+		app.logger.Error("Failed to publish gateway availability", "gateway", gatewayName, "error", err)
+	}
+}
+
 func (app *Application) startConnectionMonitoring(ctx context.Context) {
 	// Monitor MySensors transport connections
 	for gatewayName, gatewayTransport := range app.transports {
@@ -387,6 +852,7 @@ func (app *Application) startConnectionMonitoring(ctx context.Context) {
 				case <-ticker.C:
 					if !transport.IsConnected() {
 						app.logger.Info("MySensors gateway disconnected, attempting reconnection", "gateway", name)
+						app.publishGatewayAvailability(name, false)
 						
 						// Attempt reconnection with retry
 						err := app.retryWithBackoff(ctx, fmt.Sprintf("MySensors gateway '%s' reconnection", name), -1, func() error {
@@ -397,6 +863,7 @@ func (app *Application) startConnectionMonitoring(ctx context.Context) {
 							app.logger.Error("Failed to reconnect MySensors gateway", "gateway", name, "error", err)
 						} else {
 							app.logger.Info("MySensors gateway reconnected successfully", "gateway", name)
+							app.publishGatewayAvailability(name, true)
 						}
 					}
 				}
@@ -414,6 +881,10 @@ func (app *Application) startConnectionMonitoring(ctx context.Context) {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
+				// Messages are only buffered while the broker is unreachable
+				if stats := app.mqttClient.QueueStats(); stats.Depth > 0 {
+					app.logger.Warn("MQTT broker unreachable, buffering messages", "depth", stats.Depth, "dropped", stats.Dropped)
+				}
 				if !app.mqttClient.IsConnected() {
 					app.logger.Info("MQTT broker disconnected, attempting reconnection")
 					
@@ -434,7 +905,7 @@ func (app *Application) startConnectionMonitoring(ctx context.Context) {
 }
 
 func (app *Application) publishDiscovery() error {
-	for _, device := range app.config.Devices {
+	for _, device := range app.getDevices() {
 		if err := app.mqttClient.PublishHomeAssistantDiscovery(device); err != nil {
 			return fmt.Errorf("failed to publish discovery for device %s: %w", device.Name, err)
 		}
@@ -455,6 +926,13 @@ func (app *Application) publishDiscovery() error {
 		if err := app.mqttClient.PublishGatewayAdapterStatus(app.config.AdapterTopics.TopicPrefix, gatewayName, gatewaySeenNodes); err != nil {
 			return fmt.Errorf("failed to publish gateway adapter status for %s: %w", gatewayName, err)
 		}
+
+		// Publish the persistent node registry if mirroring is enabled
+		if registry := gateway.Registry(); registry != nil && app.config.MySensors[gatewayName].Gateway.NodeRegistry.PublishMQTT {
+			if err := app.mqttClient.PublishGatewayNodeRegistry(app.config.AdapterTopics.TopicPrefix, gatewayName, registry.Nodes()); err != nil {
+				return fmt.Errorf("failed to publish node registry for %s: %w", gatewayName, err)
+			}
+		}
 	}
 	
 	// Convert combined map to slice
@@ -483,6 +961,13 @@ func (app *Application) handleMySensorsMessages() {
 					tcpServer.BroadcastMessage(message)
 				}
 
+				// Mirror to the MySensors MQTT gateway topics
+				if rawTopics := app.config.MySensors[gName].RawTopics; rawTopics.Enabled {
+					if err := app.mqttClient.PublishRawMessage(rawTopics.OutPrefix, message); err != nil {
+						app.logger.Error("Failed to publish raw message", "gateway", gName, "error", err)
+					}
+				}
+
 				// Handle message with corresponding gateway
 				if gateway, exists := app.gateways[gName]; exists {
 					if err := gateway.HandleMessage(message); err != nil {
@@ -490,7 +975,29 @@ func (app *Application) handleMySensorsMessages() {
 					}
 				}
 
-				app.handleDeviceMessage(message)
+				// Any message from a node proves it is alive
+				if app.availability != nil {
+					app.availability.NodeSeen(gName, message.NodeID)
+				}
+
+				// Serve OTA firmware requests
+				if app.firmware != nil {
+					if err := app.firmware.HandleMessage(gName, t, message); err != nil {
+						app.logger.Error("Firmware request failed", "gateway", gName, "error", err, "message", message.String())
+					}
+				}
+
+				// Confirm pending commands with their echo
+				if app.commands != nil {
+					app.commands.HandleMessage(gName, message)
+				}
+
+				// Build devices from presentations when auto-discovery is enabled
+				if app.discoverer != nil && app.discoverer.HandleMessage(gName, message) {
+					app.scheduleDiscoveredDevice(gName, message.NodeID)
+				}
+
+				app.handleDeviceMessage(gName, message)
 
 				// Publish gateway-specific status and combined status
 				if gateway, exists := app.gateways[gName]; exists {
@@ -530,8 +1037,8 @@ func (app *Application) handleTCPMessages() {
 	for gatewayName, tcpServer := range app.tcpServers {
 		go func(gName string, server *tcp.Server) {
 			for message := range server.Receive() {
-				if gatewayTransport, exists := app.transports[gName]; exists {
-					if err := gatewayTransport.Send(message); err != nil {
+				if gw, exists := app.gateways[gName]; exists {
+					if err := gw.Send(message); err != nil {
 						app.logger.Error("Failed to forward TCP message to MySensors", "gateway", gName, "error", err, "message", message.String())
 					}
 				}
@@ -540,74 +1047,272 @@ func (app *Application) handleTCPMessages() {
 	}
 }
 
+// rawMessageHandler forwards messages published in the MySensors MQTT gateway format to a gateway
+func (app *Application) rawMessageHandler(gatewayName, inPrefix string) mqtt.MessageHandler {
+	return func(topic, payload string) {
+		message, err := mysensors.ParseMQTTMessage(inPrefix, topic, payload)
+		if err != nil {
+			app.logger.Warn("Invalid raw MySensors message", "gateway", gatewayName, "topic", topic, "error", err)
+			return
+		}
+
+		gw, exists := app.gateways[gatewayName]
+		if !exists {
+			return
+		}
+		if err := gw.Send(message); err != nil {
+			app.logger.Error("Failed to forward raw message to MySensors", "gateway", gatewayName, "error", err, "message", message.String())
+		}
+	}
+}
+
 func (app *Application) handleMQTTStateChanges() {
-	for _, device := range app.config.Devices {
-		// Handle entities that can receive commands
-		for _, entity := range device.Entities {
-			// Only register handlers for entities that can receive commands
-			if !entity.CanReceiveCommands() {
-				continue
-			}
-			
-			// Create local copies to avoid closure issues
-			currentDevice := device
-			currentEntity := entity
-			
-			// Create composite key for uniqueness across devices
-			compositeKey := fmt.Sprintf("%s_%s_entity", device.ID, entity.ID)
-			app.mqttClient.RegisterStateChangeHandler(compositeKey, func(deviceName, componentName string, state string) {
-				app.logger.Info("MQTT entity command received", "device", deviceName, "entity", componentName, "state", state)
-				
-				nodeID := currentDevice.NodeID
-				if currentEntity.NodeID != nil {
-					nodeID = *currentEntity.NodeID
-				}
+	for _, device := range app.getDevices() {
+		app.registerDeviceCommandHandlers(device)
+	}
+}
 
-				// Determine which gateway to use
-				gatewayName := "default"
-				if currentDevice.Gateway != "" {
-					gatewayName = currentDevice.Gateway
-				}
-				
-				gatewayTransport, exists := app.transports[gatewayName]
-				if !exists {
-					app.logger.Error("No transport found for gateway", "gateway", gatewayName, "device", deviceName)
-					return
-				}
+// registerDeviceCommandHandlers registers MQTT command handlers for all entities of a device
+func (app *Application) registerDeviceCommandHandlers(device config.Device) {
+	// Handle entities that can receive commands
+	for _, entity := range device.Entities {
+		// Only register handlers for entities that can receive commands
+		if !entity.CanReceiveCommands() {
+			continue
+		}
+		
+		// Create local copies to avoid closure issues
+		currentDevice := device
+		currentEntity := entity
 
-				// Get the MySensors variable type for this entity
-				varType, exists := config.GetMySensorsVariableTypeForEntity(currentEntity.EntityType, currentEntity.VariableType)
-				if !exists {
-					app.logger.Error("Unknown variable type for entity", "entity", componentName, "entityType", currentEntity.EntityType)
-					return
-				}
+		// Relay covers are moved by the cover manager
+		if entity.EntityType == "relay_cover" {
+			app.registerRelayCover(currentDevice, currentEntity)
+			continue
+		}
 
-				// Use configured ACK bit setting (priority: device > global > default true)
-				requestAck := app.config.GetEffectiveRequestAck(&currentDevice)
-				message := mysensors.NewSetMessageWithAck(nodeID, currentEntity.ChildID, varType, state, requestAck)
-				
-				app.logger.Info("Sending MySensors entity command", "gateway", gatewayName, "message", message.String())
-				
-				if err := gatewayTransport.Send(message); err != nil {
-					app.logger.Error("Failed to send entity state change to MySensors", "gateway", gatewayName, "error", err,
-						"device", deviceName, "entity", componentName, "state", state)
-				} else {
-					app.logger.Info("MySensors entity command sent successfully", "gateway", gatewayName, "device", deviceName, "entity", componentName, 
-						"node_id", nodeID, "child_id", currentEntity.ChildID, "state", state, "message", message.String())
+		// Entities with several variables take commands per attribute
+		if attributes := entity.Attributes(); attributes != nil {
+			for _, attribute := range attributes {
+				if !attribute.Command {
+					continue
 				}
-			})
+				currentAttribute := attribute
+				attributeKey := fmt.Sprintf("%s_%s_entity_%s", device.ID, entity.ID, attribute.Name)
+				app.mqttClient.RegisterStateChangeHandler(attributeKey, func(deviceName, componentName string, state string, response *mqtt.ResponseTarget) {
+					app.logger.Info("MQTT entity command received", "device", deviceName, "entity", componentName, "attribute", currentAttribute.Name, "state", state)
+
+					varType, payload, valid := currentAttribute.EncodeCommand(state)
+					if !valid {
+						app.logger.Warn("Invalid entity command", "device", deviceName, "entity", componentName, "attribute", currentAttribute.Name, "state", state)
+						if err := app.respondToCommand(currentDevice, currentEntity, response, state, fmt.Errorf("invalid %s value %q", currentAttribute.Name, state)); err != nil {
+							app.logger.Error("Failed to publish command response", "device", deviceName, "entity", componentName, "error", err)
+						}
+						return
+					}
+					app.sendEntityCommand(currentDevice, currentEntity, varType, payload, state, response)
+				})
+			}
+			continue
+		}
+		
+		// Create composite key for uniqueness across devices
+		compositeKey := fmt.Sprintf("%s_%s_entity", device.ID, entity.ID)
+		app.mqttClient.RegisterStateChangeHandler(compositeKey, func(deviceName, componentName string, state string, response *mqtt.ResponseTarget) {
+			app.logger.Info("MQTT entity command received", "device", deviceName, "entity", componentName, "state", state)
+
+			// Get the MySensors variable type for this entity
+			varType, exists := config.GetMySensorsVariableTypeForEntity(currentEntity.EntityType, currentEntity.VariableType)
+			if !exists {
+				app.logger.Error("Unknown variable type for entity", "entity", componentName, "entityType", currentEntity.EntityType)
+				return
+			}
+
+			app.sendEntityCommand(currentDevice, currentEntity, varType, state, state, response)
+		})
+	}
+}
+
+// sendEntityCommand sends a SET message for a command received over MQTT. The payload is the
+// MySensors value of the Home Assistant state, the outcome goes to the response target if there
+// is one. It returns an error if the message was not sent.
+func (app *Application) sendEntityCommand(device config.Device, entity config.Entity, varType mysensors.VariableType, payload, state string, response *mqtt.ResponseTarget) error {
+	nodeID := device.NodeID
+	if entity.NodeID != nil {
+		nodeID = *entity.NodeID
+	}
+
+	// Determine which gateway to use (entity > device > default)
+	gatewayName := app.config.GetEffectiveGateway(device.Gateway, entity.Gateway)
+	
+	gatewayTransport, exists := app.transports[gatewayName]
+	if !exists {
+		app.logger.Error("No transport found for gateway", "gateway", gatewayName, "device", device.Name)
+		return fmt.Errorf("no transport for gateway %s", gatewayName)
+	}
+
+	// Use configured ACK bit setting (priority: device > global > default true)
+	requestAck := app.config.GetEffectiveRequestAck(&device)
+	message := mysensors.NewSetMessageWithAck(nodeID, entity.ChildID, varType, payload, requestAck)
+	
+	app.logger.Info("Sending MySensors entity command", "gateway", gatewayName, "message", message.String())
+	
+	// Send through the gateway so that commands for sleeping nodes are queued
+	var sender command.Sender = gatewayTransport
+	if gw, exists := app.gateways[gatewayName]; exists {
+		sender = gw
+	}
+
+	var err error
+	if app.commands != nil {
+		err = app.commands.Send(gatewayName, sender, device.ID, entity.ID, message, response)
+	} else {
+		err = sender.Send(message)
+	}
+	if err != nil {
+		app.logger.Error("Failed to send entity state change to MySensors", "gateway", gatewayName, "error", err,
+			"device", device.Name, "entity", entity.Name, "state", state)
+	} else {
+		app.logger.Info("MySensors entity command sent successfully", "gateway", gatewayName, "device", device.Name, "entity", entity.Name, 
+			"node_id", nodeID, "child_id", entity.ChildID, "state", state, "message", message.String())
+	}
+
+	// Tracked commands are answered once the node echoes them, see handleCommandResult
+	if app.commands == nil || !message.Ack {
+		if err := app.respondToCommand(device, entity, response, state, err); err != nil {
+			app.logger.Error("Failed to publish command response", "device", device.Name, "entity", entity.Name, "error", err)
 		}
 	}
+	return err
 }
 
-func (app *Application) handleDeviceMessage(message *mysensors.Message) {
+// registerRelayCover adds a relay_cover entity to the cover manager and routes its commands there
+func (app *Application) registerRelayCover(device config.Device, entity config.Entity) {
+	key := fmt.Sprintf("%s_%s_entity", device.ID, entity.ID)
+	app.covers.Add(key, cover.Config{
+		UpChildID:      entity.ChildID,
+		DownChildID:    *entity.DownChildID,
+		TravelTimeUp:   entity.TravelTimeUp,
+		TravelTimeDown: entity.GetTravelTimeDown(),
+		DeadTime:       entity.GetDeadTime(),
+	}, &relayCoverDriver{app: app, device: device, entity: entity})
+
+	stateKey := fmt.Sprintf("%s_%s_entity_%s", device.ID, entity.ID, config.StateAttribute)
+	app.mqttClient.RegisterStateChangeHandler(stateKey, func(deviceName, componentName string, state string, response *mqtt.ResponseTarget) {
+		app.logger.Info("MQTT entity command received", "device", deviceName, "entity", componentName, "state", state)
+
+		var err error
+		switch state {
+		case entity.GetPayloadOpen():
+			err = app.covers.Open(key)
+		case entity.GetPayloadClose():
+			err = app.covers.Close(key)
+		case entity.GetPayloadStop():
+			err = app.covers.StopCover(key)
+		}
+		if err != nil {
+			app.logger.Error("Failed to move cover", "device", deviceName, "entity", componentName, "error", err)
+		}
+		if err := app.respondToCommand(device, entity, response, state, err); err != nil {
+			app.logger.Error("Failed to publish command response", "device", deviceName, "entity", componentName, "error", err)
+		}
+	})
+
+	positionKey := fmt.Sprintf("%s_%s_entity_%s", device.ID, entity.ID, "position")
+	app.mqttClient.RegisterStateChangeHandler(positionKey, func(deviceName, componentName string, state string, response *mqtt.ResponseTarget) {
+		app.logger.Info("MQTT entity command received", "device", deviceName, "entity", componentName, "position", state)
+
+		position, err := strconv.ParseFloat(state, 64)
+		if err != nil {
+			err = fmt.Errorf("invalid position %q", state)
+		} else {
+			err = app.covers.SetPosition(key, position)
+		}
+		if err != nil {
+			app.logger.Error("Failed to move cover", "device", deviceName, "entity", componentName, "error", err)
+		}
+		if err := app.respondToCommand(device, entity, response, state, err); err != nil {
+			app.logger.Error("Failed to publish command response", "device", deviceName, "entity", componentName, "error", err)
+		}
+	})
+}
+
+// respondToCommand answers an MQTT 5 command that was sent without waiting for the node
+func (app *Application) respondToCommand(device config.Device, entity config.Entity, target *mqtt.ResponseTarget, state string, sendErr error) error {
+	response := mqtt.CommandResponse{Status: "sent", State: state}
+	if sendErr != nil {
+		response = mqtt.CommandResponse{Status: "failed", Error: sendErr.Error()}
+	}
+	return app.mqttClient.RespondToCommand(device.ID, entity.ID, target, response)
+}
+
+// relayCoverDriver switches the relays of a relay_cover entity like switch entities and
+// publishes the cover state
+type relayCoverDriver struct {
+	app    *Application
+	device config.Device
+	entity config.Entity
+}
+
+// relayCommand is the tracking context of a relay switch, its outcome goes back to the cover manager
+type relayCommand struct {
+	cover string
+	id    uint64
+}
+
+func (d *relayCoverDriver) SwitchRelay(childID int, on bool, id uint64) error {
+	// The configuration ensures relay covers are tracked, an untracked switch would never be confirmed
+	if d.app.commands == nil {
+		return fmt.Errorf("relay covers need command tracking")
+	}
+	gatewayName := d.app.config.GetEffectiveGateway(d.device.Gateway, d.entity.Gateway)
+	gw, exists := d.app.gateways[gatewayName]
+	if !exists {
+		return fmt.Errorf("no gateway %s", gatewayName)
+	}
+	nodeID := d.device.NodeID
+	if d.entity.NodeID != nil {
+		nodeID = *d.entity.NodeID
+	}
+
+	payload := "0"
+	if on {
+		payload = "1"
+	}
+	message := mysensors.NewSetMessageWithAck(nodeID, childID, mysensors.V_STATUS, payload, true)
+	d.app.logger.Info("Switching cover relay", "gateway", gatewayName, "device", d.device.Name, "entity", d.entity.Name, "message", message.String())
+
+	tracking := &relayCommand{cover: fmt.Sprintf("%s_%s_entity", d.device.ID, d.entity.ID), id: id}
+	if err := d.app.commands.Send(gatewayName, gw, d.device.ID, d.entity.ID, message, tracking); err != nil {
+		d.app.logger.Error("Failed to switch cover relay", "gateway", gatewayName, "device", d.device.Name, "entity", d.entity.Name, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (d *relayCoverDriver) PublishCover(state string, position int) {
+	switch state {
+	case "open":
+		state = d.entity.GetStateOpen()
+	case "closed":
+		state = d.entity.GetStateClosed()
+	}
+	if err := d.app.mqttClient.PublishAttributeState(d.device, d.entity, config.StateAttribute, state); err != nil {
+		d.app.logger.Error("Failed to publish entity state", "device", d.device.Name, "entity", d.entity.Name, "state", state, "error", err)
+	}
+	if err := d.app.mqttClient.PublishAttributeState(d.device, d.entity, "position", strconv.Itoa(position)); err != nil {
+		d.app.logger.Error("Failed to publish entity state", "device", d.device.Name, "entity", d.entity.Name, "position", position, "error", err)
+	}
+}
+
+func (app *Application) handleDeviceMessage(gatewayName string, message *mysensors.Message) {
 	if !message.IsSet() && !message.IsReq() {
 		return
 	}
 
 	var matchedEntities []string
 
-	for _, device := range app.config.Devices {
+	for _, device := range app.getDevices() {
 		// Handle entities with many-to-many mapping (all matches)
 		for _, entity := range device.Entities {
 			// Only process entities that can report state
@@ -615,12 +1320,35 @@ func (app *Application) handleDeviceMessage(message *mysensors.Message) {
 				continue
 			}
 			
+			// Node IDs are only unique within a gateway
+			if app.config.GetEffectiveGateway(device.Gateway, entity.Gateway) != gatewayName {
+				continue
+			}
+
 			effectiveNodeID := device.NodeID
 			if entity.NodeID != nil {
 				effectiveNodeID = *entity.NodeID
 			}
 
+			// Relays of a relay cover switched at the node, echoes of our own commands are ignored
+			if entity.EntityType == "relay_cover" {
+				if effectiveNodeID == message.NodeID && (entity.ChildID == message.ChildID || *entity.DownChildID == message.ChildID) &&
+					message.IsSet() && !message.Ack && message.GetVariableType() == mysensors.V_STATUS {
+					app.covers.RelayChanged(fmt.Sprintf("%s_%s_entity", device.ID, entity.ID), message.ChildID, message.Payload == "1")
+					matchedEntities = append(matchedEntities, fmt.Sprintf("%s:%s", device.Name, entity.Name))
+				}
+				continue
+			}
+
 			if effectiveNodeID == message.NodeID && entity.ChildID == message.ChildID {
+				// Entities with several variables publish each variable to its own topic
+				if entity.Attributes() != nil {
+					if app.handleAttributeMessage(gatewayName, device, entity, message) {
+						matchedEntities = append(matchedEntities, fmt.Sprintf("%s:%s", device.Name, entity.Name))
+					}
+					continue
+				}
+
 				if message.IsSet() {
 					// Get expected variable type for this entity
 					expectedVarType, exists := config.GetMySensorsVariableTypeForEntity(entity.EntityType, entity.VariableType)
@@ -635,6 +1363,13 @@ func (app *Application) handleDeviceMessage(message *mysensors.Message) {
 							matchedEntities = append(matchedEntities, fmt.Sprintf("%s:%s", device.Name, entity.Name))
 						}
 					}
+				} else if message.IsReq() {
+					expectedVarType, exists := config.GetMySensorsVariableTypeForEntity(entity.EntityType, entity.VariableType)
+					if exists && message.GetVariableType() == expectedVarType {
+						if app.answerStateRequest(gatewayName, device, entity, message) {
+							matchedEntities = append(matchedEntities, fmt.Sprintf("%s:%s", device.Name, entity.Name))
+						}
+					}
 				}
 			}
 		}
@@ -643,8 +1378,110 @@ func (app *Application) handleDeviceMessage(message *mysensors.Message) {
 	// Log only when no matching device found
 	if len(matchedEntities) == 0 {
 		app.logger.Debug("No matching entity found for MySensors message",
+			"gateway", gatewayName, "node_id", message.NodeID, "child_id", message.ChildID)
+	}
+}
+
+// handleAttributeMessage publishes a variable of an entity with several variables, or answers
+// a request for it. Returns true if the variable belongs to the entity.
+func (app *Application) handleAttributeMessage(gatewayName string, device config.Device, entity config.Entity, message *mysensors.Message) bool {
+	attribute, exists := entity.AttributeForVariable(message.GetVariableType())
+	if !exists {
+		return false
+	}
+
+	if message.IsReq() {
+		attributeKey := fmt.Sprintf("%s_%s_entity_%s", device.ID, entity.ID, attribute.Name)
+		state, exists := app.mqttClient.GetState(attributeKey)
+		if !exists {
+			app.logger.Debug("No stored state to answer request", "gateway", gatewayName, "device", device.Name, "entity", entity.Name,
+				"attribute", attribute.Name, "node_id", message.NodeID, "child_id", message.ChildID)
+			return false
+		}
+		payload, known := attribute.Encode(state)
+		if !known {
+			return false
+		}
+		return app.sendStateResponse(gatewayName, device, entity, message, payload)
+	}
+
+	// Cover states follow from the reported direction and position
+	if entity.EntityType == "cover" {
+		return app.handleCoverMessage(device, entity, attribute, message)
+	}
+
+	state := attribute.Decode(message.Payload)
+	if err := app.mqttClient.PublishAttributeState(device, entity, attribute.Name, state); err != nil {
+		app.logger.Error("Failed to publish entity state", "error", err,
+			"device", device.Name, "entity", entity.Name, "attribute", attribute.Name, "state", state)
+		return false
+	}
+	app.logger.Info("Entity state changed", "device", device.Name, "entity", entity.Name, "attribute", attribute.Name,
+		"entity_type", entity.EntityType, "node_id", message.NodeID, "child_id", entity.ChildID, "state", state)
+	return true
+}
+
+// handleCoverMessage publishes a reported position and the cover state derived from it or from a
+// reported direction. Returns true if anything was published.
+func (app *Application) handleCoverMessage(device config.Device, entity config.Entity, attribute config.EntityAttribute, message *mysensors.Message) bool {
+	if attribute.Name != config.StateAttribute {
+		if err := app.mqttClient.PublishAttributeState(device, entity, attribute.Name, message.Payload); err != nil {
+			app.logger.Error("Failed to publish entity state", "error", err,
+				"device", device.Name, "entity", entity.Name, "attribute", attribute.Name, "state", message.Payload)
+			return false
+		}
+	}
+
+	stateKey := fmt.Sprintf("%s_%s_entity_%s", device.ID, entity.ID, config.StateAttribute)
+	positionKey := fmt.Sprintf("%s_%s_entity_%s", device.ID, entity.ID, "position")
+	lastState, _ := app.mqttClient.GetState(stateKey)
+	position, _ := app.mqttClient.GetState(positionKey)
+	state := entity.CoverState(message.GetVariableType(), message.Payload, lastState, position)
+	if state == "" || state == lastState {
+		return attribute.Name != config.StateAttribute
+	}
+
+	if err := app.mqttClient.PublishAttributeState(device, entity, config.StateAttribute, state); err != nil {
+		app.logger.Error("Failed to publish entity state", "error", err,
+			"device", device.Name, "entity", entity.Name, "state", state)
+		return false
+	}
+	app.logger.Info("Entity state changed", "device", device.Name, "entity", entity.Name, "entity_type", entity.EntityType,
+		"node_id", message.NodeID, "child_id", entity.ChildID, "state", state, "position", position)
+	return true
+}
+
+// answerStateRequest replies to a node's REQ message with the last known entity state,
+// acting like a regular MySensors controller. Returns true if a reply was sent.
+func (app *Application) answerStateRequest(gatewayName string, device config.Device, entity config.Entity, message *mysensors.Message) bool {
+	compositeKey := fmt.Sprintf("%s_%s_entity", device.ID, entity.ID)
+	state, exists := app.mqttClient.GetState(compositeKey)
+	if !exists {
+		app.logger.Debug("No stored state to answer request", "gateway", gatewayName, "device", device.Name, "entity", entity.Name,
 			"node_id", message.NodeID, "child_id", message.ChildID)
+		return false
 	}
+	return app.sendStateResponse(gatewayName, device, entity, message, state)
+}
+
+// sendStateResponse answers a REQ message with a stored state
+func (app *Application) sendStateResponse(gatewayName string, device config.Device, entity config.Entity, message *mysensors.Message, state string) bool {
+	gw, exists := app.gateways[gatewayName]
+	if !exists {
+		app.logger.Error("No gateway found", "gateway", gatewayName, "device", device.Name)
+		return false
+	}
+
+	response := mysensors.NewSetMessage(message.NodeID, message.ChildID, message.GetVariableType(), state)
+	if err := gw.Send(response); err != nil {
+		app.logger.Error("Failed to answer state request", "gateway", gatewayName, "error", err,
+			"device", device.Name, "entity", entity.Name, "message", response.String())
+		return false
+	}
+
+	app.logger.Info("Answered state request", "gateway", gatewayName, "device", device.Name, "entity", entity.Name,
+		"node_id", message.NodeID, "child_id", message.ChildID, "state", state)
+	return true
 }
 
 func (app *Application) periodicVersionRequest(ctx context.Context) {
@@ -686,12 +1523,26 @@ func (app *Application) shutdown() error {
 		app.syncMgr.Stop()
 	}
 
+	if app.availability != nil {
+		app.availability.Stop()
+	}
+
+	if app.commands != nil {
+		app.commands.Stop()
+	}
+
+	// Switches off the relays of moving covers while the transports are still connected
+	if app.covers != nil {
+		app.covers.Stop()
+	}
+
 	// Stop all TCP servers
 	for gatewayName, tcpServer := range app.tcpServers {
 		app.logger.Debug("Stopping TCP server", "gateway", gatewayName)
 		tcpServer.Stop()
 	}
 
+	// Publishes offline to <prefix>/status before disconnecting
 	if app.mqttClient != nil {
 		app.mqttClient.Disconnect()
 	}
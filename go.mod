@@ -3,6 +3,7 @@ module ms-mqtt-adapter
 go 1.24
 
 require (
+	github.com/eclipse/paho.golang v0.22.0
 	github.com/eclipse/paho.mqtt.golang v1.5.0
 	github.com/tarm/serial v0.0.0-20180830185346-98f6abe2eb07
 	gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,183 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"ms-mqtt-adapter/pkg/config"
+	"ms-mqtt-adapter/pkg/gateway"
+	"ms-mqtt-adapter/pkg/mqtt"
+	"sync"
+	"time"
+)
+
+// availabilityCheckInterval is how often last-seen times are compared against device timeouts
+const availabilityCheckInterval = 30 * time.Second
+
+// AvailabilityMonitor publishes per-entity availability based on when each entity's node was last seen.
+// An entity with its own node_id or gateway follows that node, not the one of its device.
+type AvailabilityMonitor struct {
+	config     *config.Config
+	devices    func() []config.Device
+	gateways   map[string]*gateway.Gateway // gatewayName -> gateway
+	mqttClient *mqtt.Client
+	logger     *slog.Logger
+	online     map[string]bool // entity state key -> last published availability
+	mu         sync.Mutex
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+func NewAvailabilityMonitor(cfg *config.Config, devices func() []config.Device, gateways map[string]*gateway.Gateway, mqttClient *mqtt.Client, logger *slog.Logger) *AvailabilityMonitor {
+	return &AvailabilityMonitor{
+		config:     cfg,
+		devices:    devices,
+		gateways:   gateways,
+		mqttClient: mqttClient,
+		logger:     logger,
+		online:     make(map[string]bool),
+	}
+}
+
+func (am *AvailabilityMonitor) Start(ctx context.Context) {
+	am.ctx, am.cancel = context.WithCancel(ctx)
+
+	go am.checkLoop()
+
+	if interval := am.config.AdapterTopics.Availability.HeartbeatInterval; interval > 0 {
+		go am.heartbeatLoop(interval)
+		am.logger.Info("Heartbeat probing started", "interval", interval)
+	}
+}
+
+func (am *AvailabilityMonitor) Stop() {
+	if am.cancel != nil {
+		am.cancel()
+	}
+}
+
+func (am *AvailabilityMonitor) checkLoop() {
+	ticker := time.NewTicker(availabilityCheckInterval)
+	defer ticker.Stop()
+
+	am.CheckAll()
+
+	for {
+		select {
+		case <-am.ctx.Done():
+			return
+		case <-ticker.C:
+			am.CheckAll()
+		}
+	}
+}
+
+// heartbeatLoop probes the nodes of tracked devices so that idle but healthy nodes stay online
+func (am *AvailabilityMonitor) heartbeatLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-am.ctx.Done():
+			return
+		case <-ticker.C:
+			probed := make(map[*gateway.Gateway]map[int]bool)
+			for _, device := range am.devices() {
+				if _, tracked := am.config.AdapterTopics.GetAvailabilityTimeout(&device); !tracked {
+					continue
+				}
+				for _, entity := range device.Entities {
+					gw, exists := am.gateways[am.config.GetEffectiveGateway(device.Gateway, entity.Gateway)]
+					if !exists {
+						continue
+					}
+					nodeID := entityNodeID(device, entity)
+					if probed[gw] == nil {
+						probed[gw] = make(map[int]bool)
+					}
+					if probed[gw][nodeID] {
+						continue
+					}
+					probed[gw][nodeID] = true
+					gw.SendHeartbeatRequest(nodeID)
+				}
+			}
+		}
+	}
+}
+
+// NodeSeen marks the entities of a node online as soon as it sends a message
+func (am *AvailabilityMonitor) NodeSeen(gatewayName string, nodeID int) {
+	for _, device := range am.devices() {
+		if _, tracked := am.config.AdapterTopics.GetAvailabilityTimeout(&device); !tracked {
+			continue
+		}
+		for _, entity := range device.Entities {
+			if entityNodeID(device, entity) != nodeID || am.config.GetEffectiveGateway(device.Gateway, entity.Gateway) != gatewayName {
+				continue
+			}
+			am.update(device, entity, true)
+		}
+	}
+}
+
+// CheckAll recomputes and publishes the availability of every entity of the tracked devices
+func (am *AvailabilityMonitor) CheckAll() {
+	for _, device := range am.devices() {
+		timeout, tracked := am.config.AdapterTopics.GetAvailabilityTimeout(&device)
+		if !tracked {
+			continue
+		}
+
+		for _, entity := range device.Entities {
+			online := false
+			if gw, exists := am.gateways[am.config.GetEffectiveGateway(device.Gateway, entity.Gateway)]; exists {
+				if lastSeen, seen := gw.LastSeen(entityNodeID(device, entity)); seen {
+					online = time.Since(lastSeen) < timeout
+				}
+			}
+			am.update(device, entity, online)
+		}
+	}
+}
+
+// Republish publishes the current availability of every tracked entity again
+func (am *AvailabilityMonitor) Republish() {
+	am.mu.Lock()
+	am.online = make(map[string]bool)
+	am.mu.Unlock()
+	am.CheckAll()
+}
+
+// update publishes an entity's availability if it changed
+func (am *AvailabilityMonitor) update(device config.Device, entity config.Entity, online bool) {
+	key := fmt.Sprintf("%s_%s_entity", device.ID, entity.ID)
+	am.mu.Lock()
+	previous, known := am.online[key]
+	if known && previous == online {
+		am.mu.Unlock()
+		return
+	}
+	am.online[key] = online
+	am.mu.Unlock()
+
+	if known {
+		am.logger.Info("Entity availability changed", "device", device.Name, "entity", entity.Name,
+			"node_id", entityNodeID(device, entity), "online", online)
+	}
+	if err := am.mqttClient.PublishEntityAvailability(device.ID, entity.ID, online); err != nil {
+		am.logger.Error("Failed to publish entity availability", "device", device.Name, "entity", entity.Name, "error", err)
+		am.mu.Lock()
+		delete(am.online, key)
+		am.mu.Unlock()
+	}
+}
+
+// entityNodeID returns the node of an entity, which defaults to the node of its device
+func entityNodeID(device config.Device, entity config.Entity) int {
+	if entity.NodeID != nil {
+		return *entity.NodeID
+	}
+	return device.NodeID
+}
@@ -1,31 +1,56 @@
 package events
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
 	"strings"
 )
 
-func NewLogger(logLevel string) *slog.Logger {
-	var level slog.Level
+// logLevel is shared by the loggers created with NewLogger so that SetLogLevel changes them at runtime
+var logLevel = new(slog.LevelVar)
 
-	switch strings.ToLower(logLevel) {
-	case "debug":
-		level = slog.LevelDebug
-	case "info":
-		level = slog.LevelInfo
-	case "warn", "warning":
-		level = slog.LevelWarn
-	case "error":
-		level = slog.LevelError
-	default:
-		level = slog.LevelInfo
+func NewLogger(level string) *slog.Logger {
+	parsed, ok := parseLogLevel(level)
+	if !ok {
+		parsed = slog.LevelInfo
 	}
+	logLevel.Set(parsed)
 
 	opts := &slog.HandlerOptions{
-		Level: level,
+		Level: logLevel,
 	}
 
 	handler := slog.NewTextHandler(os.Stdout, opts)
 	return slog.New(handler)
 }
+
+// SetLogLevel changes the level of all loggers created with NewLogger
+func SetLogLevel(level string) error {
+	parsed, ok := parseLogLevel(level)
+	if !ok {
+		return fmt.Errorf("unknown log level %q", level)
+	}
+	logLevel.Set(parsed)
+	return nil
+}
+
+// LogLevel returns the current level of the loggers created with NewLogger
+func LogLevel() string {
+	return strings.ToLower(logLevel.Level().String())
+}
+
+func parseLogLevel(level string) (slog.Level, bool) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, true
+	case "info":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	default:
+		return slog.LevelInfo, false
+	}
+}
@@ -6,39 +6,51 @@ import (
 	"log/slog"
 	"ms-mqtt-adapter/internal/mysensors"
 	"ms-mqtt-adapter/pkg/config"
+	"ms-mqtt-adapter/pkg/gateway"
 	"ms-mqtt-adapter/pkg/mqtt"
-	"ms-mqtt-adapter/pkg/transport"
+	"sort"
 	"time"
 )
 
 type SyncManager struct {
 	config     *config.Config
+	devices    func() []config.Device
 	mqttClient *mqtt.Client
-	transport  transport.Transport
+	gateways   map[string]*gateway.Gateway // gatewayName -> gateway
 	logger     *slog.Logger
 	ctx        context.Context
 	cancel     context.CancelFunc
 }
 
-func NewSyncManager(cfg *config.Config, mqttClient *mqtt.Client, transport transport.Transport, logger *slog.Logger) *SyncManager {
+func NewSyncManager(cfg *config.Config, devices func() []config.Device, mqttClient *mqtt.Client, gateways map[string]*gateway.Gateway, logger *slog.Logger) *SyncManager {
 	return &SyncManager{
 		config:     cfg,
+		devices:    devices,
 		mqttClient: mqttClient,
-		transport:  transport,
+		gateways:   gateways,
 		logger:     logger,
 	}
 }
 
 func (sm *SyncManager) Start(ctx context.Context) error {
-	if !sm.config.AdapterTopics.Sync.Enabled {
-		sm.logger.Info("Periodic sync disabled")
-		return nil
-	}
-
 	sm.ctx, sm.cancel = context.WithCancel(ctx)
 
-	go sm.syncLoop()
-	sm.logger.Info("Sync manager started", "period", sm.config.AdapterTopics.Sync.Period)
+	started := 0
+	for _, gatewayName := range sm.gatewayNames() {
+		syncConfig := sm.config.GetEffectiveSync(gatewayName)
+		if !syncConfig.Enabled {
+			sm.logger.Info("Periodic sync disabled", "gateway", gatewayName)
+			continue
+		}
+
+		go sm.syncLoop(gatewayName, syncConfig.Period)
+		sm.logger.Info("Sync manager started", "gateway", gatewayName, "period", syncConfig.Period)
+		started++
+	}
+
+	if started == 0 {
+		sm.logger.Info("Periodic sync disabled")
+	}
 	return nil
 }
 
@@ -49,59 +61,170 @@ func (sm *SyncManager) Stop() {
 	sm.logger.Info("Sync manager stopped")
 }
 
-func (sm *SyncManager) syncLoop() {
-	ticker := time.NewTicker(sm.config.AdapterTopics.Sync.Period)
+func (sm *SyncManager) syncLoop(gatewayName string, period time.Duration) {
+	ticker := time.NewTicker(period)
 	defer ticker.Stop()
 
-	sm.performSync()
+	sm.performSync(gatewayName)
 
 	for {
 		select {
 		case <-sm.ctx.Done():
 			return
 		case <-ticker.C:
-			sm.performSync()
+			sm.performSync(gatewayName)
 		}
 	}
 }
 
-func (sm *SyncManager) performSync() {
-	sm.logger.Debug("Starting periodic sync")
+// performSync pushes the stored state of every actuator entity that lives on the given gateway
+func (sm *SyncManager) performSync(gatewayName string) {
+	gw, exists := sm.gateways[gatewayName]
+	if !exists {
+		sm.logger.Error("No gateway found", "gateway", gatewayName)
+		return
+	}
+
+	sm.logger.Debug("Starting periodic sync", "gateway", gatewayName)
 
-	for _, device := range sm.config.Devices {
+	for _, device := range sm.devices() {
 		for _, entity := range device.Entities {
 			// Only sync entities that can receive commands (actuators)
 			if !entity.CanReceiveCommands() {
 				continue
 			}
-			
-			compositeKey := fmt.Sprintf("%s_%s_entity", device.ID, entity.ID)
-			if state, exists := sm.mqttClient.GetState(compositeKey); exists {
-				nodeID := device.NodeID
-				if entity.NodeID != nil {
-					nodeID = *entity.NodeID
-				}
-
-				// Get MySensors variable type for this entity
-				varType, _ := config.GetMySensorsVariableTypeForEntity(entity.EntityType, entity.VariableType)
-
-				requestAck := sm.config.GetEffectiveRequestAck(&device)
-				message := mysensors.NewSetMessageWithAck(nodeID, entity.ChildID, varType, state, requestAck)
-
-				if err := sm.transport.Send(message); err != nil {
-					sm.logger.Error("Failed to sync entity state", "error", err,
-						"device", device.Name, "entity", entity.Name, "state", state)
-				} else {
-					sm.logger.Debug("Synced entity state",
-						"device", device.Name, "entity", entity.Name, "state", state)
-				}
+
+			// Each entity is synced through its own gateway only
+			if sm.config.GetEffectiveGateway(device.Gateway, entity.Gateway) != gatewayName {
+				continue
+			}
+
+			sm.syncEntity(gatewayName, gw, device, entity)
+		}
+	}
+
+	sm.logger.Debug("Periodic sync completed", "gateway", gatewayName)
+}
+
+// syncEntity sends the stored state of an entity to its node through the gateway, which holds it
+// for a sleeping node. It returns false if there is no stored state or the send failed.
+func (sm *SyncManager) syncEntity(gatewayName string, gw *gateway.Gateway, device config.Device, entity config.Entity) bool {
+	// Relay covers are driven by the adapter, a position is not a relay state
+	if entity.EntityType == "relay_cover" {
+		return false
+	}
+
+	// Entities with several variables sync every variable that takes commands
+	if attributes := entity.Attributes(); attributes != nil {
+		synced := false
+		for _, attribute := range attributes {
+			// Actions like opening a cover have no state to sync
+			if !attribute.Command || attribute.CommandVariables != nil {
+				continue
+			}
+			attributeKey := fmt.Sprintf("%s_%s_entity_%s", device.ID, entity.ID, attribute.Name)
+			state, exists := sm.mqttClient.GetState(attributeKey)
+			if !exists {
+				continue
+			}
+			if payload, known := attribute.Encode(state); known && sm.sendState(gatewayName, gw, device, entity, attribute.VariableType, payload) {
+				synced = true
+			}
+		}
+		return synced
+	}
+
+	compositeKey := fmt.Sprintf("%s_%s_entity", device.ID, entity.ID)
+	state, exists := sm.mqttClient.GetState(compositeKey)
+	if !exists {
+		return false
+	}
+
+	// Get MySensors variable type for this entity
+	varType, _ := config.GetMySensorsVariableTypeForEntity(entity.EntityType, entity.VariableType)
+	return sm.sendState(gatewayName, gw, device, entity, varType, state)
+}
+
+func (sm *SyncManager) sendState(gatewayName string, gw *gateway.Gateway, device config.Device, entity config.Entity, varType mysensors.VariableType, state string) bool {
+	nodeID := device.NodeID
+	if entity.NodeID != nil {
+		nodeID = *entity.NodeID
+	}
+
+	requestAck := sm.config.GetEffectiveRequestAck(&device)
+	message := mysensors.NewSetMessageWithAck(nodeID, entity.ChildID, varType, state, requestAck)
+
+	if err := gw.Send(message); err != nil {
+		sm.logger.Error("Failed to sync entity state", "gateway", gatewayName, "error", err,
+			"device", device.Name, "entity", entity.Name, "state", state)
+		return false
+	}
+	sm.logger.Debug("Synced entity state", "gateway", gatewayName,
+		"device", device.Name, "entity", entity.Name, "state", state)
+	return true
+}
+
+// Sync pushes stored states right away, regardless of the periodic sync settings. An empty
+// device ID syncs all devices and an empty entity ID all entities of the device. It returns
+// the number of entities whose state was sent.
+func (sm *SyncManager) Sync(deviceID, entityID string) (int, error) {
+	if deviceID == "" && entityID != "" {
+		return 0, fmt.Errorf("an entity can only be synced together with its device")
+	}
+
+	synced := 0
+	found := deviceID == ""
+	for _, device := range sm.devices() {
+		if deviceID != "" && device.ID != deviceID {
+			continue
+		}
+		if entityID == "" {
+			found = true
+		}
+		for _, entity := range device.Entities {
+			if entityID != "" && entity.ID != entityID {
+				continue
+			}
+			found = true
+			if !entity.CanReceiveCommands() {
+				continue
+			}
+
+			gatewayName := sm.config.GetEffectiveGateway(device.Gateway, entity.Gateway)
+			gw, exists := sm.gateways[gatewayName]
+			if !exists {
+				sm.logger.Error("No gateway found", "gateway", gatewayName)
+				continue
+			}
+			if sm.syncEntity(gatewayName, gw, device, entity) {
+				synced++
 			}
 		}
 	}
 
-	sm.logger.Debug("Periodic sync completed")
+	if !found {
+		if entityID != "" {
+			return 0, fmt.Errorf("unknown entity %s of device %s", entityID, deviceID)
+		}
+		return 0, fmt.Errorf("unknown device %s", deviceID)
+	}
+	return synced, nil
 }
 
+// SyncDeviceStates performs a sync on every gateway that has sync enabled
 func (sm *SyncManager) SyncDeviceStates() {
-	sm.performSync()
+	for _, gatewayName := range sm.gatewayNames() {
+		if sm.config.GetEffectiveSync(gatewayName).Enabled {
+			sm.performSync(gatewayName)
+		}
+	}
+}
+
+func (sm *SyncManager) gatewayNames() []string {
+	names := make([]string, 0, len(sm.gateways))
+	for gatewayName := range sm.gateways {
+		names = append(names, gatewayName)
+	}
+	sort.Strings(names)
+	return names
 }
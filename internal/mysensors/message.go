@@ -19,21 +19,51 @@ const (
 type InternalType int
 
 const (
-	I_BATTERY_LEVEL        InternalType = 0
-	I_TIME                 InternalType = 1
-	I_VERSION              InternalType = 2
-	I_ID_REQUEST           InternalType = 3
-	I_ID_RESPONSE          InternalType = 4
-	I_INCLUSION_MODE       InternalType = 5
-	I_CONFIG               InternalType = 6
-	I_FIND_PARENT          InternalType = 7
-	I_FIND_PARENT_RESPONSE InternalType = 8
-	I_LOG_MESSAGE          InternalType = 9
-	I_CHILDREN             InternalType = 10
-	I_SKETCH_NAME          InternalType = 11
-	I_SKETCH_VERSION       InternalType = 12
-	I_REBOOT               InternalType = 13
-	I_GATEWAY_READY        InternalType = 14
+	I_BATTERY_LEVEL           InternalType = 0
+	I_TIME                    InternalType = 1
+	I_VERSION                 InternalType = 2
+	I_ID_REQUEST              InternalType = 3
+	I_ID_RESPONSE             InternalType = 4
+	I_INCLUSION_MODE          InternalType = 5
+	I_CONFIG                  InternalType = 6
+	I_FIND_PARENT             InternalType = 7
+	I_FIND_PARENT_RESPONSE    InternalType = 8
+	I_LOG_MESSAGE             InternalType = 9
+	I_CHILDREN                InternalType = 10
+	I_SKETCH_NAME             InternalType = 11
+	I_SKETCH_VERSION          InternalType = 12
+	I_REBOOT                  InternalType = 13
+	I_GATEWAY_READY           InternalType = 14
+	I_SIGNING_PRESENTATION    InternalType = 15
+	I_NONCE_REQUEST           InternalType = 16
+	I_NONCE_RESPONSE          InternalType = 17
+	I_HEARTBEAT_REQUEST       InternalType = 18
+	I_PRESENTATION            InternalType = 19
+	I_DISCOVER_REQUEST        InternalType = 20
+	I_DISCOVER_RESPONSE       InternalType = 21
+	I_HEARTBEAT_RESPONSE      InternalType = 22
+	I_LOCKED                  InternalType = 23
+	I_PING                    InternalType = 24
+	I_PONG                    InternalType = 25
+	I_REGISTRATION_REQUEST    InternalType = 26
+	I_REGISTRATION_RESPONSE   InternalType = 27
+	I_DEBUG                   InternalType = 28
+	I_SIGNAL_REPORT_REQUEST   InternalType = 29
+	I_SIGNAL_REPORT_REVERSE   InternalType = 30
+	I_SIGNAL_REPORT_RESPONSE  InternalType = 31
+	I_PRE_SLEEP_NOTIFICATION  InternalType = 32
+	I_POST_SLEEP_NOTIFICATION InternalType = 33
+)
+
+type StreamType int
+
+const (
+	ST_FIRMWARE_CONFIG_REQUEST  StreamType = 0
+	ST_FIRMWARE_CONFIG_RESPONSE StreamType = 1
+	ST_FIRMWARE_REQUEST         StreamType = 2
+	ST_FIRMWARE_RESPONSE        StreamType = 3
+	ST_SOUND                    StreamType = 4
+	ST_IMAGE                    StreamType = 5
 )
 
 type SensorType int
@@ -143,6 +173,133 @@ const (
 	V_POWER_FACTOR       VariableType = 56
 )
 
+var sensorTypeNames = map[SensorType]string{
+	S_DOOR:                  "S_DOOR",
+	S_MOTION:                "S_MOTION",
+	S_SMOKE:                 "S_SMOKE",
+	S_BINARY:                "S_BINARY",
+	S_DIMMER:                "S_DIMMER",
+	S_COVER:                 "S_COVER",
+	S_TEMP:                  "S_TEMP",
+	S_HUM:                   "S_HUM",
+	S_BARO:                  "S_BARO",
+	S_WIND:                  "S_WIND",
+	S_RAIN:                  "S_RAIN",
+	S_UV:                    "S_UV",
+	S_WEIGHT:                "S_WEIGHT",
+	S_POWER:                 "S_POWER",
+	S_HEATER:                "S_HEATER",
+	S_DISTANCE:              "S_DISTANCE",
+	S_LIGHT_LEVEL:           "S_LIGHT_LEVEL",
+	S_ARDUINO_NODE:          "S_ARDUINO_NODE",
+	S_ARDUINO_REPEATER_NODE: "S_ARDUINO_REPEATER_NODE",
+	S_LOCK:                  "S_LOCK",
+	S_IR:                    "S_IR",
+	S_WATER:                 "S_WATER",
+	S_AIR_QUALITY:           "S_AIR_QUALITY",
+	S_CUSTOM:                "S_CUSTOM",
+	S_DUST:                  "S_DUST",
+	S_SCENE_CONTROLLER:      "S_SCENE_CONTROLLER",
+	S_RGB_LIGHT:             "S_RGB_LIGHT",
+	S_RGBW_LIGHT:            "S_RGBW_LIGHT",
+	S_COLOR_SENSOR:          "S_COLOR_SENSOR",
+	S_HVAC:                  "S_HVAC",
+	S_MULTIMETER:            "S_MULTIMETER",
+	S_SPRINKLER:             "S_SPRINKLER",
+	S_WATER_LEAK:            "S_WATER_LEAK",
+	S_SOUND:                 "S_SOUND",
+	S_VIBRATION:             "S_VIBRATION",
+	S_MOISTURE:              "S_MOISTURE",
+	S_INFO:                  "S_INFO",
+	S_GAS:                   "S_GAS",
+	S_GPS:                   "S_GPS",
+	S_WATER_QUALITY:         "S_WATER_QUALITY",
+}
+
+var variableTypeNames = map[VariableType]string{
+	V_TEMP:               "V_TEMP",
+	V_HUM:                "V_HUM",
+	V_STATUS:             "V_STATUS",
+	V_PERCENTAGE:         "V_PERCENTAGE",
+	V_PRESSURE:           "V_PRESSURE",
+	V_FORECAST:           "V_FORECAST",
+	V_RAIN:               "V_RAIN",
+	V_RAINRATE:           "V_RAINRATE",
+	V_WIND:               "V_WIND",
+	V_GUST:               "V_GUST",
+	V_DIRECTION:          "V_DIRECTION",
+	V_UV:                 "V_UV",
+	V_WEIGHT:             "V_WEIGHT",
+	V_DISTANCE:           "V_DISTANCE",
+	V_IMPEDANCE:          "V_IMPEDANCE",
+	V_ARMED:              "V_ARMED",
+	V_TRIPPED:            "V_TRIPPED",
+	V_WATT:               "V_WATT",
+	V_KWH:                "V_KWH",
+	V_SCENE_ON:           "V_SCENE_ON",
+	V_SCENE_OFF:          "V_SCENE_OFF",
+	V_HVAC_FLOW_STATE:    "V_HVAC_FLOW_STATE",
+	V_HVAC_SPEED:         "V_HVAC_SPEED",
+	V_LIGHT_LEVEL:        "V_LIGHT_LEVEL",
+	V_VAR1:               "V_VAR1",
+	V_VAR2:               "V_VAR2",
+	V_VAR3:               "V_VAR3",
+	V_VAR4:               "V_VAR4",
+	V_VAR5:               "V_VAR5",
+	V_UP:                 "V_UP",
+	V_DOWN:               "V_DOWN",
+	V_STOP:               "V_STOP",
+	V_IR_SEND:            "V_IR_SEND",
+	V_IR_RECEIVE:         "V_IR_RECEIVE",
+	V_FLOW:               "V_FLOW",
+	V_VOLUME:             "V_VOLUME",
+	V_LOCK_STATUS:        "V_LOCK_STATUS",
+	V_LEVEL:              "V_LEVEL",
+	V_VOLTAGE:            "V_VOLTAGE",
+	V_CURRENT:            "V_CURRENT",
+	V_RGB:                "V_RGB",
+	V_RGBW:               "V_RGBW",
+	V_ID:                 "V_ID",
+	V_UNIT_PREFIX:        "V_UNIT_PREFIX",
+	V_HVAC_SETPOINT_COOL: "V_HVAC_SETPOINT_COOL",
+	V_HVAC_SETPOINT_HEAT: "V_HVAC_SETPOINT_HEAT",
+	V_HVAC_FLOW_MODE:     "V_HVAC_FLOW_MODE",
+	V_TEXT:               "V_TEXT",
+	V_CUSTOM:             "V_CUSTOM",
+	V_POSITION:           "V_POSITION",
+	V_IR_RECORD:          "V_IR_RECORD",
+	V_PH:                 "V_PH",
+	V_ORP:                "V_ORP",
+	V_EC:                 "V_EC",
+	V_VAR:                "V_VAR",
+	V_VA:                 "V_VA",
+	V_POWER_FACTOR:       "V_POWER_FACTOR",
+}
+
+func (t SensorType) String() string {
+	if name, exists := sensorTypeNames[t]; exists {
+		return name
+	}
+	return fmt.Sprintf("S_UNKNOWN(%d)", int(t))
+}
+
+func (t VariableType) String() string {
+	if name, exists := variableTypeNames[t]; exists {
+		return name
+	}
+	return fmt.Sprintf("V_UNKNOWN(%d)", int(t))
+}
+
+// ParseVariableType returns the variable type with a name such as V_STATUS
+func ParseVariableType(name string) (VariableType, bool) {
+	for varType, varName := range variableTypeNames {
+		if varName == name {
+			return varType, true
+		}
+	}
+	return 0, false
+}
+
 type Message struct {
 	NodeID      int
 	ChildID     int
@@ -198,6 +355,32 @@ func (m *Message) String() string {
 	return fmt.Sprintf("%d;%d;%d;%s;%d;%s", m.NodeID, m.ChildID, m.MessageType, ack, m.SubType, m.Payload)
 }
 
+// MQTTTopic returns the topic used by the MySensors MQTT gateway for this message,
+// <prefix>/<node>/<child>/<type>/<ack>/<subtype>
+func (m *Message) MQTTTopic(prefix string) string {
+	ack := "0"
+	if m.Ack {
+		ack = "1"
+	}
+	return fmt.Sprintf("%s/%d/%d/%d/%s/%d", prefix, m.NodeID, m.ChildID, m.MessageType, ack, m.SubType)
+}
+
+// ParseMQTTMessage parses a message in the MySensors MQTT gateway topic format
+func ParseMQTTMessage(prefix, topic, payload string) (*Message, error) {
+	if !strings.HasPrefix(topic, prefix+"/") {
+		return nil, fmt.Errorf("topic %s does not start with %s", topic, prefix)
+	}
+	parts := strings.Split(strings.TrimPrefix(topic, prefix+"/"), "/")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid topic format: expected 5 levels after prefix, got %d", len(parts))
+	}
+	if strings.ContainsAny(payload, ";\r\n") {
+		return nil, fmt.Errorf("payload must not contain ';' or line breaks")
+	}
+
+	return ParseMessage(strings.Join(parts, ";") + ";" + payload)
+}
+
 func (m *Message) IsInternal() bool {
 	return m.MessageType == INTERNAL
 }
@@ -214,6 +397,14 @@ func (m *Message) IsPresentation() bool {
 	return m.MessageType == PRESENTATION
 }
 
+func (m *Message) IsStream() bool {
+	return m.MessageType == STREAM
+}
+
+func (m *Message) GetStreamType() StreamType {
+	return StreamType(m.SubType)
+}
+
 func (m *Message) GetInternalType() InternalType {
 	return InternalType(m.SubType)
 }
@@ -269,3 +460,14 @@ func NewInternalMessage(nodeID int, intType InternalType, payload string) *Messa
 		Payload:     payload,
 	}
 }
+
+func NewStreamMessage(nodeID int, streamType StreamType, payload string) *Message {
+	return &Message{
+		NodeID:      nodeID,
+		ChildID:     255,
+		MessageType: STREAM,
+		Ack:         false,
+		SubType:     int(streamType),
+		Payload:     payload,
+	}
+}
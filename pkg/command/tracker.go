@@ -0,0 +1,221 @@
+package command
+
+import (
+	"fmt"
+	"log/slog"
+	"ms-mqtt-adapter/internal/mysensors"
+	"ms-mqtt-adapter/pkg/config"
+	"sync"
+	"time"
+)
+
+// Status is the delivery state of a tracked command
+type Status string
+
+const (
+	StatusPending    Status = "pending"
+	StatusConfirmed  Status = "confirmed"
+	StatusFailed     Status = "failed"
+	StatusSuperseded Status = "superseded" // A newer command for the same child and variable was sent
+)
+
+// Command is an outgoing SET message waiting for its echo
+type Command struct {
+	Gateway  string
+	DeviceID string
+	EntityID string
+	Message  *mysensors.Message
+	Attempts int
+	SentAt   time.Time
+	Context  any // Passed back unchanged with every status, such as where to answer the command
+
+	sender Sender
+	timer  *time.Timer
+}
+
+// Sender delivers messages to a MySensors network
+type Sender interface {
+	Send(message *mysensors.Message) error
+}
+
+// queueChecker is implemented by senders that hold messages for sleeping nodes
+type queueChecker interface {
+	IsQueued(message *mysensors.Message) bool
+}
+
+// ResultHandler is called whenever the status of a command changes
+type ResultHandler func(cmd Command, status Status)
+
+// Tracker records SET messages sent with the ACK bit and waits for the matching echo,
+// retrying with exponential backoff until the configured number of retries is exhausted
+type Tracker struct {
+	cfg      *config.CommandTrackingConfig
+	logger   *slog.Logger
+	pending  map[string]*Command // key: "gateway:node:child:type"
+	mu       sync.Mutex
+	onResult ResultHandler
+}
+
+func NewTracker(cfg *config.CommandTrackingConfig, logger *slog.Logger, onResult ResultHandler) *Tracker {
+	return &Tracker{
+		cfg:      cfg,
+		logger:   logger,
+		pending:  make(map[string]*Command),
+		onResult: onResult,
+	}
+}
+
+func commandKey(gatewayName string, nodeID, childID, subType int) string {
+	return fmt.Sprintf("%s:%d:%d:%d", gatewayName, nodeID, childID, subType)
+}
+
+// Send transmits a command and, if it requests an ACK, tracks it until confirmed or failed.
+// A newer command for the same node/child/type supersedes a pending one.
+func (t *Tracker) Send(gatewayName string, sender Sender, deviceID, entityID string, message *mysensors.Message, context any) error {
+	if !message.Ack {
+		return sender.Send(message)
+	}
+
+	cmd := &Command{
+		Gateway:  gatewayName,
+		DeviceID: deviceID,
+		EntityID: entityID,
+		Message:  message,
+		Context:  context,
+		sender:   sender,
+	}
+	key := commandKey(gatewayName, message.NodeID, message.ChildID, message.SubType)
+
+	t.mu.Lock()
+	var superseded *Command
+	if previous, exists := t.pending[key]; exists {
+		if previous.timer != nil {
+			previous.timer.Stop()
+		}
+		t.logger.Debug("Superseding pending command", "gateway", gatewayName, "message", previous.Message.String())
+		result := *previous
+		superseded = &result
+	}
+	// The timer is armed before the lock is released so a pending command always has one
+	t.pending[key] = cmd
+	t.arm(key, cmd)
+	pending := *cmd
+	t.mu.Unlock()
+
+	if superseded != nil {
+		t.notify(*superseded, StatusSuperseded)
+	}
+	t.notify(pending, StatusPending)
+	return t.transmit(cmd, pending.Attempts)
+}
+
+// attempt sends the command once more and schedules the next retry
+func (t *Tracker) attempt(key string, cmd *Command) error {
+	t.mu.Lock()
+	if t.pending[key] != cmd {
+		// Confirmed or superseded in the meantime
+		t.mu.Unlock()
+		return nil
+	}
+	t.arm(key, cmd)
+	attempts := cmd.Attempts
+	t.mu.Unlock()
+
+	return t.transmit(cmd, attempts)
+}
+
+// arm counts an attempt and schedules its timeout. The caller must hold t.mu.
+func (t *Tracker) arm(key string, cmd *Command) {
+	cmd.Attempts++
+	cmd.SentAt = time.Now()
+	timeout := t.cfg.Timeout * time.Duration(1<<(cmd.Attempts-1))
+	cmd.timer = time.AfterFunc(timeout, func() { t.handleTimeout(key, cmd) })
+}
+
+// transmit hands the command to its sender
+func (t *Tracker) transmit(cmd *Command, attempts int) error {
+	err := cmd.sender.Send(cmd.Message)
+	if err != nil {
+		t.logger.Warn("Failed to send tracked command", "gateway", cmd.Gateway, "attempt", attempts,
+			"error", err, "message", cmd.Message.String())
+	}
+	return err
+}
+
+func (t *Tracker) handleTimeout(key string, cmd *Command) {
+	t.mu.Lock()
+	if t.pending[key] != cmd {
+		t.mu.Unlock()
+		return
+	}
+	// Commands held for a sleeping node are not retried until they were delivered
+	if checker, ok := cmd.sender.(queueChecker); ok && checker.IsQueued(cmd.Message) {
+		cmd.timer = time.AfterFunc(t.cfg.Timeout, func() { t.handleTimeout(key, cmd) })
+		t.mu.Unlock()
+		return
+	}
+	retries := 0
+	if t.cfg.Retries != nil {
+		retries = *t.cfg.Retries
+	}
+	if cmd.Attempts > retries {
+		delete(t.pending, key)
+		result := *cmd
+		t.mu.Unlock()
+
+		t.logger.Warn("Command not confirmed by node", "gateway", cmd.Gateway, "device", cmd.DeviceID, "entity", cmd.EntityID,
+			"attempts", cmd.Attempts, "message", cmd.Message.String())
+		t.notify(result, StatusFailed)
+		return
+	}
+	t.mu.Unlock()
+
+	t.logger.Info("Retrying unconfirmed command", "gateway", cmd.Gateway, "device", cmd.DeviceID, "entity", cmd.EntityID,
+		"attempt", cmd.Attempts+1, "message", cmd.Message.String())
+	t.attempt(key, cmd)
+}
+
+// HandleMessage matches an echoed message against pending commands. Returns true if it confirmed one.
+func (t *Tracker) HandleMessage(gatewayName string, message *mysensors.Message) bool {
+	if !message.IsSet() || !message.Ack {
+		return false
+	}
+
+	key := commandKey(gatewayName, message.NodeID, message.ChildID, message.SubType)
+
+	t.mu.Lock()
+	cmd, exists := t.pending[key]
+	if !exists || cmd.Message.Payload != message.Payload {
+		t.mu.Unlock()
+		return false
+	}
+	if cmd.timer != nil {
+		cmd.timer.Stop()
+	}
+	delete(t.pending, key)
+	result := *cmd
+	t.mu.Unlock()
+
+	t.logger.Debug("Command confirmed by node", "gateway", gatewayName, "device", cmd.DeviceID, "entity", cmd.EntityID,
+		"attempts", cmd.Attempts, "latency", time.Since(cmd.SentAt))
+	t.notify(result, StatusConfirmed)
+	return true
+}
+
+// Stop cancels all pending retries
+func (t *Tracker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, cmd := range t.pending {
+		if cmd.timer != nil {
+			cmd.timer.Stop()
+		}
+		delete(t.pending, key)
+	}
+}
+
+func (t *Tracker) notify(cmd Command, status Status) {
+	if t.onResult != nil {
+		t.onResult(cmd, status)
+	}
+}
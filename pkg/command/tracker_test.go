@@ -0,0 +1,136 @@
+package command
+
+import (
+	"io"
+	"log/slog"
+	"ms-mqtt-adapter/internal/mysensors"
+	"ms-mqtt-adapter/pkg/config"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSender struct {
+	mu   sync.Mutex
+	sent []*mysensors.Message
+}
+
+func (s *fakeSender) Send(message *mysensors.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, message)
+	return nil
+}
+
+func newTestTracker(onResult ResultHandler) *Tracker {
+	retries := 1
+	cfg := &config.CommandTrackingConfig{Retries: &retries, Timeout: time.Second}
+	return NewTracker(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), onResult)
+}
+
+func TestTrackerConcurrentSendSameKey(t *testing.T) {
+	tracker := newTestTracker(nil)
+	defer tracker.Stop()
+	sender := &fakeSender{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		payload := "0"
+		if i%2 == 1 {
+			payload = "1"
+		}
+		go func() {
+			defer wg.Done()
+			message := mysensors.NewSetMessageWithAck(1, 2, mysensors.V_STATUS, payload, true)
+			if err := tracker.Send("gw", sender, "device", "entity", message, nil); err != nil {
+				t.Errorf("Send() error = %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			tracker.HandleMessage("gw", mysensors.NewSetMessageWithAck(1, 2, mysensors.V_STATUS, payload, true))
+		}()
+	}
+	wg.Wait()
+
+	if got := len(sender.sent); got != 50 {
+		t.Errorf("sent %d messages, want 50", got)
+	}
+}
+
+func TestTrackerSupersedeFromPendingCallback(t *testing.T) {
+	sender := &fakeSender{}
+	var tracker *Tracker
+	var statuses []Status
+	var contexts []any
+	resent := false
+	tracker = newTestTracker(func(cmd Command, status Status) {
+		statuses = append(statuses, status)
+		contexts = append(contexts, cmd.Context)
+		if status == StatusPending && !resent {
+			// A second command for the same child arrives before the first one was sent
+			resent = true
+			message := mysensors.NewSetMessageWithAck(1, 2, mysensors.V_STATUS, "0", true)
+			if err := tracker.Send("gw", sender, "device", "entity", message, "second"); err != nil {
+				t.Errorf("Send() error = %v", err)
+			}
+		}
+	})
+	defer tracker.Stop()
+
+	message := mysensors.NewSetMessageWithAck(1, 2, mysensors.V_STATUS, "1", true)
+	if err := tracker.Send("gw", sender, "device", "entity", message, "first"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	if tracker.HandleMessage("gw", mysensors.NewSetMessageWithAck(1, 2, mysensors.V_STATUS, "1", true)) {
+		t.Error("echo of the superseded command confirmed it")
+	}
+	if !tracker.HandleMessage("gw", mysensors.NewSetMessageWithAck(1, 2, mysensors.V_STATUS, "0", true)) {
+		t.Error("echo of the latest command was not matched")
+	}
+	want := []Status{StatusPending, StatusSuperseded, StatusPending, StatusConfirmed}
+	wantContexts := []any{"first", "first", "second", "second"}
+	if len(statuses) != len(want) {
+		t.Fatalf("statuses = %v, want %v", statuses, want)
+	}
+	for i := range want {
+		if statuses[i] != want[i] || contexts[i] != wantContexts[i] {
+			t.Errorf("statuses = %v with contexts %v, want %v with %v", statuses, contexts, want, wantContexts)
+			break
+		}
+	}
+}
+
+func TestTrackerRetriesUntilFailed(t *testing.T) {
+	sender := &fakeSender{}
+	results := make(chan Status, 4)
+	retries := 1
+	cfg := &config.CommandTrackingConfig{Retries: &retries, Timeout: 10 * time.Millisecond}
+	tracker := NewTracker(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), func(cmd Command, status Status) {
+		results <- status
+	})
+	defer tracker.Stop()
+
+	message := mysensors.NewSetMessageWithAck(1, 2, mysensors.V_STATUS, "1", true)
+	if err := tracker.Send("gw", sender, "device", "entity", message, nil); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	for _, want := range []Status{StatusPending, StatusFailed} {
+		select {
+		case got := <-results:
+			if got != want {
+				t.Fatalf("status = %s, want %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	if len(sender.sent) != 2 {
+		t.Errorf("sent %d messages, want 2", len(sender.sent))
+	}
+}
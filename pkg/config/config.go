@@ -1,9 +1,16 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"ms-mqtt-adapter/internal/mysensors"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -26,16 +33,58 @@ type MySensorsConfig struct {
 	RS485 struct {
 		Device string `yaml:"device"`
 	} `yaml:"rs485"`
-	Gateway    GatewayConfig    `yaml:"gateway"`
-	TCPService TCPServiceConfig `yaml:"tcp_service"`
+	Gateway    GatewayConfig     `yaml:"gateway"`
+	TCPService TCPServiceConfig  `yaml:"tcp_service"`
+	RawTopics  RawTopicsConfig   `yaml:"raw_topics"`
+	Sync       GatewaySyncConfig `yaml:"sync"`
 }
 
 type MQTTConfig struct {
-	Broker   string `yaml:"broker"`
-	Port     int    `yaml:"port"`
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
-	ClientID string `yaml:"client_id"`
+	Broker   string        `yaml:"broker"` // Host name, or a tcp://, ssl://, ws:// or wss:// URL
+	Port     int           `yaml:"port"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	ClientID string        `yaml:"client_id"`
+	CleanSession *bool     `yaml:"clean_session,omitempty"` // false keeps a persistent session for client_id (default: true)
+	ProtocolVersion int    `yaml:"protocol_version"`        // 3 for MQTT 3.1.1 or 5 for MQTT 5 (default: 3)
+	TLS      MQTTTLSConfig `yaml:"tls"`
+	Buffer   MQTTBufferConfig `yaml:"buffer"`
+}
+
+// MQTTBufferConfig controls holding back publishes while the broker is unreachable
+type MQTTBufferConfig struct {
+	Enabled     *bool `yaml:"enabled,omitempty"` // Default: true
+	MaxMessages int   `yaml:"max_messages"`      // Oldest messages are dropped beyond this (default: 1000)
+	Persist     bool  `yaml:"persist"`           // Keep the buffer in <data_dir>/mqtt-buffer.json across restarts
+}
+
+// MQTTTLSConfig configures TLS and client certificate authentication for the broker connection.
+// Certificate files are re-read when they change, on the next connection attempt.
+type MQTTTLSConfig struct {
+	Enabled            bool   `yaml:"enabled"`              // Use ssl:// for a plain broker host name
+	CAFile             string `yaml:"ca_file,omitempty"`    // CA bundle to verify the broker (default: system roots)
+	CertFile           string `yaml:"cert_file,omitempty"`  // Client certificate for mutual TLS
+	KeyFile            string `yaml:"key_file,omitempty"`   // Client private key for mutual TLS
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // Do not verify the broker certificate
+	ServerName         string `yaml:"server_name,omitempty"` // Expected broker certificate name (default: broker host)
+}
+
+// BrokerURL returns the broker address in the form expected by the MQTT client
+func (m *MQTTConfig) BrokerURL() string {
+	if strings.Contains(m.Broker, "://") {
+		return m.Broker
+	}
+	scheme := "tcp"
+	if m.TLS.Enabled {
+		scheme = "ssl"
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, m.Broker, m.Port)
+}
+
+// UsesTLS reports whether the broker connection is encrypted
+func (m *MQTTConfig) UsesTLS() bool {
+	scheme := strings.SplitN(m.BrokerURL(), "://", 2)[0]
+	return scheme == "ssl" || scheme == "tls" || scheme == "mqtts" || scheme == "wss"
 }
 
 type TCPServiceConfig struct {
@@ -43,26 +92,137 @@ type TCPServiceConfig struct {
 	Port    int  `yaml:"port"`
 }
 
+// RawTopicsConfig mirrors gateway traffic in the MySensors MQTT gateway topic format,
+// <prefix>/<node>/<child>/<type>/<ack>/<subtype>
+type RawTopicsConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	OutPrefix string `yaml:"out_prefix"` // Received messages are published here (default: mygateway1-out)
+	InPrefix  string `yaml:"in_prefix"`  // Messages published here are sent to the gateway (default: mygateway1-in)
+}
+
+// Prefixes returns the out and in prefixes, defaulting to mygateway1 for the default gateway
+// and mygateway-<name> for other gateways
+func (raw *RawTopicsConfig) Prefixes(gatewayName string) (string, string) {
+	base := "mygateway-" + gatewayName
+	if gatewayName == "default" {
+		base = "mygateway1"
+	}
+	outPrefix, inPrefix := raw.OutPrefix, raw.InPrefix
+	if outPrefix == "" {
+		outPrefix = base + "-out"
+	}
+	if inPrefix == "" {
+		inPrefix = base + "-in"
+	}
+	return outPrefix, inPrefix
+}
+
 type SyncConfig struct {
 	Enabled bool          `yaml:"enabled"`
 	Period  time.Duration `yaml:"period"`
 }
 
+// GatewaySyncConfig overrides the global sync settings for a single gateway
+type GatewaySyncConfig struct {
+	Enabled *bool         `yaml:"enabled,omitempty"`
+	Period  time.Duration `yaml:"period,omitempty"`
+}
+
 type GatewayConfig struct {
 	NodeIDRange struct {
 		Start int `yaml:"start"`
 		End   int `yaml:"end"`
 	} `yaml:"node_id_range"`
-	VersionRequestPeriod time.Duration `yaml:"version_request_period"`
-	RandomIDAssignment   *bool         `yaml:"random_id_assignment,omitempty"`
+	VersionRequestPeriod time.Duration      `yaml:"version_request_period"`
+	RandomIDAssignment   *bool              `yaml:"random_id_assignment,omitempty"`
+	NodeRegistry         NodeRegistryConfig `yaml:"node_registry"`
+	SmartSleep           SmartSleepConfig   `yaml:"smart_sleep"`
+}
+
+// SmartSleepConfig controls queueing of commands for nodes using MySensors smart sleep
+type SmartSleepConfig struct {
+	Enabled *bool `yaml:"enabled,omitempty"` // Hold SETs for sleeping nodes until they wake (default: true)
+}
+
+// NodeRegistryConfig controls the persistent per-gateway node registry
+type NodeRegistryConfig struct {
+	Enabled     *bool `yaml:"enabled,omitempty"`      // Persist assigned node IDs to disk (default: true)
+	PublishMQTT bool  `yaml:"publish_mqtt"`           // Mirror the registry to a retained MQTT topic
+	ReservedIDs []int `yaml:"reserved_ids,omitempty"` // Node IDs that must never be handed out
 }
 
 type AdapterConfig struct {
 	TopicPrefix            string     `yaml:"topic_prefix"`
+	TopicTemplate          string     `yaml:"topic_template,omitempty"` // Base topic of entities (default: DefaultTopicTemplate)
+	DataDir                string     `yaml:"data_dir"`
 	HomeAssistantDiscovery *bool      `yaml:"homeassistant_discovery,omitempty"`
+	HomeAssistantStatusTopic string   `yaml:"homeassistant_status_topic"` // Birth message topic, "online" triggers a republish
 	Optimistic             *bool      `yaml:"optimistic,omitempty"`
-	RequestAck             *bool      `yaml:"request_ack,omitempty"`
-	Sync                   SyncConfig `yaml:"sync"`
+	RequestAck             *bool               `yaml:"request_ack,omitempty"`
+	QOS                    *int                `yaml:"qos,omitempty"`    // Default QoS for entity state and command topics (default: 0)
+	Retain                 *bool               `yaml:"retain,omitempty"` // Default retain flag for entity states (default: true)
+	Sync                   SyncConfig          `yaml:"sync"`
+	AutoDiscovery          AutoDiscoveryConfig `yaml:"auto_discovery"`
+	CommandTracking        CommandTrackingConfig `yaml:"command_tracking"`
+	Firmware               FirmwareConfig        `yaml:"firmware"`
+	Availability           AvailabilityConfig    `yaml:"availability"`
+}
+
+// AvailabilityConfig controls marking devices unavailable when their node stops sending
+type AvailabilityConfig struct {
+	Enabled           bool          `yaml:"enabled"`
+	Timeout           time.Duration `yaml:"timeout"`            // Offline after this long without a message (default: 1h)
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"` // Probe nodes with I_HEARTBEAT_REQUEST (default: disabled)
+}
+
+// FirmwareConfig controls serving OTA firmware updates over the MySensors STREAM message type
+type FirmwareConfig struct {
+	Enabled     bool                 `yaml:"enabled"`
+	Directory   string               `yaml:"directory"` // Intel HEX files named <name>-<type>-<version>.hex (default: <data_dir>/firmware)
+	Assignments []FirmwareAssignment `yaml:"assignments,omitempty"`
+}
+
+// FirmwareAssignment selects the firmware a node should run
+type FirmwareAssignment struct {
+	Gateway string `yaml:"gateway,omitempty"`
+	NodeID  int    `yaml:"node_id"`
+	Type    int    `yaml:"type"`
+	Version int    `yaml:"version"`
+}
+
+// CommandTrackingConfig controls confirmation of commands sent with request_ack
+type CommandTrackingConfig struct {
+	Enabled *bool         `yaml:"enabled,omitempty"`
+	Retries *int          `yaml:"retries,omitempty"` // Resends after the first attempt
+	Timeout time.Duration `yaml:"timeout"`           // Wait for the echo, doubled on each retry
+}
+
+// AutoDiscoveryConfig controls building devices at runtime from MySensors presentations
+type AutoDiscoveryConfig struct {
+	Enabled   bool           `yaml:"enabled"`
+	Overrides []NodeOverride `yaml:"overrides,omitempty"`
+}
+
+// NodeOverride customizes an auto-discovered node
+type NodeOverride struct {
+	NodeID        int             `yaml:"node_id"`
+	Gateway       string          `yaml:"gateway,omitempty"`
+	Name          string          `yaml:"name,omitempty"`
+	ID            string          `yaml:"id,omitempty"`
+	SuggestedArea string          `yaml:"suggested_area,omitempty"`
+	Ignore        bool            `yaml:"ignore,omitempty"` // Never auto-discover this node
+	Children      []ChildOverride `yaml:"children,omitempty"`
+}
+
+// ChildOverride customizes an auto-discovered child of a node
+type ChildOverride struct {
+	ChildID     int    `yaml:"child_id"`
+	Name        string `yaml:"name,omitempty"`
+	ID          string `yaml:"id,omitempty"`
+	Icon        string `yaml:"icon,omitempty"`
+	EntityType  string `yaml:"entity_type,omitempty"`
+	DeviceClass string `yaml:"device_class,omitempty"`
+	Ignore      bool   `yaml:"ignore,omitempty"` // Never auto-discover this child
 }
 
 type Device struct {
@@ -79,6 +239,8 @@ type Device struct {
 	Connections      [][]string `yaml:"connections,omitempty"`
 	ViaDevice        string     `yaml:"via_device,omitempty"`
 	RequestAck       *bool      `yaml:"request_ack,omitempty"`
+	AvailabilityTimeout time.Duration `yaml:"availability_timeout,omitempty"` // Overrides the global timeout, enables tracking for this device
+	TopicTemplate    string     `yaml:"topic_template,omitempty"` // Overrides the global topic_template for this device
 	Entities         []Entity   `yaml:"entities"`
 }
 
@@ -104,6 +266,13 @@ type Entity struct {
 	MaxValue               *float64 `yaml:"max_value,omitempty"`          // For number entities
 	Step                   *float64 `yaml:"step,omitempty"`               // For number entities
 	Options                []string `yaml:"options,omitempty"`            // For select entities
+	Modes                  []string `yaml:"modes,omitempty"`              // For climate entities: off, heat, cool, heat_cool (default: all)
+	FanModes               []string `yaml:"fan_modes,omitempty"`          // For climate entities: auto, low, medium, high (default: no fan control)
+	Variables              map[string]string `yaml:"variables,omitempty"` // Attribute name -> MySensors variable for entities with several variables, empty keeps the default variable
+	DownChildID            *int          `yaml:"down_child_id,omitempty"`    // For relay_cover entities: relay that closes, child_id opens
+	TravelTimeUp           time.Duration `yaml:"travel_time_up,omitempty"`   // For relay_cover entities: time from closed to open
+	TravelTimeDown         time.Duration `yaml:"travel_time_down,omitempty"` // For relay_cover entities: time from open to closed (default: travel_time_up)
+	DeadTime               *time.Duration `yaml:"dead_time,omitempty"`       // For relay_cover entities: pause after one relay is confirmed off before the other goes on (default: 500ms)
 	
 	// Sensor configuration (for inputs/sensors)
 	StateClass             string `yaml:"state_class,omitempty"`         // "measurement", "total", "total_increasing"
@@ -133,6 +302,7 @@ type Entity struct {
 	Optimistic             *bool  `yaml:"optimistic,omitempty"`
 	OffDelay               *int   `yaml:"off_delay,omitempty"`            // For binary sensors
 	ExpireAfter            *int   `yaml:"expire_after,omitempty"`         // For sensors
+	MessageExpiry          time.Duration `yaml:"message_expiry,omitempty"` // MQTT 5 message expiry of state publishes
 	
 	// MQTT template configuration (optional)
 	JSONAttributesTopic    string `yaml:"json_attributes_topic,omitempty"`
@@ -171,6 +341,9 @@ func validateConfig(config *Config) error {
 	// Track TCP service ports to ensure no conflicts
 	tcpPorts := make(map[int]string)
 
+	// Track raw topic prefixes so that gateways never receive each other's messages
+	rawPrefixes := make(map[string]string)
+
 	// Validate each MySensors gateway configuration
 	for gatewayName, mysensorsConfig := range config.MySensors {
 		// Transport will be set to default "ethernet" in setDefaults if not specified
@@ -187,6 +360,18 @@ func validateConfig(config *Config) error {
 			}
 		}
 
+		// A zero period falls back to the global one, a negative period would stop the sync loop
+		if mysensorsConfig.Sync.Period < 0 {
+			return fmt.Errorf("mysensors gateway '%s' sync period must not be negative", gatewayName)
+		}
+
+		// Validate reserved node IDs
+		for _, nodeID := range mysensorsConfig.Gateway.NodeRegistry.ReservedIDs {
+			if nodeID < 1 || nodeID > 254 {
+				return fmt.Errorf("mysensors gateway '%s' reserved node ID %d must be between 1 and 254", gatewayName, nodeID)
+			}
+		}
+
 		// Validate TCP service ports for conflicts
 		if mysensorsConfig.TCPService.Enabled {
 			if mysensorsConfig.TCPService.Port == 0 {
@@ -198,14 +383,65 @@ func validateConfig(config *Config) error {
 			}
 			tcpPorts[mysensorsConfig.TCPService.Port] = gatewayName
 		}
+
+		// Validate raw topic prefixes
+		if mysensorsConfig.RawTopics.Enabled {
+			outPrefix, inPrefix := mysensorsConfig.RawTopics.Prefixes(gatewayName)
+			if outPrefix == inPrefix {
+				return fmt.Errorf("mysensors gateway '%s' raw_topics out_prefix and in_prefix must differ", gatewayName)
+			}
+			for _, prefix := range []string{outPrefix, inPrefix} {
+				if strings.ContainsAny(prefix, "#+") || strings.HasSuffix(prefix, "/") {
+					return fmt.Errorf("mysensors gateway '%s' raw_topics prefix '%s' must not contain wildcards or end with '/'", gatewayName, prefix)
+				}
+				if existingGateway, exists := rawPrefixes[prefix]; exists {
+					return fmt.Errorf("mysensors gateway '%s' raw_topics prefix '%s' conflicts with gateway '%s'", gatewayName, prefix, existingGateway)
+				}
+				rawPrefixes[prefix] = gatewayName
+			}
+		}
+	}
+
+	if config.AdapterTopics.Sync.Period < 0 {
+		return fmt.Errorf("adapter sync period must not be negative")
 	}
 
 	if config.MQTT.Broker == "" {
 		return fmt.Errorf("mqtt broker is required")
 	}
+	if strings.Contains(config.MQTT.Broker, "://") {
+		brokerURL, err := url.Parse(config.MQTT.Broker)
+		if err != nil {
+			return fmt.Errorf("invalid mqtt broker URL: %w", err)
+		}
+		switch brokerURL.Scheme {
+		case "tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss":
+		default:
+			return fmt.Errorf("unsupported mqtt broker scheme '%s', use tcp, ssl, ws or wss", brokerURL.Scheme)
+		}
+	}
+	if (config.MQTT.TLS.CertFile == "") != (config.MQTT.TLS.KeyFile == "") {
+		return fmt.Errorf("mqtt tls cert_file and key_file must be set together")
+	}
+	if config.MQTT.ProtocolVersion != 0 && config.MQTT.ProtocolVersion != 3 && config.MQTT.ProtocolVersion != 5 {
+		return fmt.Errorf("mqtt protocol_version must be 3 or 5")
+	}
+	if config.MQTT.Buffer.MaxMessages < 0 {
+		return fmt.Errorf("mqtt buffer max_messages must not be negative")
+	}
 
-	// Validate that entity node_id:child_id combinations are unique
-	entityTargets := make(map[string][]string) // key: "nodeID:childID", value: list of device:entity names
+	// Validate topic templates
+	if err := validateTopicTemplate(config.AdapterTopics.TopicTemplate); err != nil {
+		return fmt.Errorf("adapter topic_template: %w", err)
+	}
+	for _, device := range config.Devices {
+		if err := validateTopicTemplate(device.TopicTemplate); err != nil {
+			return fmt.Errorf("topic_template of device '%s': %w", device.Name, err)
+		}
+	}
+
+	// Validate that entity gateway:node_id:child_id combinations are unique
+	entityTargets := make(map[string][]string) // key: "gateway:nodeID:childID", value: list of device:entity names
 
 	// Validate entities
 	validEntityTypes := map[string]bool{
@@ -220,6 +456,7 @@ func validateConfig(config *Config) error {
 		"climate":      true,
 		"rgb_light":    true,
 		"rgbw_light":   true,
+		"relay_cover":  true,
 		
 		// Sensor types
 		"sensor":        true,
@@ -256,6 +493,24 @@ func validateConfig(config *Config) error {
 		"impedance":     true,
 	}
 
+	// A single gateway is renamed to "default" in setDefaults, so both names refer to it
+	singleGateway := ""
+	if _, hasDefault := config.MySensors["default"]; !hasDefault && len(config.MySensors) == 1 {
+		for name := range config.MySensors {
+			singleGateway = name
+		}
+	}
+	resolveGateway := func(deviceGateway, componentGateway string) (string, error) {
+		gatewayName := config.GetEffectiveGateway(deviceGateway, componentGateway)
+		if singleGateway != "" && (gatewayName == "default" || gatewayName == singleGateway) {
+			return "default", nil
+		}
+		if _, exists := config.MySensors[gatewayName]; !exists {
+			return "", fmt.Errorf("gateway '%s' is not defined under mysensors", gatewayName)
+		}
+		return gatewayName, nil
+	}
+
 	for _, device := range config.Devices {
 		// Validate entities and add them to the unique target check
 		for _, entity := range device.Entities {
@@ -266,6 +521,32 @@ func validateConfig(config *Config) error {
 			if !validEntityTypes[entity.EntityType] {
 				return fmt.Errorf("invalid entity_type '%s' for entity '%s' in device '%s'", entity.EntityType, entity.Name, device.Name)
 			}
+			if entity.QOS != nil && (*entity.QOS < 0 || *entity.QOS > 2) {
+				return fmt.Errorf("qos for entity '%s' in device '%s' must be 0, 1 or 2", entity.Name, device.Name)
+			}
+			if entity.MessageExpiry < 0 {
+				return fmt.Errorf("message_expiry for entity '%s' in device '%s' must not be negative", entity.Name, device.Name)
+			}
+			if err := validateRelayCover(&entity); err != nil {
+				return fmt.Errorf("entity '%s' in device '%s': %w", entity.Name, device.Name, err)
+			}
+			// A relay is only switched on once the node confirmed the other relay is off
+			if entity.EntityType == "relay_cover" && (!config.GetEffectiveRequestAck(&device) ||
+				(config.AdapterTopics.CommandTracking.Enabled != nil && !*config.AdapterTopics.CommandTracking.Enabled)) {
+				return fmt.Errorf("entity '%s' in device '%s': relay_cover needs request_ack and command_tracking", entity.Name, device.Name)
+			}
+			if err := validateVariableType(&entity); err != nil {
+				return fmt.Errorf("entity '%s' in device '%s': %w", entity.Name, device.Name, err)
+			}
+			if err := validateVariables(&entity); err != nil {
+				return fmt.Errorf("variables for entity '%s' in device '%s': %w", entity.Name, device.Name, err)
+			}
+			if err := validateAttributeValues(&entity, "mode", entity.Modes); err != nil {
+				return fmt.Errorf("modes for entity '%s' in device '%s': %w", entity.Name, device.Name, err)
+			}
+			if err := validateAttributeValues(&entity, "fan_mode", entity.FanModes); err != nil {
+				return fmt.Errorf("fan_modes for entity '%s' in device '%s': %w", entity.Name, device.Name, err)
+			}
 
 			// Add to unique target validation
 			effectiveNodeID := device.NodeID
@@ -273,16 +554,88 @@ func validateConfig(config *Config) error {
 				effectiveNodeID = *entity.NodeID
 			}
 
-			target := fmt.Sprintf("%d:%d", effectiveNodeID, entity.ChildID)
+			// Every gateway has its own node ID space
+			gatewayName, err := resolveGateway(device.Gateway, entity.Gateway)
+			if err != nil {
+				return fmt.Errorf("entity '%s' in device '%s': %w", entity.Name, device.Name, err)
+			}
+
+			target := fmt.Sprintf("%s:%d:%d", gatewayName, effectiveNodeID, entity.ChildID)
 			entityName := fmt.Sprintf("%s:%s", device.Name, entity.Name)
 			entityTargets[target] = append(entityTargets[target], entityName)
+			if entity.EntityType == "relay_cover" {
+				downTarget := fmt.Sprintf("%s:%d:%d", gatewayName, effectiveNodeID, *entity.DownChildID)
+				entityTargets[downTarget] = append(entityTargets[downTarget], entityName)
+			}
+		}
+	}
+
+	// Validate auto-discovery overrides
+	for _, override := range config.AdapterTopics.AutoDiscovery.Overrides {
+		if override.NodeID < 1 || override.NodeID > 254 {
+			return fmt.Errorf("auto_discovery override node_id %d must be between 1 and 254", override.NodeID)
+		}
+		if _, err := resolveGateway(override.Gateway, ""); err != nil {
+			return fmt.Errorf("auto_discovery override for node %d: %w", override.NodeID, err)
+		}
+		for _, child := range override.Children {
+			if child.EntityType != "" && !validEntityTypes[child.EntityType] {
+				return fmt.Errorf("invalid entity_type '%s' in auto_discovery override for node %d child %d", child.EntityType, override.NodeID, child.ChildID)
+			}
+		}
+	}
+
+	// Validate firmware assignments
+	for i, assignment := range config.AdapterTopics.Firmware.Assignments {
+		if assignment.NodeID < 1 || assignment.NodeID > 254 {
+			return fmt.Errorf("firmware assignment node_id %d must be between 1 and 254", assignment.NodeID)
+		}
+		if assignment.Type < 0 || assignment.Type > 65535 || assignment.Version < 0 || assignment.Version > 65535 {
+			return fmt.Errorf("firmware assignment for node %d: type and version must be between 0 and 65535", assignment.NodeID)
+		}
+		gatewayName, err := resolveGateway(assignment.Gateway, "")
+		if err != nil {
+			return fmt.Errorf("firmware assignment for node %d: %w", assignment.NodeID, err)
+		}
+		for _, other := range config.AdapterTopics.Firmware.Assignments[:i] {
+			otherGateway, _ := resolveGateway(other.Gateway, "")
+			if otherGateway == gatewayName && other.NodeID == assignment.NodeID {
+				return fmt.Errorf("duplicate firmware assignment for node %d on gateway '%s'", assignment.NodeID, gatewayName)
+			}
 		}
 	}
 
+	// Validate availability tracking
+	if config.AdapterTopics.Availability.Timeout < 0 || config.AdapterTopics.Availability.HeartbeatInterval < 0 {
+		return fmt.Errorf("availability timeout and heartbeat_interval must not be negative")
+	}
+
+	// Validate default MQTT QoS
+	if qos := config.AdapterTopics.QOS; qos != nil && (*qos < 0 || *qos > 2) {
+		return fmt.Errorf("adapter qos must be 0, 1 or 2")
+	}
+
+	// Validate command tracking
+	if retries := config.AdapterTopics.CommandTracking.Retries; retries != nil && (*retries < 0 || *retries > 10) {
+		return fmt.Errorf("command_tracking retries must be between 0 and 10")
+	}
+	if config.AdapterTopics.CommandTracking.Timeout < 0 {
+		return fmt.Errorf("command_tracking timeout must not be negative")
+	}
+
+	// Every entity needs its own topics, which templates without {entity_id} or {child_id} may not give it
+	topicPrefix := config.AdapterTopics.TopicPrefix
+	if topicPrefix == "" {
+		topicPrefix = "ms-mqtt-adapter"
+	}
+	if err := checkEntityTopics(&config.AdapterTopics, topicPrefix, config.Devices); err != nil {
+		return err
+	}
+
 	// Check for duplicate targets
 	for target, names := range entityTargets {
 		if len(names) > 1 {
-			return fmt.Errorf("duplicate mapping detected for MySensors target %s: %v - all entities must have unique node_id:child_id combinations", target, names)
+			return fmt.Errorf("duplicate mapping detected for MySensors target %s: %v - all entities must have unique gateway:node_id:child_id combinations", target, names)
 		}
 	}
 
@@ -291,6 +644,12 @@ func validateConfig(config *Config) error {
 
 // GetEffectiveGateway returns the gateway name to use for a device/relay/input
 func (config *Config) GetEffectiveGateway(deviceGateway, componentGateway string) string {
+	return config.AdapterTopics.GetEffectiveGateway(deviceGateway, componentGateway)
+}
+
+// GetEffectiveGateway returns the gateway name to use for a device/relay/input where only the
+// adapter settings are at hand, such as in topics and MQTT properties
+func (adapter *AdapterConfig) GetEffectiveGateway(deviceGateway, componentGateway string) string {
 	// Priority: component gateway > device gateway > "default"
 	if componentGateway != "" {
 		return componentGateway
@@ -301,6 +660,21 @@ func (config *Config) GetEffectiveGateway(deviceGateway, componentGateway string
 	return "default"
 }
 
+// GetEffectiveSync returns the sync settings for a gateway
+func (config *Config) GetEffectiveSync(gatewayName string) SyncConfig {
+	// Priority: gateway setting > global setting
+	effective := config.AdapterTopics.Sync
+	if gatewayConfig, exists := config.MySensors[gatewayName]; exists {
+		if gatewayConfig.Sync.Enabled != nil {
+			effective.Enabled = *gatewayConfig.Sync.Enabled
+		}
+		if gatewayConfig.Sync.Period != 0 {
+			effective.Period = gatewayConfig.Sync.Period
+		}
+	}
+	return effective
+}
+
 // GetEffectiveRequestAck returns the effective request_ack setting for a device
 func (config *Config) GetEffectiveRequestAck(device *Device) bool {
 	// Priority: device setting > global setting > default (true)
@@ -313,6 +687,273 @@ func (config *Config) GetEffectiveRequestAck(device *Device) bool {
 	return true // Default to true
 }
 
+// GetEntityQoS returns the MQTT QoS used for the state and command topics of an entity
+func (adapter *AdapterConfig) GetEntityQoS(entity *Entity) byte {
+	// Priority: entity setting > global setting > default (0)
+	if entity.QOS != nil {
+		return byte(*entity.QOS)
+	}
+	if adapter.QOS != nil {
+		return byte(*adapter.QOS)
+	}
+	return 0
+}
+
+// GetEntityRetain returns whether the state of an entity is published as a retained message
+func (adapter *AdapterConfig) GetEntityRetain(entity *Entity) bool {
+	// Priority: entity setting > global setting > default (true)
+	if entity.Retain != nil {
+		return *entity.Retain
+	}
+	if adapter.Retain != nil {
+		return *adapter.Retain
+	}
+	return true
+}
+
+// RelayCommandResultTopic returns the topic for the outcome of switching a relay of a relay_cover
+// entity with the given base topic
+func RelayCommandResultTopic(base string, childID int) string {
+	return fmt.Sprintf("%s/relay/%d/command_result", base, childID)
+}
+
+// CheckEntityTopics returns an error if two entities of the devices would share a topic, such as
+// an auto-discovered device rendered with the global template and a configured device
+func (adapter *AdapterConfig) CheckEntityTopics(devices []Device) error {
+	return checkEntityTopics(adapter, adapter.TopicPrefix, devices)
+}
+
+func checkEntityTopics(adapter *AdapterConfig, prefix string, devices []Device) error {
+	entityTopics := make(map[string]string) // topic -> device:entity name
+	for i := range devices {
+		device := &devices[i]
+		for j := range device.Entities {
+			entity := &device.Entities[j]
+			base := adapter.renderTopicTemplate(adapter.GetTopicTemplate(device), prefix, device, entity)
+			entityName := fmt.Sprintf("%s:%s", device.Name, entity.Name)
+			topics := []string{base + "/state", base + "/set", base + "/command_result", base + "/availability"}
+			for _, attribute := range entity.Attributes() {
+				if attribute.Name == StateAttribute {
+					continue
+				}
+				topics = append(topics, base+"/"+attribute.Name+"/state", base+"/"+attribute.Name+"/set")
+			}
+			if entity.EntityType == "relay_cover" && entity.DownChildID != nil {
+				topics = append(topics, RelayCommandResultTopic(base, entity.ChildID), RelayCommandResultTopic(base, *entity.DownChildID))
+			}
+			for _, topic := range topics {
+				if existing, exists := entityTopics[topic]; exists {
+					return fmt.Errorf("entities %s and %s share the topic %s, adjust topic_template", existing, entityName, topic)
+				}
+				entityTopics[topic] = entityName
+			}
+		}
+	}
+	return nil
+}
+
+// DefaultTopicTemplate is the base topic of an entity unless a topic_template is configured
+const DefaultTopicTemplate = "{prefix}/devices/{device_id}/entity/{entity_id}"
+
+// topicPlaceholder matches the placeholders of a topic template
+var topicPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// topicPlaceholders are the placeholders a topic template may use
+var topicPlaceholders = map[string]bool{
+	"{prefix}":    true,
+	"{gateway}":   true,
+	"{node_id}":   true,
+	"{child_id}":  true,
+	"{device_id}": true,
+	"{entity_id}": true,
+	"{area}":      true,
+}
+
+// GetTopicTemplate returns the template of the base topic of the entities of a device
+func (adapter *AdapterConfig) GetTopicTemplate(device *Device) string {
+	// Priority: device setting > global setting > default
+	if device.TopicTemplate != "" {
+		return device.TopicTemplate
+	}
+	if adapter.TopicTemplate != "" {
+		return adapter.TopicTemplate
+	}
+	return DefaultTopicTemplate
+}
+
+// EntityTopic returns the base topic of an entity. Its state, command, command result and
+// availability topics are <base>/state, <base>/set, <base>/command_result and <base>/availability.
+func (adapter *AdapterConfig) EntityTopic(device *Device, entity *Entity) string {
+	return adapter.renderTopicTemplate(adapter.GetTopicTemplate(device), adapter.TopicPrefix, device, entity)
+}
+
+func (adapter *AdapterConfig) renderTopicTemplate(template, prefix string, device *Device, entity *Entity) string {
+	nodeID := device.NodeID
+	if entity.NodeID != nil {
+		nodeID = *entity.NodeID
+	}
+	return strings.NewReplacer(
+		"{prefix}", prefix,
+		"{gateway}", adapter.GetEffectiveGateway(device.Gateway, entity.Gateway),
+		"{node_id}", strconv.Itoa(nodeID),
+		"{child_id}", strconv.Itoa(entity.ChildID),
+		"{device_id}", device.ID,
+		"{entity_id}", entity.ID,
+		"{area}", topicArea(device.SuggestedArea),
+	).Replace(template)
+}
+
+// topicArea turns an area name into a topic level, "Living Room" becomes "living_room".
+// Devices without an area use "unassigned".
+func topicArea(area string) string {
+	if area == "" {
+		return "unassigned"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		default:
+			return '_'
+		}
+	}, area)
+}
+
+// validateRelayCover checks the relays and travel times of a relay_cover entity
+func validateRelayCover(entity *Entity) error {
+	if entity.EntityType != "relay_cover" {
+		return nil
+	}
+	if entity.DownChildID == nil {
+		return fmt.Errorf("down_child_id is required for relay_cover")
+	}
+	if *entity.DownChildID == entity.ChildID {
+		return fmt.Errorf("down_child_id must differ from child_id")
+	}
+	if entity.TravelTimeUp <= 0 {
+		return fmt.Errorf("travel_time_up is required for relay_cover")
+	}
+	if entity.TravelTimeDown < 0 {
+		return fmt.Errorf("travel_time_down must not be negative")
+	}
+	if entity.DeadTime != nil && *entity.DeadTime < 0 {
+		return fmt.Errorf("dead_time must not be negative")
+	}
+	if len(entity.Variables) > 0 {
+		return fmt.Errorf("variables are not supported by relay_cover")
+	}
+	return nil
+}
+
+// validateVariableType rejects variable_type for entities whose variables come from attributes,
+// where it would be ignored
+func validateVariableType(entity *Entity) error {
+	attributes := entity.Attributes()
+	if entity.VariableType == "" || attributes == nil {
+		return nil
+	}
+	names := make([]string, 0, len(attributes))
+	for _, attribute := range attributes {
+		names = append(names, attribute.Name)
+	}
+	return fmt.Errorf("variable_type is not used by entity_type '%s', set the variable of each attribute (%s) with 'variables' instead",
+		entity.EntityType, strings.Join(names, ", "))
+}
+
+// validateVariables checks that the variables of an entity bind known attributes to distinct variables
+func validateVariables(entity *Entity) error {
+	if len(entity.Variables) == 0 {
+		return nil
+	}
+	available, _ := attributeCatalog(entity)
+	if available == nil {
+		return fmt.Errorf("not supported by entity_type '%s'", entity.EntityType)
+	}
+
+	for name, variable := range entity.Variables {
+		known := false
+		for _, attribute := range available {
+			if attribute.Name == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown attribute '%s' for entity_type '%s'", name, entity.EntityType)
+		}
+		if _, exists := mysensors.ParseVariableType(variable); variable != "" && !exists {
+			return fmt.Errorf("unknown variable type '%s' for attribute '%s'", variable, name)
+		}
+	}
+
+	bound := make(map[mysensors.VariableType]string)
+	for _, attribute := range entity.Attributes() {
+		varTypes := map[mysensors.VariableType]bool{attribute.VariableType: true}
+		for _, varType := range attribute.CommandVariables {
+			varTypes[varType] = true
+		}
+		for varType := range varTypes {
+			if other, exists := bound[varType]; exists {
+				return fmt.Errorf("attributes '%s' and '%s' use the same variable %s", other, attribute.Name, varType)
+			}
+			bound[varType] = attribute.Name
+		}
+	}
+	if IsLightType(entity.EntityType) {
+		if _, exists := entity.Attribute(StateAttribute); !exists {
+			return fmt.Errorf("lights need the '%s' attribute", StateAttribute)
+		}
+	}
+	return nil
+}
+
+// validateAttributeValues checks that configured values are known to an attribute of the entity
+func validateAttributeValues(entity *Entity, attributeName string, values []string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	attribute, exists := entity.Attribute(attributeName)
+	if !exists {
+		return fmt.Errorf("not supported by entity_type '%s'", entity.EntityType)
+	}
+	for _, value := range values {
+		if _, known := attribute.Encode(value); !known {
+			return fmt.Errorf("unknown value '%s'", value)
+		}
+	}
+	return nil
+}
+
+// validateTopicTemplate checks that a template only uses known placeholders and renders a valid topic
+func validateTopicTemplate(template string) error {
+	if template == "" {
+		return nil
+	}
+	for _, placeholder := range topicPlaceholder.FindAllString(template, -1) {
+		if !topicPlaceholders[placeholder] {
+			return fmt.Errorf("unknown placeholder %s in '%s'", placeholder, template)
+		}
+	}
+	if strings.ContainsAny(template, "#+") {
+		return fmt.Errorf("'%s' must not contain wildcards", template)
+	}
+	if strings.HasPrefix(template, "/") || strings.HasSuffix(template, "/") || strings.Contains(template, "//") {
+		return fmt.Errorf("'%s' must not contain empty topic levels", template)
+	}
+	return nil
+}
+
+// GetAvailabilityTimeout returns the availability timeout for a device and whether its availability is tracked
+func (adapter *AdapterConfig) GetAvailabilityTimeout(device *Device) (time.Duration, bool) {
+	// Priority: device timeout > global setting
+	if device.AvailabilityTimeout > 0 {
+		return device.AvailabilityTimeout, true
+	}
+	return adapter.Availability.Timeout, adapter.Availability.Enabled
+}
+
 // GetMySensorsVariableType returns the MySensors variable type for a sensor type
 func GetMySensorsVariableType(sensorType string) (mysensors.VariableType, bool) {
 	mapping := map[string]mysensors.VariableType{
@@ -425,6 +1066,473 @@ func GetMySensorsVariableTypeForOutput(outputType, variableTypeOverride string)
 	return mysensors.V_STATUS, false
 }
 
+// EntityAttribute is one of several MySensors variables of an entity. Each attribute has its own
+// topics below the entity topic, <base>/<name>/state and <base>/<name>/set.
+type EntityAttribute struct {
+	Name             string
+	VariableType     mysensors.VariableType
+	Command          bool                              // Accepts commands
+	Values           map[string]string                 // Home Assistant value -> MySensors payload, nil passes values through
+	CommandVariables map[string]mysensors.VariableType // Home Assistant value -> variable, for commands sent with their own variable
+	StateField       string                            // Discovery field of the state topic, empty if the entity type sets up discovery itself
+	CommandField     string                            // Discovery field of the command topic
+
+	// Conversions for values a value map cannot express, used instead of Values when set
+	encode func(value string) (string, bool)
+	decode func(payload string) string
+}
+
+// StateAttribute is the attribute that uses the topics of the entity itself, <base>/state and <base>/set
+const StateAttribute = "state"
+
+// AttributeTopic returns the base topic of an attribute below the base topic of its entity
+func AttributeTopic(base, attribute string) string {
+	if attribute == StateAttribute {
+		return base
+	}
+	return base + "/" + attribute
+}
+
+// climateAttributes map an S_HVAC or S_HEATER child to a Home Assistant climate entity
+var climateAttributes = []EntityAttribute{
+	{Name: "current_temperature", VariableType: mysensors.V_TEMP},
+	{Name: "heat_setpoint", VariableType: mysensors.V_HVAC_SETPOINT_HEAT, Command: true},
+	{Name: "cool_setpoint", VariableType: mysensors.V_HVAC_SETPOINT_COOL, Command: true},
+	{Name: "mode", VariableType: mysensors.V_HVAC_FLOW_STATE, Command: true, Values: map[string]string{
+		"off":       "Off",
+		"heat":      "HeatOn",
+		"cool":      "CoolOn",
+		"heat_cool": "AutoChangeOver",
+	}},
+	{Name: "fan_mode", VariableType: mysensors.V_HVAC_SPEED, Command: true, Values: map[string]string{
+		"auto":   "Auto",
+		"low":    "Min",
+		"medium": "Normal",
+		"high":   "Max",
+	}},
+}
+
+// DefaultClimateModes are the modes of a climate entity without configured modes
+var DefaultClimateModes = []string{"off", "heat", "cool", "heat_cool"}
+
+// lightStateAttribute switches a light on and off with V_STATUS, using the default payloads of Home Assistant
+var lightStateAttribute = EntityAttribute{Name: StateAttribute, VariableType: mysensors.V_STATUS, Command: true, Values: map[string]string{
+	"ON":  "1",
+	"OFF": "0",
+}, StateField: "state_topic", CommandField: "command_topic"}
+
+// lightBrightnessAttribute scales the 0-255 brightness of Home Assistant to the V_PERCENTAGE of the node
+var lightBrightnessAttribute = EntityAttribute{Name: "brightness", VariableType: mysensors.V_PERCENTAGE, Command: true,
+	encode: encodeBrightness, decode: decodeBrightness,
+	StateField: "brightness_state_topic", CommandField: "brightness_command_topic"}
+
+var lightRGBAttribute = EntityAttribute{Name: "rgb", VariableType: mysensors.V_RGB, Command: true,
+	encode:     func(value string) (string, bool) { return encodeColor(value, 3) },
+	decode:     func(payload string) string { return decodeColor(payload, 3) },
+	StateField: "rgb_state_topic", CommandField: "rgb_command_topic"}
+
+var lightRGBWAttribute = EntityAttribute{Name: "rgbw", VariableType: mysensors.V_RGBW, Command: true,
+	encode:     func(value string) (string, bool) { return encodeColor(value, 4) },
+	decode:     func(payload string) string { return decodeColor(payload, 4) },
+	StateField: "rgbw_state_topic", CommandField: "rgbw_command_topic"}
+
+// lightAttributes are the attributes any light can bind with variables
+var lightAttributes = []EntityAttribute{lightStateAttribute, lightBrightnessAttribute, lightRGBAttribute, lightRGBWAttribute}
+
+// dimmerAttributes map an S_DIMMER child to a Home Assistant light with on/off and brightness
+var dimmerAttributes = []EntityAttribute{lightStateAttribute, lightBrightnessAttribute}
+
+// rgbLightAttributes map an S_RGB_LIGHT child to a Home Assistant light with the rgb color mode
+var rgbLightAttributes = []EntityAttribute{lightStateAttribute, lightBrightnessAttribute, lightRGBAttribute}
+
+// rgbwLightAttributes map an S_RGBW_LIGHT child to a Home Assistant light with the rgbw color mode
+var rgbwLightAttributes = []EntityAttribute{lightStateAttribute, lightBrightnessAttribute, lightRGBWAttribute}
+
+// coverAttributes map an S_COVER child to a Home Assistant cover. Open, close and stop are sent
+// as V_UP, V_DOWN and V_STOP, the state is derived from the reported direction and position.
+func coverAttributes(entity *Entity) []EntityAttribute {
+	return newCoverAttributes(entity.GetPayloadOpen(), entity.GetPayloadClose(), entity.GetPayloadStop())
+}
+
+func newCoverAttributes(open, close, stop string) []EntityAttribute {
+	// Every command is sent as "1" with its own variable, so the payload alone does not tell
+	// the command. Commands are decoded by variable with DecodeCommand, states with CoverState.
+	encodeCommand := func(value string) (string, bool) {
+		if value == open || value == close || value == stop {
+			return "1", true
+		}
+		return "", false
+	}
+	return []EntityAttribute{
+		{Name: StateAttribute, VariableType: mysensors.V_UP, Command: true,
+			encode:           encodeCommand,
+			decode:           func(payload string) string { return payload },
+			CommandVariables: map[string]mysensors.VariableType{open: mysensors.V_UP, close: mysensors.V_DOWN, stop: mysensors.V_STOP},
+			StateField:       "state_topic", CommandField: "command_topic"},
+		{Name: "position", VariableType: mysensors.V_PERCENTAGE, Command: true, encode: encodePercentage,
+			StateField: "position_topic", CommandField: "set_position_topic"},
+	}
+}
+
+// attributeCatalog returns the attributes an entity type can bind with variables and the
+// attributes it uses without variables. Entity types with a single variable have neither.
+func attributeCatalog(entity *Entity) (available, defaults []EntityAttribute) {
+	switch entity.EntityType {
+	case "cover", "relay_cover":
+		attributes := coverAttributes(entity)
+		return attributes, attributes
+	case "climate":
+		return climateAttributes, climateAttributes
+	case "light":
+		return lightAttributes, nil
+	case "dimmer":
+		return lightAttributes, dimmerAttributes
+	case "rgb_light":
+		return lightAttributes, rgbLightAttributes
+	case "rgbw_light":
+		return lightAttributes, rgbwLightAttributes
+	default:
+		return nil, nil
+	}
+}
+
+// IsLightType returns true for entity types that are Home Assistant lights
+func IsLightType(entityType string) bool {
+	switch entityType {
+	case "light", "dimmer", "rgb_light", "rgbw_light":
+		return true
+	default:
+		return false
+	}
+}
+
+// Encode converts a Home Assistant value to the MySensors payload. It returns false for
+// values the attribute does not know.
+func (a *EntityAttribute) Encode(value string) (string, bool) {
+	if a.encode != nil {
+		return a.encode(value)
+	}
+	if a.Values == nil {
+		return value, true
+	}
+	payload, exists := a.Values[value]
+	return payload, exists
+}
+
+// EncodeCommand returns the variable and MySensors payload of a Home Assistant command. It returns
+// false for values the attribute does not know.
+func (a *EntityAttribute) EncodeCommand(value string) (mysensors.VariableType, string, bool) {
+	payload, valid := a.Encode(value)
+	if !valid {
+		return 0, "", false
+	}
+	if varType, exists := a.CommandVariables[value]; exists {
+		return varType, payload, true
+	}
+	return a.VariableType, payload, true
+}
+
+// DecodeCommand converts a command sent with EncodeCommand back to the Home Assistant value
+func (a *EntityAttribute) DecodeCommand(varType mysensors.VariableType, payload string) string {
+	for value, commandVarType := range a.CommandVariables {
+		if commandVarType == varType {
+			return value
+		}
+	}
+	return a.Decode(payload)
+}
+
+// Decode converts a MySensors payload to the Home Assistant value. Unknown payloads are passed through.
+func (a *EntityAttribute) Decode(payload string) string {
+	if a.decode != nil {
+		return a.decode(payload)
+	}
+	for value, known := range a.Values {
+		if strings.EqualFold(known, payload) {
+			return value
+		}
+	}
+	return payload
+}
+
+// Attributes returns the variables of entities that map several MySensors variables, nil for
+// entities with a single variable. Configured variables replace the defaults of the entity type.
+func (e *Entity) Attributes() []EntityAttribute {
+	available, defaults := attributeCatalog(e)
+	if len(e.Variables) == 0 {
+		return defaults
+	}
+
+	var attributes []EntityAttribute
+	for _, attribute := range available {
+		variable, bound := e.Variables[attribute.Name]
+		if !bound {
+			continue
+		}
+		if varType, exists := mysensors.ParseVariableType(variable); exists {
+			attribute.VariableType = varType
+		}
+		attributes = append(attributes, attribute)
+	}
+	return attributes
+}
+
+// Attribute returns an attribute of the entity by name
+func (e *Entity) Attribute(name string) (EntityAttribute, bool) {
+	for _, attribute := range e.Attributes() {
+		if attribute.Name == name {
+			return attribute, true
+		}
+	}
+	return EntityAttribute{}, false
+}
+
+// AttributeForVariable returns the attribute of the entity bound to a MySensors variable
+func (e *Entity) AttributeForVariable(varType mysensors.VariableType) (EntityAttribute, bool) {
+	for _, attribute := range e.Attributes() {
+		if attribute.VariableType == varType {
+			return attribute, true
+		}
+		for _, commandVarType := range attribute.CommandVariables {
+			if commandVarType == varType {
+				return attribute, true
+			}
+		}
+	}
+	return EntityAttribute{}, false
+}
+
+// CoverState derives the Home Assistant state of a cover from a reported variable and the last
+// state and position. Without a known position a stopped cover counts as closed after closing.
+// It returns an empty state for variables that do not change the state.
+func (e *Entity) CoverState(varType mysensors.VariableType, payload, state, position string) string {
+	stopped := func() string {
+		if percentage, err := strconv.ParseFloat(position, 64); err == nil {
+			if percentage <= 0 {
+				return e.GetStateClosed()
+			}
+			return e.GetStateOpen()
+		}
+		if state == "closing" {
+			return e.GetStateClosed()
+		}
+		return e.GetStateOpen()
+	}
+
+	switch varType {
+	case mysensors.V_UP:
+		if payload == "0" {
+			return stopped()
+		}
+		return "opening"
+	case mysensors.V_DOWN:
+		if payload == "0" {
+			return stopped()
+		}
+		return "closing"
+	case mysensors.V_STOP:
+		return stopped()
+	case mysensors.V_PERCENTAGE:
+		percentage, err := strconv.ParseFloat(payload, 64)
+		switch {
+		case err != nil:
+			return ""
+		case percentage <= 0:
+			return e.GetStateClosed()
+		case percentage >= 100:
+			return e.GetStateOpen()
+		case state == "opening" || state == "closing":
+			return state
+		default:
+			return e.GetStateOpen()
+		}
+	default:
+		return ""
+	}
+}
+
+// GetTravelTimeDown returns the time a relay_cover entity takes from open to closed
+func (e *Entity) GetTravelTimeDown() time.Duration {
+	if e.TravelTimeDown > 0 {
+		return e.TravelTimeDown
+	}
+	return e.TravelTimeUp
+}
+
+// GetDeadTime returns the pause of a relay_cover entity between a relay confirmed off and
+// switching on the relay of the other direction
+func (e *Entity) GetDeadTime() time.Duration {
+	if e.DeadTime != nil {
+		return *e.DeadTime
+	}
+	return 500 * time.Millisecond
+}
+
+// GetPayloadOpen returns the Home Assistant command that opens a cover
+func (e *Entity) GetPayloadOpen() string {
+	if e.PayloadOpen != "" {
+		return e.PayloadOpen
+	}
+	return "OPEN"
+}
+
+// GetPayloadClose returns the Home Assistant command that closes a cover
+func (e *Entity) GetPayloadClose() string {
+	if e.PayloadClose != "" {
+		return e.PayloadClose
+	}
+	return "CLOSE"
+}
+
+// GetPayloadStop returns the Home Assistant command that stops a cover
+func (e *Entity) GetPayloadStop() string {
+	if e.PayloadStop != "" {
+		return e.PayloadStop
+	}
+	return "STOP"
+}
+
+// GetStateOpen returns the state of an open cover
+func (e *Entity) GetStateOpen() string {
+	if e.StateOpen != "" {
+		return e.StateOpen
+	}
+	return "open"
+}
+
+// GetStateClosed returns the state of a closed cover
+func (e *Entity) GetStateClosed() string {
+	if e.StateClosed != "" {
+		return e.StateClosed
+	}
+	return "closed"
+}
+
+// GetClimateModes returns the Home Assistant modes of a climate entity
+func (e *Entity) GetClimateModes() []string {
+	if len(e.Modes) > 0 {
+		return e.Modes
+	}
+	return DefaultClimateModes
+}
+
+// encodeBrightness converts a Home Assistant brightness of 0-255 to a percentage
+func encodeBrightness(value string) (string, bool) {
+	brightness, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || brightness < 0 || brightness > 255 {
+		return "", false
+	}
+	return strconv.Itoa(int(math.Round(brightness * 100 / 255))), true
+}
+
+// encodePercentage accepts numbers from 0 to 100
+func encodePercentage(value string) (string, bool) {
+	percentage, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || percentage < 0 || percentage > 100 {
+		return "", false
+	}
+	return strconv.FormatFloat(percentage, 'f', -1, 64), true
+}
+
+// decodeBrightness converts a percentage to a Home Assistant brightness of 0-255
+func decodeBrightness(payload string) string {
+	percentage, err := strconv.ParseFloat(strings.TrimSpace(payload), 64)
+	if err != nil {
+		return payload
+	}
+	percentage = math.Max(0, math.Min(100, percentage))
+	return strconv.Itoa(int(math.Round(percentage * 255 / 100)))
+}
+
+// colorPayload is a color of the Home Assistant JSON schema. A whole JSON light state carries
+// it in its color field.
+type colorPayload struct {
+	R     *int          `json:"r"`
+	G     *int          `json:"g"`
+	B     *int          `json:"b"`
+	W     *int          `json:"w"`
+	Color *colorPayload `json:"color"`
+}
+
+// encodeColor converts an "r,g,b[,w]" value or a JSON color to the RRGGBB[WW] hex payload of MySensors
+func encodeColor(value string, channels int) (string, bool) {
+	value = strings.TrimSpace(value)
+	var components []int
+	if strings.HasPrefix(value, "{") {
+		var valid bool
+		if components, valid = jsonColorComponents(value, channels); !valid {
+			return "", false
+		}
+	} else {
+		parts := strings.Split(value, ",")
+		if len(parts) != channels {
+			return "", false
+		}
+		components = make([]int, 0, channels)
+		for _, part := range parts {
+			component, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return "", false
+			}
+			components = append(components, component)
+		}
+	}
+
+	var hex strings.Builder
+	for _, component := range components {
+		if component < 0 || component > 255 {
+			return "", false
+		}
+		fmt.Fprintf(&hex, "%02x", component)
+	}
+	return hex.String(), true
+}
+
+// jsonColorComponents returns the components of a JSON color, or of the color of a JSON light
+// state. A white component is needed for four channels and rejected for three.
+func jsonColorComponents(value string, channels int) ([]int, bool) {
+	var color colorPayload
+	if err := json.Unmarshal([]byte(value), &color); err != nil {
+		return nil, false
+	}
+	if color.Color != nil {
+		if color.R != nil || color.G != nil || color.B != nil || color.W != nil {
+			return nil, false
+		}
+		color = *color.Color
+	}
+	if color.Color != nil || (color.W != nil) != (channels == 4) {
+		return nil, false
+	}
+
+	components := make([]int, 0, channels)
+	for _, component := range []*int{color.R, color.G, color.B, color.W}[:channels] {
+		if component == nil {
+			return nil, false
+		}
+		components = append(components, *component)
+	}
+	return components, true
+}
+
+// decodeColor converts an RRGGBB[WW] hex payload to the "r,g,b[,w]" value of Home Assistant.
+// Payloads that are not valid colors are passed through.
+func decodeColor(payload string, channels int) string {
+	hex := strings.TrimPrefix(strings.TrimSpace(payload), "#")
+	if len(hex) != channels*2 {
+		return payload
+	}
+	components := make([]string, channels)
+	for i := range components {
+		component, err := strconv.ParseUint(hex[i*2:i*2+2], 16, 8)
+		if err != nil {
+			return payload
+		}
+		components[i] = strconv.FormatUint(component, 10)
+	}
+	return strings.Join(components, ",")
+}
+
 // Entity helper functions
 
 // IsReadOnly returns true if the entity is read-only (sensor)
@@ -497,6 +1605,23 @@ func GetMySensorsVariableTypeForEntity(entityType, variableTypeOverride string)
 			"V_VAR":                mysensors.V_VAR,
 			"V_VA":                 mysensors.V_VA,
 			"V_POWER_FACTOR":       mysensors.V_POWER_FACTOR,
+			"V_TRIPPED":            mysensors.V_TRIPPED,
+			"V_ARMED":              mysensors.V_ARMED,
+			"V_LOCK_STATUS":        mysensors.V_LOCK_STATUS,
+			"V_HVAC_FLOW_STATE":    mysensors.V_HVAC_FLOW_STATE,
+			"V_HVAC_SPEED":         mysensors.V_HVAC_SPEED,
+			"V_FORECAST":           mysensors.V_FORECAST,
+			"V_RAIN":               mysensors.V_RAIN,
+			"V_RAINRATE":           mysensors.V_RAINRATE,
+			"V_WIND":               mysensors.V_WIND,
+			"V_GUST":               mysensors.V_GUST,
+			"V_DIRECTION":          mysensors.V_DIRECTION,
+			"V_UV":                 mysensors.V_UV,
+			"V_IMPEDANCE":          mysensors.V_IMPEDANCE,
+			"V_SCENE_ON":           mysensors.V_SCENE_ON,
+			"V_SCENE_OFF":          mysensors.V_SCENE_OFF,
+			"V_IR_RECEIVE":         mysensors.V_IR_RECEIVE,
+			"V_ID":                 mysensors.V_ID,
 		}
 		
 		if varType, exists := mapping[variableTypeOverride]; exists {
@@ -561,6 +1686,103 @@ func GetMySensorsVariableTypeForEntity(entityType, variableTypeOverride string)
 	return mysensors.V_STATUS, false
 }
 
+// SensorTypeMapping describes how a presented MySensors sensor type maps to an entity
+type SensorTypeMapping struct {
+	EntityType   string
+	VariableType string // Variable type override, empty to use the entity type default
+	DeviceClass  string
+	ReadOnly     bool
+}
+
+// GetEntityMappingForSensorType returns the entity mapping for a presented MySensors sensor type
+func GetEntityMappingForSensorType(sensorType mysensors.SensorType) (SensorTypeMapping, bool) {
+	mapping := map[mysensors.SensorType]SensorTypeMapping{
+		mysensors.S_DOOR:          {EntityType: "binary_sensor", VariableType: "V_TRIPPED", DeviceClass: "door", ReadOnly: true},
+		mysensors.S_MOTION:        {EntityType: "binary_sensor", VariableType: "V_TRIPPED", DeviceClass: "motion", ReadOnly: true},
+		mysensors.S_SMOKE:         {EntityType: "binary_sensor", VariableType: "V_TRIPPED", DeviceClass: "smoke", ReadOnly: true},
+		mysensors.S_WATER_LEAK:    {EntityType: "binary_sensor", VariableType: "V_TRIPPED", DeviceClass: "moisture", ReadOnly: true},
+		mysensors.S_BINARY:        {EntityType: "switch"},
+		mysensors.S_SPRINKLER:     {EntityType: "switch"},
+		mysensors.S_LOCK:          {EntityType: "switch", VariableType: "V_LOCK_STATUS"},
+		mysensors.S_DIMMER:        {EntityType: "dimmer"},
+		mysensors.S_COVER:         {EntityType: "cover"},
+		mysensors.S_HVAC:          {EntityType: "climate"},
+		mysensors.S_HEATER:        {EntityType: "climate"},
+		mysensors.S_RGB_LIGHT:     {EntityType: "rgb_light"},
+		mysensors.S_RGBW_LIGHT:    {EntityType: "rgbw_light"},
+		mysensors.S_INFO:          {EntityType: "text"},
+		mysensors.S_TEMP:          {EntityType: "temperature", DeviceClass: "temperature", ReadOnly: true},
+		mysensors.S_HUM:           {EntityType: "humidity", DeviceClass: "humidity", ReadOnly: true},
+		mysensors.S_BARO:          {EntityType: "pressure", DeviceClass: "atmospheric_pressure", ReadOnly: true},
+		mysensors.S_WIND:          {EntityType: "wind", DeviceClass: "wind_speed", ReadOnly: true},
+		mysensors.S_RAIN:          {EntityType: "rain", ReadOnly: true},
+		mysensors.S_UV:            {EntityType: "uv", ReadOnly: true},
+		mysensors.S_WEIGHT:        {EntityType: "weight", DeviceClass: "weight", ReadOnly: true},
+		mysensors.S_POWER:         {EntityType: "watt", DeviceClass: "power", ReadOnly: true},
+		mysensors.S_DISTANCE:      {EntityType: "distance", DeviceClass: "distance", ReadOnly: true},
+		mysensors.S_LIGHT_LEVEL:   {EntityType: "light_level", DeviceClass: "illuminance", ReadOnly: true},
+		mysensors.S_WATER:         {EntityType: "volume", ReadOnly: true},
+		mysensors.S_GAS:           {EntityType: "flow", ReadOnly: true},
+		mysensors.S_AIR_QUALITY:   {EntityType: "level", ReadOnly: true},
+		mysensors.S_DUST:          {EntityType: "level", ReadOnly: true},
+		mysensors.S_SOUND:         {EntityType: "level", ReadOnly: true},
+		mysensors.S_VIBRATION:     {EntityType: "level", ReadOnly: true},
+		mysensors.S_MOISTURE:      {EntityType: "level", DeviceClass: "moisture", ReadOnly: true},
+		mysensors.S_MULTIMETER:    {EntityType: "voltage", DeviceClass: "voltage", ReadOnly: true},
+		mysensors.S_WATER_QUALITY: {EntityType: "ph", ReadOnly: true},
+		mysensors.S_CUSTOM:        {EntityType: "custom", ReadOnly: true},
+	}
+
+	entityMapping, exists := mapping[sensorType]
+	return entityMapping, exists
+}
+
+// GetEntityMappingForVariableType returns a best-guess entity mapping for a child that was only
+// seen sending values of the given variable type, without a presentation
+func GetEntityMappingForVariableType(varType mysensors.VariableType) (SensorTypeMapping, bool) {
+	mapping := map[mysensors.VariableType]SensorTypeMapping{
+		mysensors.V_STATUS:             {EntityType: "switch"},
+		mysensors.V_LOCK_STATUS:        {EntityType: "switch", VariableType: "V_LOCK_STATUS"},
+		mysensors.V_TRIPPED:            {EntityType: "binary_sensor", VariableType: "V_TRIPPED", ReadOnly: true},
+		mysensors.V_RGB:                {EntityType: "rgb_light"},
+		mysensors.V_RGBW:               {EntityType: "rgbw_light"},
+		mysensors.V_HVAC_SETPOINT_HEAT: {EntityType: "climate"},
+		mysensors.V_TEXT:               {EntityType: "text"},
+		mysensors.V_TEMP:               {EntityType: "temperature", DeviceClass: "temperature", ReadOnly: true},
+		mysensors.V_HUM:                {EntityType: "humidity", DeviceClass: "humidity", ReadOnly: true},
+		mysensors.V_PERCENTAGE:         {EntityType: "percentage", ReadOnly: true},
+		mysensors.V_PRESSURE:           {EntityType: "pressure", DeviceClass: "atmospheric_pressure", ReadOnly: true},
+		mysensors.V_RAIN:               {EntityType: "rain", ReadOnly: true},
+		mysensors.V_RAINRATE:           {EntityType: "rainrate", ReadOnly: true},
+		mysensors.V_WIND:               {EntityType: "wind", DeviceClass: "wind_speed", ReadOnly: true},
+		mysensors.V_GUST:               {EntityType: "gust", ReadOnly: true},
+		mysensors.V_DIRECTION:          {EntityType: "direction", ReadOnly: true},
+		mysensors.V_UV:                 {EntityType: "uv", ReadOnly: true},
+		mysensors.V_WEIGHT:             {EntityType: "weight", DeviceClass: "weight", ReadOnly: true},
+		mysensors.V_DISTANCE:           {EntityType: "distance", DeviceClass: "distance", ReadOnly: true},
+		mysensors.V_IMPEDANCE:          {EntityType: "impedance", ReadOnly: true},
+		mysensors.V_WATT:               {EntityType: "watt", DeviceClass: "power", ReadOnly: true},
+		mysensors.V_KWH:                {EntityType: "kwh", DeviceClass: "energy", ReadOnly: true},
+		mysensors.V_LIGHT_LEVEL:        {EntityType: "light_level", ReadOnly: true},
+		mysensors.V_FLOW:               {EntityType: "flow", ReadOnly: true},
+		mysensors.V_VOLUME:             {EntityType: "volume", ReadOnly: true},
+		mysensors.V_LEVEL:              {EntityType: "level", ReadOnly: true},
+		mysensors.V_VOLTAGE:            {EntityType: "voltage", DeviceClass: "voltage", ReadOnly: true},
+		mysensors.V_CURRENT:            {EntityType: "current", DeviceClass: "current", ReadOnly: true},
+		mysensors.V_CUSTOM:             {EntityType: "custom", ReadOnly: true},
+		mysensors.V_POSITION:           {EntityType: "position", ReadOnly: true},
+		mysensors.V_PH:                 {EntityType: "ph", ReadOnly: true},
+		mysensors.V_ORP:                {EntityType: "orp", ReadOnly: true},
+		mysensors.V_EC:                 {EntityType: "ec", ReadOnly: true},
+		mysensors.V_VAR:                {EntityType: "var", ReadOnly: true},
+		mysensors.V_VA:                 {EntityType: "va", ReadOnly: true},
+		mysensors.V_POWER_FACTOR:       {EntityType: "power_factor", ReadOnly: true},
+	}
+
+	entityMapping, exists := mapping[varType]
+	return entityMapping, exists
+}
+
 func setDefaults(config *Config) {
 	if config.LogLevel == "" {
 		config.LogLevel = "info"
@@ -584,18 +1806,35 @@ func setDefaults(config *Config) {
 		for name, gatewayConfig := range config.MySensors {
 			delete(config.MySensors, name)
 			config.MySensors["default"] = gatewayConfig
+			renameGatewayReferences(config, name, "default")
 			break
 		}
 	}
 
 	if config.MQTT.Port == 0 {
 		config.MQTT.Port = 1883
+		if config.MQTT.TLS.Enabled {
+			config.MQTT.Port = 8883
+		}
 	}
 
 	if config.MQTT.ClientID == "" {
 		config.MQTT.ClientID = "ms-mqtt-adapter"
 	}
 
+	if config.MQTT.ProtocolVersion == 0 {
+		config.MQTT.ProtocolVersion = 3
+	}
+
+	// Publishes are buffered while the broker is unreachable by default
+	if config.MQTT.Buffer.Enabled == nil {
+		bufferEnabled := true
+		config.MQTT.Buffer.Enabled = &bufferEnabled
+	}
+	if config.MQTT.Buffer.MaxMessages == 0 {
+		config.MQTT.Buffer.MaxMessages = 1000
+	}
+
 	if config.AdapterTopics.Sync.Period == 0 {
 		config.AdapterTopics.Sync.Period = 30 * time.Second
 	}
@@ -625,8 +1864,24 @@ func setDefaults(config *Config) {
 			gatewayConfig.Gateway.RandomIDAssignment = &randomAssignment
 		}
 
+		// Node registry is persisted by default
+		if gatewayConfig.Gateway.NodeRegistry.Enabled == nil {
+			registryEnabled := true
+			gatewayConfig.Gateway.NodeRegistry.Enabled = &registryEnabled
+		}
+
+		// Smart-sleep queueing is enabled by default, it only affects nodes that announce sleep
+		if gatewayConfig.Gateway.SmartSleep.Enabled == nil {
+			smartSleepEnabled := true
+			gatewayConfig.Gateway.SmartSleep.Enabled = &smartSleepEnabled
+		}
+
 		// TCP service is disabled by default and requires explicit port configuration
 
+		if gatewayConfig.RawTopics.Enabled {
+			gatewayConfig.RawTopics.OutPrefix, gatewayConfig.RawTopics.InPrefix = gatewayConfig.RawTopics.Prefixes(gatewayName)
+		}
+
 		config.MySensors[gatewayName] = gatewayConfig
 	}
 
@@ -634,6 +1889,22 @@ func setDefaults(config *Config) {
 		config.AdapterTopics.TopicPrefix = "ms-mqtt-adapter"
 	}
 
+	if config.AdapterTopics.DataDir == "" {
+		config.AdapterTopics.DataDir = "data"
+	}
+
+	if config.AdapterTopics.Firmware.Directory == "" {
+		config.AdapterTopics.Firmware.Directory = filepath.Join(config.AdapterTopics.DataDir, "firmware")
+	}
+
+	if config.AdapterTopics.Availability.Timeout == 0 {
+		config.AdapterTopics.Availability.Timeout = time.Hour
+	}
+
+	if config.AdapterTopics.HomeAssistantStatusTopic == "" {
+		config.AdapterTopics.HomeAssistantStatusTopic = "homeassistant/status"
+	}
+
 	// Default to enabling HomeAssistant discovery if not explicitly set
 	if config.AdapterTopics.HomeAssistantDiscovery == nil {
 		enabled := true
@@ -646,128 +1917,179 @@ func setDefaults(config *Config) {
 		config.AdapterTopics.Optimistic = &optimistic
 	}
 
+	// Entity states use QoS 0 and are retained unless configured otherwise
+	if config.AdapterTopics.QOS == nil {
+		qos := 0
+		config.AdapterTopics.QOS = &qos
+	}
+	if config.AdapterTopics.Retain == nil {
+		retain := true
+		config.AdapterTopics.Retain = &retain
+	}
+
 	// Default to request ACK (helps with device echoing) if not explicitly set
 	if config.AdapterTopics.RequestAck == nil {
 		requestAck := true
 		config.AdapterTopics.RequestAck = &requestAck
 	}
 
-	for i := range config.Devices {
+	// Track command echoes when request_ack is in effect
+	if config.AdapterTopics.CommandTracking.Enabled == nil {
+		trackingEnabled := true
+		config.AdapterTopics.CommandTracking.Enabled = &trackingEnabled
+	}
+
+	if config.AdapterTopics.CommandTracking.Retries == nil {
+		retries := 2
+		config.AdapterTopics.CommandTracking.Retries = &retries
+	}
 
+	if config.AdapterTopics.CommandTracking.Timeout == 0 {
+		config.AdapterTopics.CommandTracking.Timeout = 2 * time.Second
+	}
+
+	for i := range config.Devices {
 		// Set defaults for entities
 		for j := range config.Devices[i].Entities {
-			entity := &config.Devices[i].Entities[j]
-			
-			// Set default initial values based on entity type
-			if entity.InitialValue == "" {
-				switch entity.EntityType {
-				case "switch", "light", "binary_sensor":
-					entity.InitialValue = "0"
-				case "dimmer", "number", "percentage", "level":
-					entity.InitialValue = "0"
-				case "text", "select", "sensor":
-					entity.InitialValue = ""
-				default:
-					entity.InitialValue = "0"
-				}
-			}
-			
-			// Set default units and state class based on entity type
-			switch entity.EntityType {
-			case "temperature":
-				if entity.UnitOfMeasurement == "" {
-					entity.UnitOfMeasurement = "°C"
-				}
-				if entity.StateClass == "" {
-					entity.StateClass = "measurement"
-				}
-			case "humidity", "battery", "percentage", "level":
-				if entity.UnitOfMeasurement == "" {
-					entity.UnitOfMeasurement = "%"
-				}
-				if entity.StateClass == "" {
-					entity.StateClass = "measurement"
-				}
-			case "voltage":
-				if entity.UnitOfMeasurement == "" {
-					entity.UnitOfMeasurement = "V"
-				}
-				if entity.StateClass == "" {
-					entity.StateClass = "measurement"
-				}
-			case "current":
-				if entity.UnitOfMeasurement == "" {
-					entity.UnitOfMeasurement = "A"
-				}
-				if entity.StateClass == "" {
-					entity.StateClass = "measurement"
-				}
-			case "pressure":
-				if entity.UnitOfMeasurement == "" {
-					entity.UnitOfMeasurement = "hPa"
-				}
-				if entity.StateClass == "" {
-					entity.StateClass = "measurement"
-				}
-			case "weight":
-				if entity.UnitOfMeasurement == "" {
-					entity.UnitOfMeasurement = "kg"
-				}
-				if entity.StateClass == "" {
-					entity.StateClass = "measurement"
-				}
-			case "distance":
-				if entity.UnitOfMeasurement == "" {
-					entity.UnitOfMeasurement = "m"
-				}
-				if entity.StateClass == "" {
-					entity.StateClass = "measurement"
-				}
-			case "light_level":
-				if entity.UnitOfMeasurement == "" {
-					entity.UnitOfMeasurement = "lx"
-				}
-				if entity.StateClass == "" {
-					entity.StateClass = "measurement"
-				}
-			case "watt":
-				if entity.UnitOfMeasurement == "" {
-					entity.UnitOfMeasurement = "W"
-				}
-				if entity.StateClass == "" {
-					entity.StateClass = "measurement"
-				}
-			case "kwh":
-				if entity.UnitOfMeasurement == "" {
-					entity.UnitOfMeasurement = "kWh"
-				}
-				if entity.StateClass == "" {
-					entity.StateClass = "total_increasing"
-				}
-			case "flow":
-				if entity.UnitOfMeasurement == "" {
-					entity.UnitOfMeasurement = "m³/h"
-				}
-				if entity.StateClass == "" {
-					entity.StateClass = "measurement"
-				}
-			case "volume":
-				if entity.UnitOfMeasurement == "" {
-					entity.UnitOfMeasurement = "m³"
-				}
-				if entity.StateClass == "" {
-					entity.StateClass = "total_increasing"
-				}
-			case "dimmer", "number":
-				if entity.UnitOfMeasurement == "" && entity.EntityType == "number" {
-					entity.UnitOfMeasurement = ""
-				}
-			case "text", "select", "custom", "sensor", "binary_sensor":
-				// Text, select, and sensor entities don't have default units or state class
-				if entity.StateClass == "" && (entity.EntityType == "text" || entity.EntityType == "select" || entity.EntityType == "binary_sensor") {
-					entity.StateClass = ""
-				}
+			ApplyEntityDefaults(&config.Devices[i].Entities[j])
+		}
+	}
+}
+
+// renameGatewayReferences updates device, entity and override gateway names after a gateway was renamed
+func renameGatewayReferences(config *Config, oldName, newName string) {
+	for i := range config.Devices {
+		if config.Devices[i].Gateway == oldName {
+			config.Devices[i].Gateway = newName
+		}
+		for j := range config.Devices[i].Entities {
+			if config.Devices[i].Entities[j].Gateway == oldName {
+				config.Devices[i].Entities[j].Gateway = newName
 			}
 		}
 	}
+	for i := range config.AdapterTopics.AutoDiscovery.Overrides {
+		if config.AdapterTopics.AutoDiscovery.Overrides[i].Gateway == oldName {
+			config.AdapterTopics.AutoDiscovery.Overrides[i].Gateway = newName
+		}
+	}
+	for i := range config.AdapterTopics.Firmware.Assignments {
+		if config.AdapterTopics.Firmware.Assignments[i].Gateway == oldName {
+			config.AdapterTopics.Firmware.Assignments[i].Gateway = newName
+		}
+	}
+}
+
+// ApplyEntityDefaults fills in default initial value, unit and state class based on entity type
+func ApplyEntityDefaults(entity *Entity) {
+	// Set default initial values based on entity type
+	if entity.InitialValue == "" {
+		switch entity.EntityType {
+		case "switch", "light", "binary_sensor":
+			entity.InitialValue = "0"
+		case "dimmer", "number", "percentage", "level":
+			entity.InitialValue = "0"
+		case "text", "select", "sensor":
+			entity.InitialValue = ""
+		default:
+			entity.InitialValue = "0"
+		}
+	}
+	
+	// Set default units and state class based on entity type
+	switch entity.EntityType {
+	case "temperature":
+		if entity.UnitOfMeasurement == "" {
+			entity.UnitOfMeasurement = "°C"
+		}
+		if entity.StateClass == "" {
+			entity.StateClass = "measurement"
+		}
+	case "humidity", "battery", "percentage", "level":
+		if entity.UnitOfMeasurement == "" {
+			entity.UnitOfMeasurement = "%"
+		}
+		if entity.StateClass == "" {
+			entity.StateClass = "measurement"
+		}
+	case "voltage":
+		if entity.UnitOfMeasurement == "" {
+			entity.UnitOfMeasurement = "V"
+		}
+		if entity.StateClass == "" {
+			entity.StateClass = "measurement"
+		}
+	case "current":
+		if entity.UnitOfMeasurement == "" {
+			entity.UnitOfMeasurement = "A"
+		}
+		if entity.StateClass == "" {
+			entity.StateClass = "measurement"
+		}
+	case "pressure":
+		if entity.UnitOfMeasurement == "" {
+			entity.UnitOfMeasurement = "hPa"
+		}
+		if entity.StateClass == "" {
+			entity.StateClass = "measurement"
+		}
+	case "weight":
+		if entity.UnitOfMeasurement == "" {
+			entity.UnitOfMeasurement = "kg"
+		}
+		if entity.StateClass == "" {
+			entity.StateClass = "measurement"
+		}
+	case "distance":
+		if entity.UnitOfMeasurement == "" {
+			entity.UnitOfMeasurement = "m"
+		}
+		if entity.StateClass == "" {
+			entity.StateClass = "measurement"
+		}
+	case "light_level":
+		if entity.UnitOfMeasurement == "" {
+			entity.UnitOfMeasurement = "lx"
+		}
+		if entity.StateClass == "" {
+			entity.StateClass = "measurement"
+		}
+	case "watt":
+		if entity.UnitOfMeasurement == "" {
+			entity.UnitOfMeasurement = "W"
+		}
+		if entity.StateClass == "" {
+			entity.StateClass = "measurement"
+		}
+	case "kwh":
+		if entity.UnitOfMeasurement == "" {
+			entity.UnitOfMeasurement = "kWh"
+		}
+		if entity.StateClass == "" {
+			entity.StateClass = "total_increasing"
+		}
+	case "flow":
+		if entity.UnitOfMeasurement == "" {
+			entity.UnitOfMeasurement = "m³/h"
+		}
+		if entity.StateClass == "" {
+			entity.StateClass = "measurement"
+		}
+	case "volume":
+		if entity.UnitOfMeasurement == "" {
+			entity.UnitOfMeasurement = "m³"
+		}
+		if entity.StateClass == "" {
+			entity.StateClass = "total_increasing"
+		}
+	case "dimmer", "number":
+		if entity.UnitOfMeasurement == "" && entity.EntityType == "number" {
+			entity.UnitOfMeasurement = ""
+		}
+	case "text", "select", "custom", "sensor", "binary_sensor":
+		// Text, select, and sensor entities don't have default units or state class
+		if entity.StateClass == "" && (entity.EntityType == "text" || entity.EntityType == "select" || entity.EntityType == "binary_sensor") {
+			entity.StateClass = ""
+		}
+	}
 }
@@ -0,0 +1,305 @@
+package config
+
+import (
+	"ms-mqtt-adapter/internal/mysensors"
+	"testing"
+	"time"
+)
+
+func TestCoverStateAttribute(t *testing.T) {
+	entity := &Entity{EntityType: "cover", PayloadOpen: "UP", PayloadClose: "DOWN"}
+	attribute, exists := entity.Attribute(StateAttribute)
+	if !exists {
+		t.Fatal("cover has no state attribute")
+	}
+
+	tests := []struct {
+		value       string
+		wantVarType mysensors.VariableType
+		valid       bool
+	}{
+		{"UP", mysensors.V_UP, true},
+		{"DOWN", mysensors.V_DOWN, true},
+		{"STOP", mysensors.V_STOP, true},
+		{"OPEN", 0, false},
+	}
+	for _, tt := range tests {
+		varType, payload, valid := attribute.EncodeCommand(tt.value)
+		if valid != tt.valid {
+			t.Errorf("EncodeCommand(%q) valid = %t, want %t", tt.value, valid, tt.valid)
+			continue
+		}
+		if !valid {
+			continue
+		}
+		if varType != tt.wantVarType || payload != "1" {
+			t.Errorf("EncodeCommand(%q) = %s %q, want %s \"1\"", tt.value, varType, payload, tt.wantVarType)
+		}
+		if got := attribute.DecodeCommand(varType, payload); got != tt.value {
+			t.Errorf("DecodeCommand(%s, %q) = %q, want %q", varType, payload, got, tt.value)
+		}
+	}
+
+	// The payload alone does not tell the command
+	if got := attribute.Decode("1"); got != "1" {
+		t.Errorf("Decode(\"1\") = %q, want the payload", got)
+	}
+}
+
+func TestDimmerAttributes(t *testing.T) {
+	dimmer := &Entity{EntityType: "dimmer"}
+	state, hasState := dimmer.Attribute(StateAttribute)
+	brightness, hasBrightness := dimmer.Attribute("brightness")
+	if !hasState || !hasBrightness || len(dimmer.Attributes()) != 2 {
+		t.Fatalf("dimmer has attributes %v, want state and brightness", dimmer.Attributes())
+	}
+	if state.VariableType != mysensors.V_STATUS || brightness.VariableType != mysensors.V_PERCENTAGE {
+		t.Errorf("variables = %s, %s, want V_STATUS, V_PERCENTAGE", state.VariableType, brightness.VariableType)
+	}
+
+	// Brightness is 0-255 in Home Assistant and 0-100 at the node
+	if varType, payload, valid := brightness.EncodeCommand("255"); !valid || varType != mysensors.V_PERCENTAGE || payload != "100" {
+		t.Errorf("EncodeCommand(\"255\") = %s %q %t, want V_PERCENTAGE \"100\"", varType, payload, valid)
+	}
+
+	custom := &Entity{EntityType: "dimmer", Variables: map[string]string{"state": "", "brightness": "V_LEVEL"}}
+	if brightness, _ := custom.Attribute("brightness"); brightness.VariableType != mysensors.V_LEVEL {
+		t.Errorf("brightness variable = %s, want V_LEVEL", brightness.VariableType)
+	}
+}
+
+func TestValidateVariableType(t *testing.T) {
+	tests := []struct {
+		name    string
+		entity  Entity
+		wantErr bool
+	}{
+		{"switch override", Entity{EntityType: "switch", VariableType: "V_LIGHT"}, false},
+		{"dimmer", Entity{EntityType: "dimmer", VariableType: "V_DIMMER"}, true},
+		{"dimmer with variables", Entity{EntityType: "dimmer", VariableType: "V_DIMMER", Variables: map[string]string{"state": ""}}, true},
+		{"climate", Entity{EntityType: "climate", VariableType: "V_TEMP"}, true},
+		{"cover", Entity{EntityType: "cover", VariableType: "V_PERCENTAGE"}, true},
+		{"rgb light", Entity{EntityType: "rgb_light", VariableType: "V_RGB"}, true},
+		{"rgbw light", Entity{EntityType: "rgbw_light", VariableType: "V_RGBW"}, true},
+		{"cover without override", Entity{EntityType: "cover"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateVariableType(&tt.entity)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateVariableType() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncodeBrightness(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		valid bool
+	}{
+		{"0", "0", true},
+		{"255", "100", true},
+		{"128", "50", true},
+		{"1", "0", true},
+		{" 51 ", "20", true},
+		{"127.5", "50", true},
+		{"256", "", false},
+		{"-1", "", false},
+		{"bright", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		got, valid := encodeBrightness(tt.value)
+		if got != tt.want || valid != tt.valid {
+			t.Errorf("encodeBrightness(%q) = %q, %t, want %q, %t", tt.value, got, valid, tt.want, tt.valid)
+		}
+	}
+}
+
+func TestDecodeBrightness(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    string
+	}{
+		{"0", "0"},
+		{"100", "255"},
+		{"50", "128"},
+		{"20", "51"},
+		{"150", "255"},
+		{"-10", "0"},
+		{"dim", "dim"},
+	}
+
+	for _, tt := range tests {
+		if got := decodeBrightness(tt.payload); got != tt.want {
+			t.Errorf("decodeBrightness(%q) = %q, want %q", tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeColor(t *testing.T) {
+	tests := []struct {
+		value    string
+		channels int
+		want     string
+		valid    bool
+	}{
+		{"255,128,0", 3, "ff8000", true},
+		{"0,0,0", 3, "000000", true},
+		{" 1, 2 ,3 ", 3, "010203", true},
+		{"255,255,255,255", 4, "ffffffff", true},
+		{"10,20,30,40", 4, "0a141e28", true},
+		{"255,128,0", 4, "", false},
+		{"255,128,0,0", 3, "", false},
+		{"256,0,0", 3, "", false},
+		{"-1,0,0", 3, "", false},
+		{"red,0,0", 3, "", false},
+		{"ff8000", 3, "", false},
+		{`{"r":255,"g":128,"b":0}`, 3, "ff8000", true},
+		{` {"r": 1, "g": 2, "b": 3} `, 3, "010203", true},
+		{`{"r":10,"g":20,"b":30,"w":40}`, 4, "0a141e28", true},
+		{`{"state":"ON","color":{"r":255,"g":128,"b":0}}`, 3, "ff8000", true},
+		{`{"state":"ON","brightness":255,"color":{"r":10,"g":20,"b":30,"w":40}}`, 4, "0a141e28", true},
+		{`{"r":255,"g":128}`, 3, "", false},
+		{`{"r":255,"g":128,"b":0}`, 4, "", false},
+		{`{"r":255,"g":128,"b":0,"w":0}`, 3, "", false},
+		{`{"r":256,"g":0,"b":0}`, 3, "", false},
+		{`{"r":-1,"g":0,"b":0}`, 3, "", false},
+		{`{"r":1.5,"g":0,"b":0}`, 3, "", false},
+		{`{"r":"255","g":0,"b":0}`, 3, "", false},
+		{`{"color":{"color":{"r":1,"g":2,"b":3}}}`, 3, "", false},
+		{`{"r":1,"color":{"r":1,"g":2,"b":3}}`, 3, "", false},
+		{`{"state":"ON"}`, 3, "", false},
+		{`{"r":255,"g":128,"b":0`, 3, "", false},
+	}
+
+	for _, tt := range tests {
+		got, valid := encodeColor(tt.value, tt.channels)
+		if got != tt.want || valid != tt.valid {
+			t.Errorf("encodeColor(%q, %d) = %q, %t, want %q, %t", tt.value, tt.channels, got, valid, tt.want, tt.valid)
+		}
+	}
+}
+
+func TestDecodeColor(t *testing.T) {
+	tests := []struct {
+		payload  string
+		channels int
+		want     string
+	}{
+		{"ff8000", 3, "255,128,0"},
+		{"FF8000", 3, "255,128,0"},
+		{"#ff8000", 3, "255,128,0"},
+		{"0a141e28", 4, "10,20,30,40"},
+		{"#0A141E28", 4, "10,20,30,40"},
+		{"ff8000", 4, "ff8000"},
+		{"0a141e28", 3, "0a141e28"},
+		{"gg8000", 3, "gg8000"},
+		{"", 3, ""},
+	}
+
+	for _, tt := range tests {
+		if got := decodeColor(tt.payload, tt.channels); got != tt.want {
+			t.Errorf("decodeColor(%q, %d) = %q, want %q", tt.payload, tt.channels, got, tt.want)
+		}
+	}
+}
+
+func TestCheckEntityTopics(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		devices  []Device
+		wantErr  bool
+	}{
+		{
+			name: "default template",
+			devices: []Device{
+				{ID: "a", NodeID: 1, Entities: []Entity{{ID: "light", ChildID: 1}}},
+				{ID: "b", NodeID: 2, Entities: []Entity{{ID: "light", ChildID: 1}}},
+			},
+		},
+		{
+			name:     "template without device",
+			template: "{prefix}/{entity_id}",
+			devices: []Device{
+				{ID: "a", NodeID: 1, Entities: []Entity{{ID: "light", ChildID: 1}}},
+				{ID: "b", NodeID: 2, Entities: []Entity{{ID: "light", ChildID: 1}}},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "discovered device on another node",
+			template: "{prefix}/{node_id}/{child_id}",
+			devices: []Device{
+				{ID: "a", NodeID: 1, Entities: []Entity{{ID: "light", ChildID: 1}}},
+				{ID: "node_2", NodeID: 2, Entities: []Entity{{ID: "child_1", ChildID: 1}}},
+			},
+		},
+		{
+			name:     "device template overlapping a discovered device",
+			template: "{prefix}/{node_id}/{child_id}",
+			devices: []Device{
+				{ID: "a", NodeID: 1, TopicTemplate: "{prefix}/2/{child_id}", Entities: []Entity{{ID: "light", ChildID: 1}}},
+				{ID: "node_2", NodeID: 2, Entities: []Entity{{ID: "child_1", ChildID: 1}}},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "attribute topic overlapping another entity",
+			template: "{prefix}/{device_id}/{entity_id}",
+			devices: []Device{
+				{ID: "a", NodeID: 1, Entities: []Entity{
+					{ID: "blind", ChildID: 1, EntityType: "cover"},
+					{ID: "blind/position", ChildID: 2},
+				}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := &AdapterConfig{TopicPrefix: "msa", TopicTemplate: tt.template}
+			err := adapter.CheckEntityTopics(tt.devices)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckEntityTopics() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSyncPeriod(t *testing.T) {
+	tests := []struct {
+		name          string
+		period        time.Duration
+		gatewayPeriod time.Duration
+		wantErr       bool
+	}{
+		{"defaults", 0, 0, false},
+		{"global period", time.Minute, 0, false},
+		{"gateway period", 0, time.Minute, false},
+		{"negative global period", -time.Second, 0, true},
+		{"negative gateway period", time.Minute, -time.Second, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := MySensorsConfig{Transport: "rs485"}
+			gateway.Sync.Period = tt.gatewayPeriod
+			config := &Config{
+				MySensors: map[string]MySensorsConfig{"default": gateway},
+				MQTT:      MQTTConfig{Broker: "localhost"},
+			}
+			config.AdapterTopics.Sync.Period = tt.period
+			err := validateConfig(config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
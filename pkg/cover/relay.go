@@ -0,0 +1,605 @@
+package cover
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	directionStopped = 0
+	directionUp      = 1
+	directionDown    = -1
+)
+
+// reportWindow is how long a report of a relay may take to arrive after the relay was switched.
+// Reports within the window that match a switch sent by the manager are not changes at the node.
+const reportWindow = 5 * time.Second
+
+// Config describes the relays and travel times of a cover driven by two relays
+type Config struct {
+	UpChildID      int
+	DownChildID    int
+	TravelTimeUp   time.Duration // Time from closed to open
+	TravelTimeDown time.Duration // Time from open to closed
+	DeadTime       time.Duration // Pause between a confirmed off and switching on the other relay
+}
+
+// Driver switches the relays of a cover and publishes what the cover does. The outcome of every
+// switch must be reported back with RelayConfirmed or RelayFailed using its id.
+type Driver interface {
+	SwitchRelay(childID int, on bool, id uint64) error
+	PublishCover(state string, position int)
+}
+
+// stopper is a scheduled call that can be cancelled, such as a *time.Timer
+type stopper interface {
+	Stop() bool
+}
+
+// relaySwitch is a switch of a relay sent by the manager whose report may still arrive
+type relaySwitch struct {
+	childID int
+	on      bool
+	at      time.Time
+}
+
+// relayStatus is what the manager knows about the last switch of a relay
+type relayStatus int
+
+const (
+	relayConfirmed relayStatus = iota // The node confirmed the switch
+	relayPending                      // Waiting for the node to confirm the switch
+	relayUnknown                      // The node did not confirm the switch
+)
+
+// relayState is the last switch of a relay
+type relayState struct {
+	on          bool
+	status      relayStatus
+	id          uint64 // Passed to the driver, 0 if the manager never switched the relay
+	confirmedAt time.Time
+}
+
+// move is a movement waiting for the relay of the other direction to be off
+type move struct {
+	direction int
+	target    float64
+	duration  time.Duration
+}
+
+// relayCover is the estimated state of one cover. Positions run from 0 (closed) to 100 (open).
+type relayCover struct {
+	cfg       Config
+	driver    Driver
+	position  float64 // Position when the current movement started, or the resting position
+	direction int
+	target    float64
+	startedAt time.Time
+	timer     stopper             // Ends the movement, or starts the waiting one after the dead time
+	relays    map[int]*relayState // childID -> last switch
+	next      *move               // Movement waiting for the other relay to be off
+	switches  []relaySwitch       // Switches sent within the report window, oldest first
+}
+
+// Manager moves covers that have no position feedback by switching an up and a down relay for
+// the time it takes to reach a position. The estimated positions survive restarts.
+//
+// A relay is only switched on once the node confirmed that the relay of the other direction is
+// off and the dead time has passed since. If the node does not confirm the off, the cover does
+// not move in the other direction.
+type Manager struct {
+	path      string
+	logger    *slog.Logger
+	covers    map[string]*relayCover
+	positions map[string]float64 // Persisted positions of covers not added yet
+	switchID  uint64             // ID of the last relay switch
+	mu        sync.Mutex
+
+	now       func() time.Time
+	afterFunc func(d time.Duration, f func()) stopper
+}
+
+// NewManager creates a manager that stores positions as covers.json in dataDir
+func NewManager(dataDir string, logger *slog.Logger) *Manager {
+	return &Manager{
+		path:      filepath.Join(dataDir, "covers.json"),
+		logger:    logger,
+		covers:    make(map[string]*relayCover),
+		positions: make(map[string]float64),
+		now:       time.Now,
+		afterFunc: func(d time.Duration, f func()) stopper { return time.AfterFunc(d, f) },
+	}
+}
+
+// Load reads the persisted positions. A missing file is not an error.
+func (m *Manager) Load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read cover positions: %w", err)
+	}
+	if err := json.Unmarshal(data, &m.positions); err != nil {
+		return fmt.Errorf("failed to parse cover positions %s: %w", m.path, err)
+	}
+	m.logger.Info("Loaded cover positions", "path", m.path, "covers", len(m.positions))
+	return nil
+}
+
+// Add starts managing a cover and publishes its state. A cover without a persisted position is
+// assumed closed with both relays off. Adding a cover again stops it and keeps its position and
+// what is known about its relays.
+func (m *Manager) Add(key string, cfg Config, driver Driver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	position := m.positions[key]
+	relays := map[int]*relayState{
+		cfg.UpChildID:   {status: relayConfirmed},
+		cfg.DownChildID: {status: relayConfirmed},
+	}
+	if existing, exists := m.covers[key]; exists {
+		m.halt(key, existing)
+		position = existing.position
+		for childID, state := range existing.relays {
+			if _, used := relays[childID]; used {
+				relays[childID] = state
+			}
+		}
+	}
+
+	c := &relayCover{cfg: cfg, driver: driver, position: position, relays: relays}
+	m.covers[key] = c
+	m.publish(c)
+}
+
+// Open moves a cover to the open position
+func (m *Manager) Open(key string) error {
+	return m.SetPosition(key, 100)
+}
+
+// Close moves a cover to the closed position
+func (m *Manager) Close(key string) error {
+	return m.SetPosition(key, 0)
+}
+
+// SetPosition moves a cover to a position between 0 (closed) and 100 (open). Moving to an end
+// the cover is already at runs the full travel time, so that the estimate recovers from drift.
+// A cover moving the other way stops first and only starts again once its relay is confirmed off.
+func (m *Manager) SetPosition(key string, target float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, exists := m.covers[key]
+	if !exists {
+		return fmt.Errorf("unknown cover %s", key)
+	}
+	target = math.Max(0, math.Min(100, target))
+
+	now := m.now()
+	position := c.estimate(now)
+	direction := directionUp
+	if target < position || (target == 0 && position == 0) {
+		direction = directionDown
+	}
+	distance := math.Abs(target - position)
+	if distance == 0 {
+		if target != 0 && target != 100 {
+			return m.halt(key, c)
+		}
+		distance = 100
+	}
+	duration := time.Duration(float64(c.travelTime(direction)) * distance / 100)
+
+	if c.direction == direction {
+		// Already moving that way, only the end of the movement changes
+		m.run(key, c, now, direction, target, duration)
+		return nil
+	}
+
+	if c.direction != directionStopped {
+		err := m.switchOff(c)
+		m.settle(key, c, now)
+		if err != nil {
+			m.publish(c)
+			return fmt.Errorf("failed to switch relay off: %w", err)
+		}
+	}
+	c.next = &move{direction: direction, target: target, duration: duration}
+	err := m.advance(key, c)
+	m.publish(c)
+	return err
+}
+
+// StopCover stops a moving cover at its estimated position
+func (m *Manager) StopCover(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, exists := m.covers[key]
+	if !exists {
+		return fmt.Errorf("unknown cover %s", key)
+	}
+	return m.halt(key, c)
+}
+
+// RelayConfirmed records that the node confirmed a relay switch. A confirmed off starts the
+// movement waiting for it once the dead time has passed.
+func (m *Manager) RelayConfirmed(key string, id uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, exists := m.covers[key]
+	if !exists {
+		return
+	}
+	childID, state := c.lastSwitch(id)
+	if state == nil || state.status != relayPending {
+		// A newer switch of the relay was sent in the meantime
+		return
+	}
+	state.status = relayConfirmed
+	state.confirmedAt = m.now()
+
+	if state.on {
+		if c.direction == directionStopped || c.relay(c.direction) != childID {
+			// The movement it was switched on for did not start
+			m.logger.Warn("Relay on without a movement, switching it off", "cover", key, "child_id", childID)
+			if err := m.switchRelay(c, childID, false); err != nil {
+				m.logger.Error("Failed to switch relay off", "cover", key, "error", err)
+			}
+		}
+		return
+	}
+	if err := m.advance(key, c); err != nil {
+		m.logger.Error("Failed to move cover", "cover", key, "error", err)
+	}
+	m.publish(c)
+}
+
+// RelayFailed records that the node did not confirm a relay switch, or that a newer command
+// replaced it. Only the last switch of a relay counts. The state of the relay is unknown
+// afterwards: a cover moving with it stops, and a movement waiting for it to be off is dropped.
+func (m *Manager) RelayFailed(key string, id uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, exists := m.covers[key]
+	if !exists {
+		return
+	}
+	childID, state := c.lastSwitch(id)
+	if state == nil || state.status != relayPending {
+		return
+	}
+	state.status = relayUnknown
+
+	if state.on {
+		m.logger.Error("Relay on not confirmed by node, stopping cover", "cover", key, "child_id", childID)
+		c.next = nil
+		if c.direction != directionStopped && c.relay(c.direction) == childID {
+			if err := m.halt(key, c); err != nil {
+				m.logger.Error("Failed to stop cover", "cover", key, "error", err)
+			}
+			return
+		}
+		if err := m.switchRelay(c, childID, false); err != nil {
+			m.logger.Error("Failed to switch relay off", "cover", key, "error", err)
+		}
+		m.publish(c)
+		return
+	}
+
+	if c.next != nil {
+		m.logger.Error("Relay off not confirmed by node, not moving the cover the other way", "cover", key, "child_id", childID)
+		c.next = nil
+		m.stopTimer(c)
+	}
+	m.publish(c)
+}
+
+// RelayChanged follows relays switched at the node, for example by a wall switch. The echoes of
+// commands sent by the manager must not be passed here. Reports of switches the manager sent
+// recently are recognised and ignored, even when they arrive after a later switch of the relay.
+func (m *Manager) RelayChanged(key string, childID int, on bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, exists := m.covers[key]
+	if !exists {
+		return
+	}
+	now := m.now()
+	if c.reported(childID, on, now) {
+		return
+	}
+	if state, known := c.relays[childID]; known {
+		state.on = on
+		state.status = relayConfirmed
+		state.confirmedAt = now
+	}
+	direction := directionUp
+	if childID == c.cfg.DownChildID {
+		direction = directionDown
+	}
+
+	if !on {
+		if c.direction == direction {
+			m.logger.Info("Cover stopped at the node", "cover", key)
+			m.settle(key, c, now)
+		}
+		if err := m.advance(key, c); err != nil {
+			m.logger.Error("Failed to move cover", "cover", key, "error", err)
+		}
+		m.publish(c)
+		return
+	}
+	if c.direction == direction {
+		return
+	}
+
+	// The node started the cover, follow it until the end is reached
+	c.next = nil
+	if err := m.switchOff(c); err != nil {
+		m.logger.Error("Failed to switch relay off", "cover", key, "error", err)
+	}
+	target := 0.0
+	if direction == directionUp {
+		target = 100
+	}
+	position := c.estimate(now)
+	duration := time.Duration(float64(c.travelTime(direction)) * math.Abs(target-position) / 100)
+	m.logger.Info("Cover started at the node", "cover", key, "position", math.Round(position), "target", target)
+	m.run(key, c, now, direction, target, duration)
+}
+
+// Stop switches off the relays of moving covers, drops waiting movements and saves the positions
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, c := range m.covers {
+		if c.direction != directionStopped || c.next != nil {
+			if err := m.halt(key, c); err != nil {
+				m.logger.Error("Failed to stop cover", "cover", key, "error", err)
+			}
+		}
+	}
+}
+
+// advance starts the waiting movement of a cover once the relay of the other direction is
+// confirmed off and the dead time has passed. Until then it switches that relay off or waits for
+// the node to confirm the off.
+func (m *Manager) advance(key string, c *relayCover) error {
+	next := c.next
+	if next == nil {
+		return nil
+	}
+	m.stopTimer(c)
+
+	otherID := c.relay(-next.direction)
+	other := c.relays[otherID]
+	switch {
+	case other.on || other.status == relayUnknown:
+		if err := m.switchRelay(c, otherID, false); err != nil {
+			return fmt.Errorf("failed to switch relay off: %w", err)
+		}
+		return nil
+	case other.status == relayPending:
+		return nil
+	}
+
+	if wait := c.cfg.DeadTime - m.now().Sub(other.confirmedAt); wait > 0 {
+		c.timer = m.afterFunc(wait, func() { m.resume(key, c, next) })
+		return nil
+	}
+
+	c.next = nil
+	if err := m.switchRelay(c, c.relay(next.direction), true); err != nil {
+		return fmt.Errorf("failed to switch relay on: %w", err)
+	}
+	m.run(key, c, m.now(), next.direction, next.target, next.duration)
+	return nil
+}
+
+// resume starts a waiting movement after the dead time
+func (m *Manager) resume(key string, c *relayCover, next *move) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.covers[key] != c || c.next != next {
+		// Stopped or superseded in the meantime
+		return
+	}
+	c.timer = nil
+	if err := m.advance(key, c); err != nil {
+		m.logger.Error("Failed to move cover", "cover", key, "error", err)
+		m.publish(c)
+	}
+}
+
+// run moves a cover whose relay is on towards a target and publishes that it moves
+func (m *Manager) run(key string, c *relayCover, now time.Time, direction int, target float64, duration time.Duration) {
+	m.stopTimer(c)
+	c.position = c.estimate(now)
+	c.direction = direction
+	c.target = target
+	c.startedAt = now
+	c.timer = m.afterFunc(duration, func() { m.finish(key, c, now) })
+
+	m.logger.Info("Moving cover", "cover", key, "position", math.Round(c.position), "target", target, "duration", duration)
+	m.publish(c)
+}
+
+// finish ends the movement started at startedAt once its travel time has passed
+func (m *Manager) finish(key string, c *relayCover, startedAt time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.covers[key] != c || c.direction == directionStopped || !c.startedAt.Equal(startedAt) {
+		// Stopped or superseded in the meantime
+		return
+	}
+	if err := m.switchOff(c); err != nil {
+		m.logger.Error("Failed to stop cover", "cover", key, "error", err)
+	}
+	c.direction = directionStopped
+	c.position = c.target
+	c.timer = nil
+	m.save()
+
+	m.logger.Info("Cover reached position", "cover", key, "position", c.position)
+	m.publish(c)
+}
+
+// halt switches off the relay of a moving cover, drops a waiting movement and publishes where
+// the cover stopped
+func (m *Manager) halt(key string, c *relayCover) error {
+	c.next = nil
+	err := m.switchOff(c)
+	m.settle(key, c, m.now())
+	m.publish(c)
+	if err != nil {
+		return fmt.Errorf("failed to switch relay off: %w", err)
+	}
+	return nil
+}
+
+// settle records the estimated position of a cover as its resting position
+func (m *Manager) settle(key string, c *relayCover, now time.Time) {
+	m.stopTimer(c)
+	c.position = c.estimate(now)
+	c.direction = directionStopped
+	m.save()
+}
+
+func (m *Manager) stopTimer(c *relayCover) {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+// switchOff switches off the relay of the current direction
+func (m *Manager) switchOff(c *relayCover) error {
+	if c.direction == directionStopped {
+		return nil
+	}
+	return m.switchRelay(c, c.relay(c.direction), false)
+}
+
+// switchRelay switches a relay and waits for the node to confirm it. The switch is remembered
+// so that its report is not taken for a change at the node.
+func (m *Manager) switchRelay(c *relayCover, childID int, on bool) error {
+	m.switchID++
+	c.relays[childID] = &relayState{on: on, status: relayPending, id: m.switchID}
+	c.switches = append(c.switches, relaySwitch{childID: childID, on: on, at: m.now()})
+	return c.driver.SwitchRelay(childID, on, m.switchID)
+}
+
+func (m *Manager) publish(c *relayCover) {
+	position := c.estimate(m.now())
+	state := "open"
+	switch {
+	case c.direction == directionUp:
+		state = "opening"
+	case c.direction == directionDown:
+		state = "closing"
+	case position <= 0:
+		state = "closed"
+	}
+	c.driver.PublishCover(state, int(math.Round(position)))
+}
+
+// save writes the resting positions of all covers
+func (m *Manager) save() {
+	for key, c := range m.covers {
+		if c.direction == directionStopped {
+			m.positions[key] = c.position
+		}
+	}
+
+	data, err := json.MarshalIndent(m.positions, "", "  ")
+	if err != nil {
+		m.logger.Error("Failed to marshal cover positions", "error", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		m.logger.Error("Failed to create data directory", "path", m.path, "error", err)
+		return
+	}
+
+	// Write to a temporary file and rename so that a crash never leaves truncated positions
+	tmpPath := m.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		m.logger.Error("Failed to write cover positions", "path", m.path, "error", err)
+		return
+	}
+	if err := os.Rename(tmpPath, m.path); err != nil {
+		m.logger.Error("Failed to replace cover positions", "path", m.path, "error", err)
+	}
+}
+
+// reported consumes the switch a relay report belongs to. Returns false if the manager did not
+// switch the relay that way within the report window.
+func (c *relayCover) reported(childID int, on bool, now time.Time) bool {
+	recent := c.switches[:0]
+	for _, s := range c.switches {
+		if now.Sub(s.at) <= reportWindow {
+			recent = append(recent, s)
+		}
+	}
+	c.switches = recent
+
+	for i, s := range c.switches {
+		if s.childID == childID && s.on == on {
+			c.switches = append(c.switches[:i], c.switches[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// lastSwitch returns the relay whose last switch has the id, or nil if there is none
+func (c *relayCover) lastSwitch(id uint64) (int, *relayState) {
+	for childID, state := range c.relays {
+		if state.id == id {
+			return childID, state
+		}
+	}
+	return 0, nil
+}
+
+// estimate returns the position of the cover at a point in time
+func (c *relayCover) estimate(now time.Time) float64 {
+	if c.direction == directionStopped {
+		return c.position
+	}
+	moved := float64(now.Sub(c.startedAt)) / float64(c.travelTime(c.direction)) * 100
+	position := c.position + float64(c.direction)*moved
+	return math.Max(0, math.Min(100, position))
+}
+
+func (c *relayCover) relay(direction int) int {
+	if direction == directionDown {
+		return c.cfg.DownChildID
+	}
+	return c.cfg.UpChildID
+}
+
+func (c *relayCover) travelTime(direction int) time.Duration {
+	if direction == directionDown {
+		return c.cfg.TravelTimeDown
+	}
+	return c.cfg.TravelTimeUp
+}
@@ -0,0 +1,393 @@
+package cover
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testKey   = "blind"
+	upRelay   = 1
+	downRelay = 2
+)
+
+type fakeTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	wasActive := !t.stopped
+	t.stopped = true
+	return wasActive
+}
+
+// fakeClock runs timers only when the test advances it
+type fakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) stopper {
+	timer := &fakeTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// Advance moves the clock forward and fires the timers that became due
+func (c *fakeClock) Advance(d time.Duration) {
+	end := c.now.Add(d)
+	for {
+		var next *fakeTimer
+		for _, timer := range c.timers {
+			if !timer.stopped && !timer.at.After(end) && (next == nil || timer.at.Before(next.at)) {
+				next = timer
+			}
+		}
+		if next == nil {
+			break
+		}
+		next.stopped = true
+		c.now = next.at
+		next.f()
+	}
+	c.now = end
+}
+
+type fakeDriver struct {
+	t        *testing.T
+	relays   map[int]bool
+	switches []string
+	lastID   uint64
+	state    string
+	position int
+}
+
+func (d *fakeDriver) SwitchRelay(childID int, on bool, id uint64) error {
+	d.relays[childID] = on
+	d.switches = append(d.switches, fmt.Sprintf("%d:%t", childID, on))
+	d.lastID = id
+	if d.relays[upRelay] && d.relays[downRelay] {
+		d.t.Errorf("both relays on after switches %v", d.switches)
+	}
+	return nil
+}
+
+func (d *fakeDriver) PublishCover(state string, position int) {
+	d.state = state
+	d.position = position
+}
+
+func newTestManager(t *testing.T, position float64) (*Manager, *fakeClock, *fakeDriver) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	m := NewManager(t.TempDir(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	m.now = clock.Now
+	m.afterFunc = clock.AfterFunc
+	m.positions[testKey] = position
+
+	driver := &fakeDriver{t: t, relays: make(map[int]bool)}
+	m.Add(testKey, Config{
+		UpChildID:      upRelay,
+		DownChildID:    downRelay,
+		TravelTimeUp:   20 * time.Second,
+		TravelTimeDown: 10 * time.Second,
+		DeadTime:       time.Second,
+	}, driver)
+	return m, clock, driver
+}
+
+func checkCover(t *testing.T, driver *fakeDriver, state string, position int) {
+	t.Helper()
+	if driver.state != state || driver.position != position {
+		t.Errorf("cover = %s %d, want %s %d", driver.state, driver.position, state, position)
+	}
+}
+
+func checkSwitches(t *testing.T, driver *fakeDriver, want ...string) {
+	t.Helper()
+	if fmt.Sprint(driver.switches) != fmt.Sprint(want) {
+		t.Errorf("switches = %v, want %v", driver.switches, want)
+	}
+}
+
+func TestManagerSetPosition(t *testing.T) {
+	tests := []struct {
+		name         string
+		start        float64
+		target       float64
+		duration     time.Duration
+		movingState  string
+		finalState   string
+		finalPos     int
+		wantSwitches []string
+	}{
+		{"open from closed", 0, 100, 20 * time.Second, "opening", "open", 100, []string{"1:true", "1:false"}},
+		{"close part way", 100, 40, 6 * time.Second, "closing", "open", 40, []string{"2:true", "2:false"}},
+		{"close from half", 50, 0, 5 * time.Second, "closing", "closed", 0, []string{"2:true", "2:false"}},
+		{"close when closed runs full travel", 0, 0, 10 * time.Second, "closing", "closed", 0, []string{"2:true", "2:false"}},
+		{"open when open runs full travel", 100, 100, 20 * time.Second, "opening", "open", 100, []string{"1:true", "1:false"}},
+		{"target is clamped", 20, 150, 16 * time.Second, "opening", "open", 100, []string{"1:true", "1:false"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, clock, driver := newTestManager(t, tt.start)
+			if err := m.SetPosition(testKey, tt.target); err != nil {
+				t.Fatalf("SetPosition() error = %v", err)
+			}
+			clock.Advance(tt.duration - time.Millisecond)
+			if driver.state != tt.movingState {
+				t.Errorf("state before travel time = %s, want %s", driver.state, tt.movingState)
+			}
+			clock.Advance(time.Millisecond)
+			checkCover(t, driver, tt.finalState, tt.finalPos)
+			checkSwitches(t, driver, tt.wantSwitches...)
+		})
+	}
+}
+
+func TestManagerSetPositionInPlace(t *testing.T) {
+	m, _, driver := newTestManager(t, 50)
+	if err := m.SetPosition(testKey, 50); err != nil {
+		t.Fatalf("SetPosition() error = %v", err)
+	}
+	checkCover(t, driver, "open", 50)
+	checkSwitches(t, driver)
+}
+
+func TestManagerUnknownCover(t *testing.T) {
+	m, _, _ := newTestManager(t, 0)
+	if err := m.SetPosition("missing", 50); err == nil {
+		t.Error("SetPosition() of an unknown cover succeeded")
+	}
+	if err := m.StopCover("missing"); err == nil {
+		t.Error("StopCover() of an unknown cover succeeded")
+	}
+}
+
+func TestRelayCoverEstimate(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		position  float64
+		direction int
+		elapsed   time.Duration
+		want      float64
+	}{
+		{"stopped", 30, directionStopped, time.Minute, 30},
+		{"opening", 0, directionUp, 5 * time.Second, 25},
+		{"closing", 100, directionDown, 5 * time.Second, 50},
+		{"opening past the end", 90, directionUp, 10 * time.Second, 100},
+		{"closing past the end", 10, directionDown, 10 * time.Second, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &relayCover{
+				cfg:       Config{TravelTimeUp: 20 * time.Second, TravelTimeDown: 10 * time.Second},
+				position:  tt.position,
+				direction: tt.direction,
+				startedAt: start,
+			}
+			if got := c.estimate(start.Add(tt.elapsed)); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("estimate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManagerStopCoverSavesPosition(t *testing.T) {
+	m, clock, driver := newTestManager(t, 0)
+	if err := m.Open(testKey); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	clock.Advance(5 * time.Second)
+	if err := m.StopCover(testKey); err != nil {
+		t.Fatalf("StopCover() error = %v", err)
+	}
+	checkCover(t, driver, "open", 25)
+	checkSwitches(t, driver, "1:true", "1:false")
+
+	// The finish timer of the stopped movement must not fire
+	clock.Advance(time.Minute)
+	checkCover(t, driver, "open", 25)
+
+	restored := NewManager(filepath.Dir(m.path), m.logger)
+	if err := restored.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := restored.positions[testKey]; got != 25 {
+		t.Errorf("restored position = %v, want 25", got)
+	}
+}
+
+func TestManagerReversal(t *testing.T) {
+	m, clock, driver := newTestManager(t, 0)
+	if err := m.Open(testKey); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	clock.Advance(5 * time.Second)
+	if err := m.Close(testKey); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	// The cover stops until the node confirms the up relay is off
+	checkCover(t, driver, "open", 25)
+	checkSwitches(t, driver, "1:true", "1:false")
+	clock.Advance(time.Minute)
+	checkSwitches(t, driver, "1:true", "1:false")
+
+	// The down relay goes on after the dead time
+	m.RelayConfirmed(testKey, driver.lastID)
+	clock.Advance(time.Second - time.Millisecond)
+	checkSwitches(t, driver, "1:true", "1:false")
+	clock.Advance(time.Millisecond)
+	checkCover(t, driver, "closing", 25)
+	checkSwitches(t, driver, "1:true", "1:false", "2:true")
+
+	clock.Advance(2500 * time.Millisecond)
+	checkCover(t, driver, "closed", 0)
+	checkSwitches(t, driver, "1:true", "1:false", "2:true", "2:false")
+}
+
+func TestManagerReversalOffNotConfirmed(t *testing.T) {
+	m, clock, driver := newTestManager(t, 0)
+	if err := m.Open(testKey); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	clock.Advance(5 * time.Second)
+	if err := m.Close(testKey); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	off := driver.lastID
+
+	// The node never confirms the off, the down relay must stay off
+	m.RelayFailed(testKey, off)
+	clock.Advance(time.Minute)
+	checkCover(t, driver, "open", 25)
+	checkSwitches(t, driver, "1:true", "1:false")
+
+	// A late confirmation of the failed switch changes nothing
+	m.RelayConfirmed(testKey, off)
+	clock.Advance(time.Minute)
+	checkSwitches(t, driver, "1:true", "1:false")
+
+	// The next command switches the relay in the unknown state off again first
+	if err := m.Close(testKey); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	checkSwitches(t, driver, "1:true", "1:false", "1:false")
+	m.RelayConfirmed(testKey, driver.lastID)
+	clock.Advance(time.Second)
+	checkCover(t, driver, "closing", 25)
+	checkSwitches(t, driver, "1:true", "1:false", "1:false", "2:true")
+}
+
+func TestManagerRelayOnNotConfirmed(t *testing.T) {
+	m, clock, driver := newTestManager(t, 0)
+	if err := m.Open(testKey); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	on := driver.lastID
+	clock.Advance(4 * time.Second)
+
+	m.RelayFailed(testKey, on)
+	checkCover(t, driver, "open", 20)
+	checkSwitches(t, driver, "1:true", "1:false")
+
+	// Results of replaced switches are ignored
+	m.RelayFailed(testKey, on)
+	checkSwitches(t, driver, "1:true", "1:false")
+}
+
+func TestManagerStopOnShutdown(t *testing.T) {
+	m, clock, driver := newTestManager(t, 100)
+	if err := m.Close(testKey); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	clock.Advance(2 * time.Second)
+	m.Stop()
+	checkCover(t, driver, "open", 80)
+	checkSwitches(t, driver, "2:true", "2:false")
+}
+
+func TestManagerRelayChanged(t *testing.T) {
+	t.Run("started at the node", func(t *testing.T) {
+		m, clock, driver := newTestManager(t, 100)
+		m.RelayChanged(testKey, downRelay, true)
+		checkCover(t, driver, "closing", 100)
+		checkSwitches(t, driver)
+
+		clock.Advance(10 * time.Second)
+		checkCover(t, driver, "closed", 0)
+		checkSwitches(t, driver, "2:false")
+	})
+
+	t.Run("stopped at the node", func(t *testing.T) {
+		m, clock, driver := newTestManager(t, 0)
+		if err := m.Open(testKey); err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		m.RelayChanged(testKey, upRelay, true) // Report of our own switch
+		clock.Advance(4 * time.Second)
+		m.RelayChanged(testKey, upRelay, false)
+		checkCover(t, driver, "open", 20)
+		checkSwitches(t, driver, "1:true")
+	})
+
+	t.Run("reversed at the node", func(t *testing.T) {
+		m, clock, driver := newTestManager(t, 0)
+		if err := m.Open(testKey); err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		clock.Advance(10 * time.Second)
+		m.RelayChanged(testKey, downRelay, true)
+		checkCover(t, driver, "closing", 50)
+		checkSwitches(t, driver, "1:true", "1:false")
+	})
+
+	t.Run("late report after stop and open", func(t *testing.T) {
+		m, clock, driver := newTestManager(t, 0)
+		if err := m.Open(testKey); err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		clock.Advance(2 * time.Second)
+		if err := m.StopCover(testKey); err != nil {
+			t.Fatalf("StopCover() error = %v", err)
+		}
+		if err := m.Open(testKey); err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		// The report of the stop arrives after the relay was switched on again
+		m.RelayChanged(testKey, upRelay, true)
+		m.RelayChanged(testKey, upRelay, false)
+		clock.Advance(time.Second)
+		checkCover(t, driver, "opening", 10)
+
+		// Another off is a real stop at the node
+		m.RelayChanged(testKey, upRelay, false)
+		checkCover(t, driver, "open", 15)
+	})
+
+	t.Run("reports outside the window are changes", func(t *testing.T) {
+		m, clock, driver := newTestManager(t, 0)
+		if err := m.Open(testKey); err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		if err := m.StopCover(testKey); err != nil {
+			t.Fatalf("StopCover() error = %v", err)
+		}
+		clock.Advance(reportWindow + time.Second)
+		m.RelayChanged(testKey, upRelay, true)
+		checkCover(t, driver, "opening", 0)
+	})
+}
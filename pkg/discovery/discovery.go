@@ -0,0 +1,432 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"ms-mqtt-adapter/internal/mysensors"
+	"ms-mqtt-adapter/pkg/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Node holds everything a node has announced about itself
+type Node struct {
+	Gateway        string         `json:"gateway"`
+	NodeID         int            `json:"node_id"`
+	SketchName     string         `json:"sketch_name,omitempty"`
+	SketchVersion  string         `json:"sketch_version,omitempty"`
+	LibraryVersion string         `json:"library_version,omitempty"`
+	Children       map[int]*Child `json:"children"`
+}
+
+// Child holds a presented child sensor
+type Child struct {
+	ChildID       int                      `json:"child_id"`
+	SensorType    mysensors.SensorType     `json:"sensor_type"`
+	Description   string                   `json:"description,omitempty"`
+	Unpresented   bool                     `json:"unpresented,omitempty"`    // Only seen through SET traffic
+	VariableTypes []mysensors.VariableType `json:"variable_types,omitempty"` // Variable types seen in SET traffic
+}
+
+// Discoverer collects presentation and sketch information and builds device definitions from it
+type Discoverer struct {
+	cfg    *config.AutoDiscoveryConfig
+	path   string
+	logger *slog.Logger
+	nodes  map[string]*Node // key: "gateway:nodeID"
+	mu     sync.RWMutex
+}
+
+// NewDiscoverer creates a discoverer. If dataDir is empty, discovered nodes are not persisted.
+func NewDiscoverer(cfg *config.AutoDiscoveryConfig, dataDir string, logger *slog.Logger) *Discoverer {
+	d := &Discoverer{
+		cfg:    cfg,
+		logger: logger,
+		nodes:  make(map[string]*Node),
+	}
+	if dataDir != "" {
+		d.path = filepath.Join(dataDir, "discovered-nodes.json")
+	}
+	return d
+}
+
+func nodeKey(gatewayName string, nodeID int) string {
+	return fmt.Sprintf("%s:%d", gatewayName, nodeID)
+}
+
+// Load reads previously discovered nodes from disk. A missing file is not an error.
+func (d *Discoverer) Load() error {
+	if d.path == "" {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read discovered nodes: %w", err)
+	}
+
+	var nodes []*Node
+	if err := json.Unmarshal(data, &nodes); err != nil {
+		return fmt.Errorf("failed to parse discovered nodes %s: %w", d.path, err)
+	}
+
+	for _, node := range nodes {
+		if node.Children == nil {
+			node.Children = make(map[int]*Child)
+		}
+		d.nodes[nodeKey(node.Gateway, node.NodeID)] = node
+	}
+
+	d.logger.Info("Loaded discovered nodes", "path", d.path, "nodes", len(d.nodes))
+	return nil
+}
+
+// HandleMessage records presentation and sketch information. Returns true if the node changed.
+func (d *Discoverer) HandleMessage(gatewayName string, message *mysensors.Message) bool {
+	if message.NodeID == 0 || message.NodeID == 255 {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	changed := false
+	switch {
+	case message.IsPresentation():
+		sensorType := message.GetSensorType()
+		if message.ChildID == 255 || sensorType == mysensors.S_ARDUINO_NODE || sensorType == mysensors.S_ARDUINO_REPEATER_NODE {
+			node := d.getOrCreateNode(gatewayName, message.NodeID)
+			if node.LibraryVersion != message.Payload {
+				node.LibraryVersion = message.Payload
+				changed = true
+			}
+			break
+		}
+
+		node := d.getOrCreateNode(gatewayName, message.NodeID)
+		child, exists := node.Children[message.ChildID]
+		if !exists || child.SensorType != sensorType || child.Description != message.Payload {
+			newChild := &Child{
+				ChildID:     message.ChildID,
+				SensorType:  sensorType,
+				Description: message.Payload,
+			}
+			if exists {
+				newChild.VariableTypes = child.VariableTypes
+			}
+			node.Children[message.ChildID] = newChild
+			changed = true
+			d.logger.Info("Discovered child sensor", "gateway", gatewayName, "node_id", message.NodeID,
+				"child_id", message.ChildID, "sensor_type", sensorType.String(), "description", message.Payload)
+		}
+
+	case message.IsInternal() && message.GetInternalType() == mysensors.I_SKETCH_NAME:
+		node := d.getOrCreateNode(gatewayName, message.NodeID)
+		if node.SketchName != message.Payload {
+			node.SketchName = message.Payload
+			changed = true
+		}
+
+	case message.IsInternal() && message.GetInternalType() == mysensors.I_SKETCH_VERSION:
+		node := d.getOrCreateNode(gatewayName, message.NodeID)
+		if node.SketchVersion != message.Payload {
+			node.SketchVersion = message.Payload
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := d.save(); err != nil {
+			d.logger.Error("Failed to save discovered nodes", "path", d.path, "error", err)
+		}
+	}
+	return changed
+}
+
+// RecordSet records the variable type of a SET message so that children which were never
+// presented can still be classified. Returns true if the child changed.
+func (d *Discoverer) RecordSet(gatewayName string, message *mysensors.Message) bool {
+	if !message.IsSet() || message.NodeID == 0 || message.NodeID == 255 || message.ChildID == 255 {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	node := d.getOrCreateNode(gatewayName, message.NodeID)
+	child, exists := node.Children[message.ChildID]
+	if !exists {
+		child = &Child{ChildID: message.ChildID, Unpresented: true}
+		node.Children[message.ChildID] = child
+	}
+
+	varType := message.GetVariableType()
+	for _, seen := range child.VariableTypes {
+		if seen == varType {
+			return !exists
+		}
+	}
+	child.VariableTypes = append(child.VariableTypes, varType)
+	return true
+}
+
+// clone returns a deep copy of the node so it can be used without holding the lock
+func (n *Node) clone() Node {
+	nodeCopy := *n
+	nodeCopy.Children = make(map[int]*Child, len(n.Children))
+	for childID, child := range n.Children {
+		childCopy := *child
+		childCopy.VariableTypes = append([]mysensors.VariableType(nil), child.VariableTypes...)
+		nodeCopy.Children[childID] = &childCopy
+	}
+	return nodeCopy
+}
+
+// getOrCreateNode must be called with d.mu held
+func (d *Discoverer) getOrCreateNode(gatewayName string, nodeID int) *Node {
+	key := nodeKey(gatewayName, nodeID)
+	node, exists := d.nodes[key]
+	if !exists {
+		node = &Node{Gateway: gatewayName, NodeID: nodeID, Children: make(map[int]*Child)}
+		d.nodes[key] = node
+	}
+	return node
+}
+
+// Nodes returns all discovered nodes sorted by gateway and node ID
+func (d *Discoverer) Nodes() []Node {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	nodes := make([]Node, 0, len(d.nodes))
+	for _, node := range d.nodes {
+		nodes = append(nodes, node.clone())
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Gateway != nodes[j].Gateway {
+			return nodes[i].Gateway < nodes[j].Gateway
+		}
+		return nodes[i].NodeID < nodes[j].NodeID
+	})
+	return nodes
+}
+
+// Device builds a device definition for a discovered node, applying configured overrides.
+// Returns false if the node is unknown, ignored, or has no classifiable children.
+func (d *Discoverer) Device(gatewayName string, nodeID int) (config.Device, bool) {
+	d.mu.RLock()
+	node, exists := d.nodes[nodeKey(gatewayName, nodeID)]
+	if !exists {
+		d.mu.RUnlock()
+		return config.Device{}, false
+	}
+	nodeCopy := node.clone()
+	d.mu.RUnlock()
+
+	device, _ := d.buildDevice(nodeCopy)
+	return device, len(device.Entities) > 0
+}
+
+// Devices builds device definitions for all discovered nodes
+func (d *Discoverer) Devices() []config.Device {
+	var devices []config.Device
+	for _, node := range d.Nodes() {
+		if device, _ := d.buildDevice(node); len(device.Entities) > 0 {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// Draft builds a device definition for a node even if none of its children could be
+// classified, and returns the children that were left out
+func (d *Discoverer) Draft(node Node) (config.Device, []*Child) {
+	return d.buildDevice(node)
+}
+
+func (d *Discoverer) buildDevice(node Node) (config.Device, []*Child) {
+	var unclassified []*Child
+
+	override := d.findOverride(node.Gateway, node.NodeID)
+	if override != nil && override.Ignore {
+		return config.Device{}, nil
+	}
+
+	device := config.Device{
+		Name:         fmt.Sprintf("MySensors Node %d", node.NodeID),
+		ID:           fmt.Sprintf("mysensors_%s_%d", node.Gateway, node.NodeID),
+		NodeID:       node.NodeID,
+		Gateway:      node.Gateway,
+		Manufacturer: "MySensors",
+		Model:        node.SketchName,
+		SWVersion:    node.SketchVersion,
+		HWVersion:    node.LibraryVersion,
+	}
+	if node.SketchName != "" {
+		device.Name = fmt.Sprintf("%s (node %d)", node.SketchName, node.NodeID)
+	}
+	if override != nil {
+		if override.Name != "" {
+			device.Name = override.Name
+		}
+		if override.ID != "" {
+			device.ID = override.ID
+		}
+		device.SuggestedArea = override.SuggestedArea
+	}
+
+	childIDs := make([]int, 0, len(node.Children))
+	for childID := range node.Children {
+		childIDs = append(childIDs, childID)
+	}
+	sort.Ints(childIDs)
+
+	for _, childID := range childIDs {
+		child := node.Children[childID]
+		childOverride := findChildOverride(override, childID)
+		entity, ok := d.buildEntity(child, childOverride)
+		if !ok {
+			if childOverride == nil || !childOverride.Ignore {
+				unclassified = append(unclassified, child)
+			}
+			continue
+		}
+		device.Entities = append(device.Entities, entity)
+	}
+
+	return device, unclassified
+}
+
+func (d *Discoverer) buildEntity(child *Child, override *config.ChildOverride) (config.Entity, bool) {
+	if override != nil && override.Ignore {
+		return config.Entity{}, false
+	}
+
+	mapping, exists := Classify(child)
+	if override != nil && override.EntityType != "" {
+		mapping = config.SensorTypeMapping{EntityType: override.EntityType}
+		exists = true
+	}
+	if !exists {
+		d.logger.Debug("Skipping unclassified child sensor", "child_id", child.ChildID, "sensor_type", child.SensorType.String())
+		return config.Entity{}, false
+	}
+
+	entity := config.Entity{
+		Name:         child.Description,
+		ID:           fmt.Sprintf("child_%d", child.ChildID),
+		ChildID:      child.ChildID,
+		EntityType:   mapping.EntityType,
+		VariableType: mapping.VariableType,
+		DeviceClass:  mapping.DeviceClass,
+	}
+	if entity.Name == "" {
+		entity.Name = fmt.Sprintf("%s %d", mapping.EntityType, child.ChildID)
+	}
+	if mapping.ReadOnly {
+		readOnly := true
+		entity.ReadOnly = &readOnly
+	}
+	if override != nil {
+		if override.Name != "" {
+			entity.Name = override.Name
+		}
+		if override.ID != "" {
+			entity.ID = override.ID
+		}
+		if override.Icon != "" {
+			entity.Icon = override.Icon
+		}
+		if override.DeviceClass != "" {
+			entity.DeviceClass = override.DeviceClass
+		}
+	}
+
+	config.ApplyEntityDefaults(&entity)
+	return entity, true
+}
+
+// Classify returns the entity mapping for a child, based on its presentation or,
+// for children that were never presented, on the first variable type seen
+func Classify(child *Child) (config.SensorTypeMapping, bool) {
+	if !child.Unpresented {
+		return config.GetEntityMappingForSensorType(child.SensorType)
+	}
+	for _, varType := range child.VariableTypes {
+		if mapping, exists := config.GetEntityMappingForVariableType(varType); exists {
+			return mapping, true
+		}
+	}
+	return config.SensorTypeMapping{}, false
+}
+
+func (d *Discoverer) findOverride(gatewayName string, nodeID int) *config.NodeOverride {
+	if d.cfg == nil {
+		return nil
+	}
+	for i := range d.cfg.Overrides {
+		override := &d.cfg.Overrides[i]
+		overrideGateway := override.Gateway
+		if overrideGateway == "" {
+			overrideGateway = "default"
+		}
+		if override.NodeID == nodeID && overrideGateway == gatewayName {
+			return override
+		}
+	}
+	return nil
+}
+
+func findChildOverride(override *config.NodeOverride, childID int) *config.ChildOverride {
+	if override == nil {
+		return nil
+	}
+	for i := range override.Children {
+		if override.Children[i].ChildID == childID {
+			return &override.Children[i]
+		}
+	}
+	return nil
+}
+
+// save must be called with d.mu held
+func (d *Discoverer) save() error {
+	if d.path == "" {
+		return nil
+	}
+
+	nodes := make([]*Node, 0, len(d.nodes))
+	for _, node := range d.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Gateway != nodes[j].Gateway {
+			return nodes[i].Gateway < nodes[j].Gateway
+		}
+		return nodes[i].NodeID < nodes[j].NodeID
+	})
+
+	data, err := json.MarshalIndent(nodes, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal discovered nodes: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	tmpPath := d.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write discovered nodes: %w", err)
+	}
+	return os.Rename(tmpPath, d.path)
+}
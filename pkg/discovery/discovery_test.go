@@ -0,0 +1,153 @@
+package discovery
+
+import (
+	"io"
+	"log/slog"
+	"ms-mqtt-adapter/internal/mysensors"
+	"ms-mqtt-adapter/pkg/config"
+	"reflect"
+	"testing"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func presentation(nodeID, childID int, sensorType mysensors.SensorType, description string) *mysensors.Message {
+	return &mysensors.Message{
+		NodeID:      nodeID,
+		ChildID:     childID,
+		MessageType: mysensors.PRESENTATION,
+		SubType:     int(sensorType),
+		Payload:     description,
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name        string
+		child       Child
+		entityType  string
+		deviceClass string
+		ok          bool
+	}{
+		{"temperature", Child{SensorType: mysensors.S_TEMP}, "temperature", "temperature", true},
+		{"door", Child{SensorType: mysensors.S_DOOR}, "binary_sensor", "door", true},
+		{"binary", Child{SensorType: mysensors.S_BINARY}, "switch", "", true},
+		{"dimmer", Child{SensorType: mysensors.S_DIMMER}, "dimmer", "", true},
+		{"node", Child{SensorType: mysensors.S_ARDUINO_NODE}, "", "", false},
+		{"unpresented status", Child{Unpresented: true, VariableTypes: []mysensors.VariableType{mysensors.V_STATUS}}, "switch", "", true},
+		{"unpresented first known variable", Child{Unpresented: true, VariableTypes: []mysensors.VariableType{mysensors.V_VAR1, mysensors.V_HUM}}, "humidity", "humidity", true},
+		{"unpresented without variables", Child{Unpresented: true}, "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping, ok := Classify(&tt.child)
+			if ok != tt.ok || mapping.EntityType != tt.entityType || mapping.DeviceClass != tt.deviceClass {
+				t.Errorf("Classify() = %q %q %t, want %q %q %t", mapping.EntityType, mapping.DeviceClass, ok, tt.entityType, tt.deviceClass, tt.ok)
+			}
+		})
+	}
+}
+
+func TestOverrideDefaultGateway(t *testing.T) {
+	cfg := &config.AutoDiscoveryConfig{Overrides: []config.NodeOverride{
+		{NodeID: 5, Name: "Hallway", Children: []config.ChildOverride{
+			{ChildID: 1, Name: "Front door", DeviceClass: "garage_door"},
+			{ChildID: 2, Ignore: true},
+		}},
+		{NodeID: 6, Gateway: "upstairs", Ignore: true},
+	}}
+
+	tests := []struct {
+		name     string
+		gateway  string
+		nodeID   int
+		device   string
+		entities []string
+		ok       bool
+	}{
+		{"override without gateway applies to default", "default", 5, "Hallway", []string{"Front door"}, true},
+		{"override without gateway skips other gateways", "upstairs", 5, "MySensors Node 5", []string{"Door", "Temp"}, true},
+		{"ignored node", "upstairs", 6, "", nil, false},
+		{"ignore on another gateway", "default", 6, "MySensors Node 6", []string{"Door", "Temp"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDiscoverer(cfg, "", testLogger())
+			d.HandleMessage(tt.gateway, presentation(tt.nodeID, 1, mysensors.S_DOOR, "Door"))
+			d.HandleMessage(tt.gateway, presentation(tt.nodeID, 2, mysensors.S_TEMP, "Temp"))
+
+			device, ok := d.Device(tt.gateway, tt.nodeID)
+			if ok != tt.ok {
+				t.Fatalf("Device() ok = %t, want %t", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			var entities []string
+			for _, entity := range device.Entities {
+				entities = append(entities, entity.Name)
+			}
+			if device.Name != tt.device || !reflect.DeepEqual(entities, tt.entities) {
+				t.Errorf("device = %q with %v, want %q with %v", device.Name, entities, tt.device, tt.entities)
+			}
+		})
+	}
+}
+
+func TestChildOverride(t *testing.T) {
+	cfg := &config.AutoDiscoveryConfig{Overrides: []config.NodeOverride{
+		{NodeID: 5, Children: []config.ChildOverride{{ChildID: 1, ID: "front_door", DeviceClass: "garage_door"}}},
+	}}
+	d := NewDiscoverer(cfg, "", testLogger())
+	d.HandleMessage("default", presentation(5, 1, mysensors.S_DOOR, "Door"))
+
+	device, ok := d.Device("default", 5)
+	if !ok || len(device.Entities) != 1 {
+		t.Fatalf("Device() = %+v, %t, want one entity", device, ok)
+	}
+	entity := device.Entities[0]
+	if entity.ID != "front_door" || entity.DeviceClass != "garage_door" || entity.EntityType != "binary_sensor" || !entity.IsReadOnly() {
+		t.Errorf("entity = %+v, want the overridden read-only binary_sensor front_door", entity)
+	}
+}
+
+func TestDiscoveredNodesRoundTrip(t *testing.T) {
+	dataDir := t.TempDir()
+	d := NewDiscoverer(nil, dataDir, testLogger())
+
+	d.HandleMessage("default", presentation(5, 255, mysensors.S_ARDUINO_NODE, "2.3.2"))
+	d.HandleMessage("default", presentation(5, 1, mysensors.S_DOOR, "Door"))
+	d.HandleMessage("default", mysensors.NewInternalMessage(5, mysensors.I_SKETCH_NAME, "Hallway"))
+	d.HandleMessage("default", mysensors.NewInternalMessage(5, mysensors.I_SKETCH_VERSION, "1.0"))
+	d.HandleMessage("upstairs", presentation(9, 3, mysensors.S_TEMP, "Temp"))
+
+	// Nothing changes for a repeated presentation
+	if d.HandleMessage("default", presentation(5, 1, mysensors.S_DOOR, "Door")) {
+		t.Error("repeated presentation reported a change")
+	}
+
+	loaded := NewDiscoverer(nil, dataDir, testLogger())
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got, want := loaded.Nodes(), d.Nodes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded nodes = %+v, want %+v", got, want)
+	}
+
+	node := loaded.Nodes()[0]
+	if node.Gateway != "default" || node.SketchName != "Hallway" || node.SketchVersion != "1.0" || node.LibraryVersion != "2.3.2" {
+		t.Errorf("node = %+v, want the sketch and library of node 5", node)
+	}
+	if child := node.Children[1]; child == nil || child.SensorType != mysensors.S_DOOR || child.Description != "Door" {
+		t.Errorf("child 1 = %+v, want the door", child)
+	}
+
+	// A missing file is not an error
+	if err := NewDiscoverer(nil, t.TempDir(), testLogger()).Load(); err != nil {
+		t.Errorf("Load() without a file error = %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package firmware
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// BlockSize is the number of firmware bytes sent in one ST_FIRMWARE_RESPONSE
+const BlockSize = 16
+
+// pageSize is the flash page size the image is padded to
+const pageSize = 128
+
+// maxImageSize guards against HEX files placed at high addresses, MySensors images fit in 256KB
+const maxImageSize = 256 * 1024
+
+// Intel HEX record types
+const (
+	recordData                   = 0x00
+	recordEndOfFile              = 0x01
+	recordExtendedSegmentAddress = 0x02
+	recordStartSegmentAddress    = 0x03
+	recordExtendedLinearAddress  = 0x04
+	recordStartLinearAddress     = 0x05
+)
+
+// Firmware is a firmware image prepared for serving to nodes in blocks
+type Firmware struct {
+	Type    uint16
+	Version uint16
+	Blocks  uint16
+	CRC     uint16
+	File    string
+	data    []byte
+}
+
+// LoadFirmware reads an Intel HEX file and prepares it for serving
+func LoadFirmware(path string, fwType, version uint16) (*Firmware, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open firmware file: %w", err)
+	}
+	defer file.Close()
+
+	data, err := parseIntelHex(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse firmware file %s: %w", path, err)
+	}
+
+	fw := NewFirmware(fwType, version, data)
+	fw.File = path
+	return fw, nil
+}
+
+// NewFirmware pads a binary image to whole flash pages and computes its block count and CRC
+func NewFirmware(fwType, version uint16, data []byte) *Firmware {
+	padded := make([]byte, len(data))
+	copy(padded, data)
+	for len(padded)%pageSize != 0 {
+		padded = append(padded, 0xFF)
+	}
+
+	return &Firmware{
+		Type:    fwType,
+		Version: version,
+		Blocks:  uint16(len(padded) / BlockSize),
+		CRC:     crc16(padded),
+		data:    padded,
+	}
+}
+
+// Block returns the bytes of a single block
+func (f *Firmware) Block(block uint16) ([]byte, error) {
+	if block >= f.Blocks {
+		return nil, fmt.Errorf("block %d out of range (%d blocks)", block, f.Blocks)
+	}
+	start := int(block) * BlockSize
+	return f.data[start : start+BlockSize], nil
+}
+
+// parseIntelHex decodes an Intel HEX file into a flat binary image starting at address 0.
+// Gaps between records are filled with 0xFF, the erased flash value.
+func parseIntelHex(r io.Reader) ([]byte, error) {
+	var image []byte
+	var baseAddress uint32
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, ":") {
+			return nil, fmt.Errorf("line %d: missing start code", lineNumber)
+		}
+
+		record, err := hex.DecodeString(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if len(record) < 5 || len(record) != int(record[0])+5 {
+			return nil, fmt.Errorf("line %d: invalid record length", lineNumber)
+		}
+
+		var checksum byte
+		for _, b := range record {
+			checksum += b
+		}
+		if checksum != 0 {
+			return nil, fmt.Errorf("line %d: checksum mismatch", lineNumber)
+		}
+
+		length := int(record[0])
+		offset := uint32(record[1])<<8 | uint32(record[2])
+		payload := record[4 : 4+length]
+
+		switch record[3] {
+		case recordData:
+			address := baseAddress + offset
+			end := int(address) + length
+			if end > maxImageSize {
+				return nil, fmt.Errorf("line %d: address 0x%X exceeds maximum image size", lineNumber, address)
+			}
+			for len(image) < end {
+				image = append(image, 0xFF)
+			}
+			copy(image[address:], payload)
+		case recordEndOfFile:
+			return image, nil
+		case recordExtendedSegmentAddress:
+			if length != 2 {
+				return nil, fmt.Errorf("line %d: invalid extended segment address", lineNumber)
+			}
+			baseAddress = (uint32(payload[0])<<8 | uint32(payload[1])) << 4
+		case recordExtendedLinearAddress:
+			if length != 2 {
+				return nil, fmt.Errorf("line %d: invalid extended linear address", lineNumber)
+			}
+			baseAddress = (uint32(payload[0])<<8 | uint32(payload[1])) << 16
+		case recordStartSegmentAddress, recordStartLinearAddress:
+			// Entry point, not part of the image
+		default:
+			return nil, fmt.Errorf("line %d: unsupported record type %d", lineNumber, record[3])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("missing end of file record")
+}
+
+// crc16 computes the CRC-16/MODBUS checksum used by the MySensors bootloader
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,113 @@
+package firmware
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCRC16(t *testing.T) {
+	tests := []struct {
+		data string
+		want uint16
+	}{
+		// Check value of CRC-16/MODBUS, the avr-libc _crc16_update used by the MySensors bootloader
+		{"123456789", 0x4B37},
+		{"", 0xFFFF},
+		{"\x00", 0x40BF},
+	}
+
+	for _, tt := range tests {
+		if got := crc16([]byte(tt.data)); got != tt.want {
+			t.Errorf("crc16(%q) = 0x%04X, want 0x%04X", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestLoadFirmware(t *testing.T) {
+	fw, err := LoadFirmware("testdata/sample.hex", 10, 2)
+	if err != nil {
+		t.Fatalf("LoadFirmware() error = %v", err)
+	}
+
+	// 0x140 bytes from address 0x100, padded to three flash pages
+	if fw.Type != 10 || fw.Version != 2 || fw.Blocks != 24 || fw.CRC != 0x0B36 {
+		t.Errorf("firmware = type %d version %d, %d blocks, CRC 0x%04X, want type 10 version 2, 24 blocks, CRC 0x0B36",
+			fw.Type, fw.Version, fw.Blocks, fw.CRC)
+	}
+
+	tests := []struct {
+		block uint16
+		want  []byte
+	}{
+		{0, bytes.Repeat([]byte{0xFF}, BlockSize)},
+		{16, []byte{0x21, 0x46, 0x01, 0x36, 0x01, 0x21, 0x47, 0x01, 0x36, 0x00, 0x7E, 0xFE, 0x09, 0xD2, 0x19, 0x01}},
+		{19, []byte{0x3F, 0x01, 0x56, 0x70, 0x2B, 0x5E, 0x71, 0x2B, 0x72, 0x2B, 0x73, 0x21, 0x46, 0x01, 0x34, 0x21}},
+		{23, bytes.Repeat([]byte{0xFF}, BlockSize)},
+	}
+	for _, tt := range tests {
+		got, err := fw.Block(tt.block)
+		if err != nil {
+			t.Errorf("Block(%d) error = %v", tt.block, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("Block(%d) = % X, want % X", tt.block, got, tt.want)
+		}
+	}
+	if _, err := fw.Block(fw.Blocks); err == nil {
+		t.Errorf("Block(%d) beyond the image succeeded", fw.Blocks)
+	}
+}
+
+func TestParseIntelHexAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		hex     string
+		address int
+	}{
+		{"extended segment address", ":020000021000EC\n:0400000001020304F2\n:00000001FF", 0x10000},
+		{"extended linear address", ":020000040001F9\n:0400000001020304F2\n:00000001FF", 0x10000},
+		{"start address ignored", ":0400000001020304F2\n:0400000500000000F7\n:00000001FF", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image, err := parseIntelHex(strings.NewReader(tt.hex))
+			if err != nil {
+				t.Fatalf("parseIntelHex() error = %v", err)
+			}
+			if len(image) != tt.address+4 || !bytes.Equal(image[tt.address:], []byte{1, 2, 3, 4}) {
+				t.Errorf("image of %d bytes ends in % X, want 01 02 03 04 at 0x%X", len(image), image[max(len(image)-4, 0):], tt.address)
+			}
+			for _, b := range image[:tt.address] {
+				if b != 0xFF {
+					t.Fatal("gap before the data is not filled with 0xFF")
+				}
+			}
+		})
+	}
+}
+
+func TestParseIntelHexErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+	}{
+		{"missing start code", "0400000001020304F2\n:00000001FF"},
+		{"invalid hex", ":04000000010203ZZF2\n:00000001FF"},
+		{"record length", ":0500000001020304F1\n:00000001FF"},
+		{"checksum mismatch", ":0400000001020304F3\n:00000001FF"},
+		{"unsupported record type", ":0400000601020304EC\n:00000001FF"},
+		{"beyond maximum image size", ":020000040004F6\n:0400000001020304F2\n:00000001FF"},
+		{"missing end of file", ":0400000001020304F2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseIntelHex(strings.NewReader(tt.hex)); err == nil {
+				t.Error("parseIntelHex() succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,461 @@
+package firmware
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"ms-mqtt-adapter/internal/mysensors"
+	"ms-mqtt-adapter/pkg/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Update states reported in NodeStatus
+const (
+	StatusUnknown   = "unknown"    // No assignment, or the node has not asked for its config yet
+	StatusUpToDate  = "up_to_date" // Node runs the assigned firmware
+	StatusPending   = "pending"    // Node was told about new firmware and should start fetching it
+	StatusUpdating  = "updating"   // Node is fetching blocks
+	StatusCompleted = "completed"  // All blocks were served, node is expected to reboot into the new firmware
+)
+
+// progressStep limits progress publications to every few percent
+const progressStep = 5
+
+// Sender delivers messages to a MySensors network
+type Sender interface {
+	Send(message *mysensors.Message) error
+}
+
+// Assignment selects the firmware a node should run
+type Assignment struct {
+	Gateway string `json:"gateway"`
+	NodeID  int    `json:"node_id"`
+	Type    uint16 `json:"type"`
+	Version uint16 `json:"version"`
+}
+
+// NodeStatus describes the firmware a node reported and the progress of its update
+type NodeStatus struct {
+	Gateway           string      `json:"gateway"`
+	NodeID            int         `json:"node_id"`
+	CurrentType       uint16      `json:"current_type"`
+	CurrentVersion    uint16      `json:"current_version"`
+	CurrentBlocks     uint16      `json:"current_blocks"`
+	CurrentCRC        uint16      `json:"current_crc"`
+	BootloaderVersion uint16      `json:"bootloader_version,omitempty"`
+	Assigned          *Assignment `json:"assigned,omitempty"`
+	Status            string      `json:"status"`
+	Progress          int         `json:"progress"` // Percent of blocks served
+	LastRequest       time.Time   `json:"last_request"`
+}
+
+// StatusHandler is called when a node's firmware status or update progress changes
+type StatusHandler func(status NodeStatus)
+
+// Server answers MySensors OTA firmware requests from Intel HEX images in a directory
+type Server struct {
+	cfg         *config.FirmwareConfig
+	path        string // Persisted runtime assignments
+	logger      *slog.Logger
+	images      map[string]*Firmware   // key: "type:version"
+	assignments map[string]Assignment  // key: "gateway:node"
+	runtime     map[string]Assignment  // Assignments made over MQTT, key: "gateway:node"
+	statuses    map[string]*NodeStatus // key: "gateway:node"
+	mu          sync.Mutex
+	onStatus    StatusHandler
+}
+
+// NewServer creates a firmware server. Assignments made at runtime are stored as
+// firmware-assignments.json in dataDir.
+func NewServer(cfg *config.FirmwareConfig, dataDir string, logger *slog.Logger) *Server {
+	return &Server{
+		cfg:         cfg,
+		path:        filepath.Join(dataDir, "firmware-assignments.json"),
+		logger:      logger,
+		images:      make(map[string]*Firmware),
+		assignments: make(map[string]Assignment),
+		runtime:     make(map[string]Assignment),
+		statuses:    make(map[string]*NodeStatus),
+	}
+}
+
+func imageKey(fwType, version uint16) string {
+	return fmt.Sprintf("%d:%d", fwType, version)
+}
+
+func nodeKey(gatewayName string, nodeID int) string {
+	return fmt.Sprintf("%s:%d", gatewayName, nodeID)
+}
+
+// SetStatusHandler registers a callback for status and progress updates
+func (s *Server) SetStatusHandler(handler StatusHandler) {
+	s.mu.Lock()
+	s.onStatus = handler
+	s.mu.Unlock()
+}
+
+// Load reads the firmware directory and applies configured and persisted assignments.
+// Persisted assignments made over MQTT take precedence over the configuration.
+func (s *Server) Load() error {
+	if err := s.LoadImages(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, assignment := range s.cfg.Assignments {
+		gatewayName := assignment.Gateway
+		if gatewayName == "" {
+			gatewayName = "default"
+		}
+		s.assignments[nodeKey(gatewayName, assignment.NodeID)] = Assignment{
+			Gateway: gatewayName,
+			NodeID:  assignment.NodeID,
+			Type:    uint16(assignment.Type),
+			Version: uint16(assignment.Version),
+		}
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read firmware assignments: %w", err)
+	}
+
+	var persisted []Assignment
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return fmt.Errorf("failed to parse firmware assignments %s: %w", s.path, err)
+	}
+	for _, assignment := range persisted {
+		key := nodeKey(assignment.Gateway, assignment.NodeID)
+		s.assignments[key] = assignment
+		s.runtime[key] = assignment
+	}
+
+	s.logger.Info("Loaded firmware assignments", "assignments", len(s.assignments))
+	return nil
+}
+
+// LoadImages (re)reads all *.hex files from the firmware directory. File names must end
+// in -<type>-<version>.hex, for example "temperature-sensor-10-3.hex".
+func (s *Server) LoadImages() error {
+	files, err := filepath.Glob(filepath.Join(s.cfg.Directory, "*.hex"))
+	if err != nil {
+		return fmt.Errorf("failed to list firmware directory: %w", err)
+	}
+
+	images := make(map[string]*Firmware)
+	for _, file := range files {
+		fwType, version, err := parseFileName(filepath.Base(file))
+		if err != nil {
+			s.logger.Warn("Skipping firmware file", "file", file, "error", err)
+			continue
+		}
+
+		fw, err := LoadFirmware(file, fwType, version)
+		if err != nil {
+			s.logger.Warn("Skipping firmware file", "file", file, "error", err)
+			continue
+		}
+
+		images[imageKey(fwType, version)] = fw
+		s.logger.Info("Loaded firmware", "file", file, "type", fwType, "version", version,
+			"blocks", fw.Blocks, "crc", fmt.Sprintf("%04X", fw.CRC))
+	}
+
+	s.mu.Lock()
+	s.images = images
+	s.mu.Unlock()
+	return nil
+}
+
+// parseFileName extracts firmware type and version from "<name>-<type>-<version>.hex"
+func parseFileName(name string) (uint16, uint16, error) {
+	parts := strings.Split(strings.TrimSuffix(name, filepath.Ext(name)), "-")
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("file name must end in -<type>-<version>.hex")
+	}
+
+	fwType, err := strconv.ParseUint(parts[len(parts)-2], 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid firmware type in file name: %w", err)
+	}
+	version, err := strconv.ParseUint(parts[len(parts)-1], 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid firmware version in file name: %w", err)
+	}
+	return uint16(fwType), uint16(version), nil
+}
+
+// Assign selects the firmware a node should run the next time it boots
+func (s *Server) Assign(gatewayName string, nodeID int, fwType, version uint16) error {
+	if nodeID < 1 || nodeID > 254 {
+		return fmt.Errorf("invalid node ID %d", nodeID)
+	}
+
+	s.mu.Lock()
+	if _, exists := s.images[imageKey(fwType, version)]; !exists {
+		s.mu.Unlock()
+		return fmt.Errorf("no firmware with type %d version %d in %s", fwType, version, s.cfg.Directory)
+	}
+
+	assignment := Assignment{Gateway: gatewayName, NodeID: nodeID, Type: fwType, Version: version}
+	key := nodeKey(gatewayName, nodeID)
+	s.assignments[key] = assignment
+	s.runtime[key] = assignment
+
+	status := s.status(gatewayName, nodeID)
+	status.Assigned = &assignment
+	status.Status = StatusPending
+	status.Progress = 0
+	snapshot := *status
+
+	err := s.save()
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("Assigned firmware", "gateway", gatewayName, "node_id", nodeID, "type", fwType, "version", version)
+	s.notify(snapshot)
+	return nil
+}
+
+// HandleMessage answers firmware STREAM requests, other messages are ignored
+func (s *Server) HandleMessage(gatewayName string, sender Sender, message *mysensors.Message) error {
+	if !message.IsStream() {
+		return nil
+	}
+
+	switch message.GetStreamType() {
+	case mysensors.ST_FIRMWARE_CONFIG_REQUEST:
+		return s.handleConfigRequest(gatewayName, sender, message)
+	case mysensors.ST_FIRMWARE_REQUEST:
+		return s.handleFirmwareRequest(gatewayName, sender, message)
+	default:
+		return nil
+	}
+}
+
+// handleConfigRequest is sent by the bootloader on every boot with the firmware the node currently runs.
+// The reply tells the node which firmware it should run, if it differs the node starts fetching blocks.
+func (s *Server) handleConfigRequest(gatewayName string, sender Sender, message *mysensors.Message) error {
+	values, err := decodeWords(message.Payload, 4)
+	if err != nil {
+		return fmt.Errorf("invalid firmware config request from node %d: %w", message.NodeID, err)
+	}
+
+	s.mu.Lock()
+	status := s.status(gatewayName, message.NodeID)
+	status.CurrentType = values[0]
+	status.CurrentVersion = values[1]
+	status.CurrentBlocks = values[2]
+	status.CurrentCRC = values[3]
+	if len(values) > 4 {
+		status.BootloaderVersion = values[4]
+	}
+	status.LastRequest = time.Now()
+
+	assignment, assigned := s.assignments[nodeKey(gatewayName, message.NodeID)]
+	var fw *Firmware
+	if assigned {
+		fw = s.images[imageKey(assignment.Type, assignment.Version)]
+		status.Assigned = &assignment
+	}
+
+	switch {
+	case !assigned:
+		status.Status = StatusUnknown
+	case fw == nil:
+		status.Status = StatusUnknown
+	case fw.Type == status.CurrentType && fw.Version == status.CurrentVersion &&
+		fw.Blocks == status.CurrentBlocks && fw.CRC == status.CurrentCRC:
+		status.Status = StatusUpToDate
+		status.Progress = 100
+	default:
+		status.Status = StatusPending
+		status.Progress = 0
+	}
+	snapshot := *status
+	s.mu.Unlock()
+
+	s.logger.Info("Node reported firmware", "gateway", gatewayName, "node_id", message.NodeID,
+		"type", snapshot.CurrentType, "version", snapshot.CurrentVersion, "status", snapshot.Status)
+	s.notify(snapshot)
+
+	if !assigned {
+		return nil
+	}
+	if fw == nil {
+		s.logger.Warn("Assigned firmware not found", "gateway", gatewayName, "node_id", message.NodeID,
+			"type", assignment.Type, "version", assignment.Version)
+		return nil
+	}
+
+	payload := encodeWords(fw.Type, fw.Version, fw.Blocks, fw.CRC)
+	response := mysensors.NewStreamMessage(message.NodeID, mysensors.ST_FIRMWARE_CONFIG_RESPONSE, payload)
+	if err := sender.Send(response); err != nil {
+		return fmt.Errorf("failed to send firmware config response: %w", err)
+	}
+	return nil
+}
+
+// handleFirmwareRequest serves one block. Nodes request blocks from the last one down to 0.
+func (s *Server) handleFirmwareRequest(gatewayName string, sender Sender, message *mysensors.Message) error {
+	values, err := decodeWords(message.Payload, 3)
+	if err != nil {
+		return fmt.Errorf("invalid firmware request from node %d: %w", message.NodeID, err)
+	}
+	fwType, version, block := values[0], values[1], values[2]
+
+	s.mu.Lock()
+	fw, exists := s.images[imageKey(fwType, version)]
+	if !exists {
+		s.mu.Unlock()
+		return fmt.Errorf("node %d requested unknown firmware type %d version %d", message.NodeID, fwType, version)
+	}
+
+	data, err := fw.Block(block)
+	if err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("node %d requested invalid firmware block: %w", message.NodeID, err)
+	}
+
+	status := s.status(gatewayName, message.NodeID)
+	status.LastRequest = time.Now()
+	progress := int(fw.Blocks-block) * 100 / int(fw.Blocks)
+	publish := status.Status != StatusUpdating || progress-status.Progress >= progressStep || block == 0
+	status.Status = StatusUpdating
+	if block == 0 {
+		status.Status = StatusCompleted
+	}
+	if publish {
+		status.Progress = progress
+	}
+	snapshot := *status
+	s.mu.Unlock()
+
+	payload := encodeWords(fwType, version, block) + strings.ToUpper(hex.EncodeToString(data))
+	response := mysensors.NewStreamMessage(message.NodeID, mysensors.ST_FIRMWARE_RESPONSE, payload)
+	if err := sender.Send(response); err != nil {
+		return fmt.Errorf("failed to send firmware block %d: %w", block, err)
+	}
+
+	if publish {
+		if block == 0 {
+			s.logger.Info("Firmware transfer completed", "gateway", gatewayName, "node_id", message.NodeID,
+				"type", fwType, "version", version)
+		}
+		s.notify(snapshot)
+	}
+	return nil
+}
+
+// Statuses returns a snapshot of all known nodes sorted by gateway and node ID
+func (s *Server) Statuses() []NodeStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]NodeStatus, 0, len(s.statuses))
+	for _, status := range s.statuses {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Gateway != statuses[j].Gateway {
+			return statuses[i].Gateway < statuses[j].Gateway
+		}
+		return statuses[i].NodeID < statuses[j].NodeID
+	})
+	return statuses
+}
+
+// status returns the status record of a node, creating it if needed. Must be called with s.mu held.
+func (s *Server) status(gatewayName string, nodeID int) *NodeStatus {
+	key := nodeKey(gatewayName, nodeID)
+	status, exists := s.statuses[key]
+	if !exists {
+		status = &NodeStatus{Gateway: gatewayName, NodeID: nodeID, Status: StatusUnknown}
+		s.statuses[key] = status
+	}
+	return status
+}
+
+// save writes runtime assignments to disk. Must be called with s.mu held.
+func (s *Server) save() error {
+	assignments := make([]Assignment, 0, len(s.runtime))
+	for _, assignment := range s.runtime {
+		assignments = append(assignments, assignment)
+	}
+	sort.Slice(assignments, func(i, j int) bool {
+		if assignments[i].Gateway != assignments[j].Gateway {
+			return assignments[i].Gateway < assignments[j].Gateway
+		}
+		return assignments[i].NodeID < assignments[j].NodeID
+	})
+
+	data, err := json.MarshalIndent(assignments, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal firmware assignments: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write firmware assignments: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace firmware assignments: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) notify(status NodeStatus) {
+	s.mu.Lock()
+	handler := s.onStatus
+	s.mu.Unlock()
+
+	if handler != nil {
+		handler(status)
+	}
+}
+
+// decodeWords decodes a hex payload of little-endian 16 bit values, requiring at least min values
+func decodeWords(payload string, min int) ([]uint16, error) {
+	data, err := hex.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < min*2 {
+		return nil, fmt.Errorf("payload too short: %d bytes", len(data))
+	}
+
+	values := make([]uint16, len(data)/2)
+	for i := range values {
+		values[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return values, nil
+}
+
+// encodeWords encodes 16 bit values as a little-endian hex payload
+func encodeWords(values ...uint16) string {
+	data := make([]byte, len(values)*2)
+	for i, value := range values {
+		binary.LittleEndian.PutUint16(data[i*2:], value)
+	}
+	return strings.ToUpper(hex.EncodeToString(data))
+}
@@ -7,6 +7,7 @@ import (
 	"ms-mqtt-adapter/internal/mysensors"
 	"ms-mqtt-adapter/pkg/config"
 	"ms-mqtt-adapter/pkg/transport"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -17,19 +18,38 @@ type Gateway struct {
 	logger        *slog.Logger
 	seenNodes     map[int]bool
 	seenNodesOrder []int // Track order of node discovery
+	lastSeen      map[int]time.Time
 	nodesMu       sync.RWMutex
 	nextNodeID    int
+	registry      *NodeRegistry // Persistent node registry (nil if disabled)
+	sleepQueue    *SleepQueue   // Queue for smart-sleep nodes (nil if disabled)
 }
 
-func NewGateway(gatewayConfig *config.GatewayConfig, transport transport.Transport, logger *slog.Logger) *Gateway {
-	return &Gateway{
+// NewGateway creates a gateway. The registry is optional and must already be loaded,
+// so that ID assignment never hands out IDs used before a restart.
+func NewGateway(gatewayConfig *config.GatewayConfig, registry *NodeRegistry, transport transport.Transport, logger *slog.Logger) *Gateway {
+	g := &Gateway{
 		gatewayConfig:  gatewayConfig,
 		transport:      transport,
 		logger:         logger,
 		seenNodes:      make(map[int]bool),
 		seenNodesOrder: make([]int, 0),
+		lastSeen:       make(map[int]time.Time),
 		nextNodeID:     gatewayConfig.NodeIDRange.Start,
+		registry:       registry,
 	}
+
+	if gatewayConfig.SmartSleep.Enabled == nil || *gatewayConfig.SmartSleep.Enabled {
+		g.sleepQueue = NewSleepQueue(transport, logger)
+	}
+
+	if registry != nil {
+		for _, nodeID := range gatewayConfig.NodeRegistry.ReservedIDs {
+			registry.Reserve(nodeID)
+		}
+	}
+
+	return g
 }
 
 func (g *Gateway) HandleMessage(message *mysensors.Message) error {
@@ -44,6 +64,31 @@ func (g *Gateway) HandleMessage(message *mysensors.Message) error {
 		return g.handleIDRequest(message)
 	case mysensors.I_TIME:
 		return g.handleTimeRequest(message)
+	case mysensors.I_SKETCH_NAME:
+		if g.registry != nil {
+			g.registry.SetSketchInfo(message.NodeID, message.Payload, "")
+		}
+		return nil
+	case mysensors.I_SKETCH_VERSION:
+		if g.registry != nil {
+			g.registry.SetSketchInfo(message.NodeID, "", message.Payload)
+		}
+		return nil
+	case mysensors.I_DISCOVER_RESPONSE:
+		return g.handleDiscoverResponse(message)
+	case mysensors.I_HEARTBEAT_RESPONSE:
+		g.logger.Debug("Heartbeat response", "node_id", message.NodeID, "payload", message.Payload)
+		return nil
+	case mysensors.I_PRE_SLEEP_NOTIFICATION:
+		if g.sleepQueue != nil {
+			g.sleepQueue.HandlePreSleep(message.NodeID)
+		}
+		return nil
+	case mysensors.I_POST_SLEEP_NOTIFICATION:
+		if g.sleepQueue != nil {
+			g.sleepQueue.HandlePostSleep(message.NodeID)
+		}
+		return nil
 	default:
 		return nil
 	}
@@ -75,6 +120,20 @@ func (g *Gateway) handleIDRequest(message *mysensors.Message) error {
 	return nil
 }
 
+func (g *Gateway) handleDiscoverResponse(message *mysensors.Message) error {
+	if g.registry == nil || message.NodeID == 0 || message.NodeID == 255 {
+		return nil
+	}
+
+	parentID, err := strconv.Atoi(message.Payload)
+	if err != nil {
+		return fmt.Errorf("invalid parent ID in discover response: %w", err)
+	}
+
+	g.registry.SetParent(message.NodeID, parentID)
+	return nil
+}
+
 func (g *Gateway) handleTimeRequest(message *mysensors.Message) error {
 	timestamp := time.Now().Unix()
 	response := mysensors.NewInternalMessage(message.NodeID, mysensors.I_TIME, fmt.Sprintf("%d", timestamp))
@@ -105,7 +164,7 @@ func (g *Gateway) assignNodeID() int {
 func (g *Gateway) assignSequentialNodeID() int {
 	// Original sequential assignment logic
 	for nodeID := g.gatewayConfig.NodeIDRange.Start; nodeID <= g.gatewayConfig.NodeIDRange.End; nodeID++ {
-		if !g.seenNodes[nodeID] {
+		if !g.isNodeIDTaken(nodeID) {
 			return nodeID
 		}
 	}
@@ -116,7 +175,7 @@ func (g *Gateway) assignRandomNodeID() int {
 	// Build list of available node IDs
 	var availableIDs []int
 	for nodeID := g.gatewayConfig.NodeIDRange.Start; nodeID <= g.gatewayConfig.NodeIDRange.End; nodeID++ {
-		if !g.seenNodes[nodeID] {
+		if !g.isNodeIDTaken(nodeID) {
 			availableIDs = append(availableIDs, nodeID)
 		}
 	}
@@ -131,12 +190,26 @@ func (g *Gateway) assignRandomNodeID() int {
 	return availableIDs[randomIndex]
 }
 
+// isNodeIDTaken reports whether a node ID was seen in this session or is held by the registry.
+// Must be called with g.nodesMu held.
+func (g *Gateway) isNodeIDTaken(nodeID int) bool {
+	if g.seenNodes[nodeID] {
+		return true
+	}
+	return g.registry != nil && g.registry.IsAssigned(nodeID)
+}
+
 func (g *Gateway) trackNode(nodeID int) {
 	if nodeID == 0 || nodeID == 255 {
 		return
 	}
 
+	if g.registry != nil {
+		g.registry.Touch(nodeID)
+	}
+
 	g.nodesMu.Lock()
+	g.lastSeen[nodeID] = time.Now()
 	wasNew := !g.seenNodes[nodeID]
 	if wasNew {
 		g.seenNodes[nodeID] = true
@@ -180,3 +253,98 @@ func (g *Gateway) SendVersionRequest() error {
 	g.logger.Debug("Sent version request to gateway")
 	return nil
 }
+
+// LastSeen returns when a node last sent a message. Nodes not seen since startup
+// fall back to the last-seen time stored in the node registry.
+func (g *Gateway) LastSeen(nodeID int) (time.Time, bool) {
+	g.nodesMu.RLock()
+	lastSeen, exists := g.lastSeen[nodeID]
+	g.nodesMu.RUnlock()
+	if exists {
+		return lastSeen, true
+	}
+
+	if g.registry != nil {
+		if record, exists := g.registry.Get(nodeID); exists && !record.LastSeen.IsZero() {
+			return record.LastSeen, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// SendHeartbeatRequest asks a node to answer with I_HEARTBEAT_RESPONSE
+func (g *Gateway) SendHeartbeatRequest(nodeID int) error {
+	message := mysensors.NewInternalMessage(nodeID, mysensors.I_HEARTBEAT_REQUEST, "")
+
+	if err := g.transport.Send(message); err != nil {
+		g.logger.Error("Failed to send heartbeat request", "error", err, "node_id", nodeID)
+		return err
+	}
+
+	g.logger.Debug("Sent heartbeat request", "node_id", nodeID)
+	return nil
+}
+
+// Send transmits a message to the network, holding SETs for sleeping nodes until they wake up
+func (g *Gateway) Send(message *mysensors.Message) error {
+	if g.sleepQueue != nil {
+		return g.sleepQueue.Send(message)
+	}
+	return g.transport.Send(message)
+}
+
+// IsQueued reports whether a message is waiting for a sleeping node
+func (g *Gateway) IsQueued(message *mysensors.Message) bool {
+	return g.sleepQueue != nil && g.sleepQueue.IsQueued(message)
+}
+
+// SleepQueue returns the smart-sleep queue, or nil if it is disabled
+func (g *Gateway) SleepQueue() *SleepQueue {
+	return g.sleepQueue
+}
+
+// Registry returns the persistent node registry, or nil if it is disabled
+func (g *Gateway) Registry() *NodeRegistry {
+	return g.registry
+}
+
+// ReserveNodeID prevents a node ID from being handed out by ID assignment
+func (g *Gateway) ReserveNodeID(nodeID int) error {
+	if g.registry == nil {
+		return fmt.Errorf("node registry is disabled")
+	}
+	if nodeID < 1 || nodeID > 254 {
+		return fmt.Errorf("invalid node ID %d", nodeID)
+	}
+
+	g.registry.Reserve(nodeID)
+	g.logger.Info("Reserved node ID", "node_id", nodeID)
+	return nil
+}
+
+// RetireNodeID forgets a node so that its ID can be assigned again
+func (g *Gateway) RetireNodeID(nodeID int) error {
+	if g.registry == nil {
+		return fmt.Errorf("node registry is disabled")
+	}
+
+	g.nodesMu.Lock()
+	delete(g.lastSeen, nodeID)
+	if g.seenNodes[nodeID] {
+		delete(g.seenNodes, nodeID)
+		for i, id := range g.seenNodesOrder {
+			if id == nodeID {
+				g.seenNodesOrder = append(g.seenNodesOrder[:i], g.seenNodesOrder[i+1:]...)
+				break
+			}
+		}
+	}
+	g.nodesMu.Unlock()
+
+	if !g.registry.Retire(nodeID) {
+		return fmt.Errorf("node ID %d is not in the registry", nodeID)
+	}
+
+	g.logger.Info("Retired node ID", "node_id", nodeID)
+	return nil
+}
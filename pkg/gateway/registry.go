@@ -0,0 +1,252 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// lastSeenSaveInterval limits how often a plain last-seen update is written to disk
+const lastSeenSaveInterval = 5 * time.Minute
+
+// NodeRecord describes a node known to a gateway
+type NodeRecord struct {
+	NodeID        int       `json:"node_id"`
+	FirstSeen     time.Time `json:"first_seen,omitempty"`
+	LastSeen      time.Time `json:"last_seen,omitempty"`
+	SketchName    string    `json:"sketch_name,omitempty"`
+	SketchVersion string    `json:"sketch_version,omitempty"`
+	ParentID      *int      `json:"parent_id,omitempty"`
+	Reserved      bool      `json:"reserved,omitempty"`
+}
+
+// RegistryChangeHandler is called with a snapshot of all records after the registry changed
+type RegistryChangeHandler func(nodes []NodeRecord)
+
+// NodeRegistry persists the node IDs assigned on a gateway so that ID assignment survives restarts
+type NodeRegistry struct {
+	path      string
+	logger    *slog.Logger
+	nodes     map[int]*NodeRecord
+	lastSaved map[int]time.Time
+	mu        sync.Mutex
+	onChange  RegistryChangeHandler
+}
+
+// NewNodeRegistry creates a registry stored as nodes-<gateway>.json in dataDir
+func NewNodeRegistry(dataDir, gatewayName string, logger *slog.Logger) *NodeRegistry {
+	return &NodeRegistry{
+		path:      filepath.Join(dataDir, fmt.Sprintf("nodes-%s.json", gatewayName)),
+		logger:    logger,
+		nodes:     make(map[int]*NodeRecord),
+		lastSaved: make(map[int]time.Time),
+	}
+}
+
+// Load reads the registry from disk. A missing file is not an error.
+func (r *NodeRegistry) Load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			r.logger.Info("No node registry found, starting empty", "path", r.path)
+			return nil
+		}
+		return fmt.Errorf("failed to read node registry: %w", err)
+	}
+
+	var records []NodeRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("failed to parse node registry %s: %w", r.path, err)
+	}
+
+	for i := range records {
+		record := records[i]
+		r.nodes[record.NodeID] = &record
+		r.lastSaved[record.NodeID] = record.LastSeen
+	}
+
+	r.logger.Info("Loaded node registry", "path", r.path, "nodes", len(r.nodes))
+	return nil
+}
+
+// SetChangeHandler registers a callback invoked after every persisted change
+func (r *NodeRegistry) SetChangeHandler(handler RegistryChangeHandler) {
+	r.mu.Lock()
+	r.onChange = handler
+	r.mu.Unlock()
+}
+
+// IsAssigned returns true if the node ID is known or reserved
+func (r *NodeRegistry) IsAssigned(nodeID int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, exists := r.nodes[nodeID]
+	return exists
+}
+
+// Get returns a copy of the record for a node
+func (r *NodeRegistry) Get(nodeID int) (NodeRecord, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record, exists := r.nodes[nodeID]
+	if !exists {
+		return NodeRecord{}, false
+	}
+	return *record, true
+}
+
+// Nodes returns a snapshot of all records sorted by node ID
+func (r *NodeRegistry) Nodes() []NodeRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.snapshot()
+}
+
+// Touch records activity from a node, adding it to the registry if needed
+func (r *NodeRegistry) Touch(nodeID int) {
+	now := time.Now()
+
+	r.mu.Lock()
+	record, exists := r.nodes[nodeID]
+	if !exists {
+		record = &NodeRecord{NodeID: nodeID, FirstSeen: now}
+		r.nodes[nodeID] = record
+	}
+	record.LastSeen = now
+	if record.FirstSeen.IsZero() {
+		record.FirstSeen = now
+	}
+
+	// Avoid rewriting the file on every message; only persist new nodes or stale last-seen times
+	if exists && now.Sub(r.lastSaved[nodeID]) < lastSeenSaveInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.saveAndNotify()
+}
+
+// SetSketchInfo updates the sketch name or version reported by a node
+func (r *NodeRegistry) SetSketchInfo(nodeID int, name, version string) {
+	r.update(nodeID, func(record *NodeRecord) bool {
+		changed := false
+		if name != "" && record.SketchName != name {
+			record.SketchName = name
+			changed = true
+		}
+		if version != "" && record.SketchVersion != version {
+			record.SketchVersion = version
+			changed = true
+		}
+		return changed
+	})
+}
+
+// SetParent updates the parent node reported by a node
+func (r *NodeRegistry) SetParent(nodeID, parentID int) {
+	r.update(nodeID, func(record *NodeRecord) bool {
+		if record.ParentID != nil && *record.ParentID == parentID {
+			return false
+		}
+		record.ParentID = &parentID
+		return true
+	})
+}
+
+// Reserve marks a node ID as in use without the node having been seen
+func (r *NodeRegistry) Reserve(nodeID int) {
+	r.update(nodeID, func(record *NodeRecord) bool {
+		if record.Reserved {
+			return false
+		}
+		record.Reserved = true
+		return true
+	})
+}
+
+// Retire removes a node ID from the registry so that it can be assigned again
+func (r *NodeRegistry) Retire(nodeID int) bool {
+	r.mu.Lock()
+	if _, exists := r.nodes[nodeID]; !exists {
+		r.mu.Unlock()
+		return false
+	}
+	delete(r.nodes, nodeID)
+	delete(r.lastSaved, nodeID)
+	r.saveAndNotify()
+	return true
+}
+
+// update applies fn to the record for nodeID, creating it if needed, and persists if fn reports a change
+func (r *NodeRegistry) update(nodeID int, fn func(record *NodeRecord) bool) {
+	r.mu.Lock()
+	record, exists := r.nodes[nodeID]
+	if !exists {
+		record = &NodeRecord{NodeID: nodeID}
+		r.nodes[nodeID] = record
+	}
+	if !fn(record) && exists {
+		r.mu.Unlock()
+		return
+	}
+	r.saveAndNotify()
+}
+
+// saveAndNotify writes the registry to disk and releases the lock before calling the change handler.
+// Must be called with r.mu held.
+func (r *NodeRegistry) saveAndNotify() {
+	if err := r.save(); err != nil {
+		r.logger.Error("Failed to save node registry", "path", r.path, "error", err)
+	}
+	handler := r.onChange
+	nodes := r.snapshot()
+	r.mu.Unlock()
+
+	if handler != nil {
+		handler(nodes)
+	}
+}
+
+func (r *NodeRegistry) save() error {
+	records := r.snapshot()
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal node registry: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	// Write to a temporary file and rename so that a crash never leaves a truncated registry
+	tmpPath := r.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write node registry: %w", err)
+	}
+	if err := os.Rename(tmpPath, r.path); err != nil {
+		return fmt.Errorf("failed to replace node registry: %w", err)
+	}
+
+	for _, record := range records {
+		r.lastSaved[record.NodeID] = record.LastSeen
+	}
+	return nil
+}
+
+func (r *NodeRegistry) snapshot() []NodeRecord {
+	records := make([]NodeRecord, 0, len(r.nodes))
+	for _, record := range r.nodes {
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].NodeID < records[j].NodeID
+	})
+	return records
+}
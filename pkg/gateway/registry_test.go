@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"ms-mqtt-adapter/internal/mysensors"
+	"ms-mqtt-adapter/pkg/config"
+	"sync"
+	"testing"
+)
+
+type fakeTransport struct {
+	mu   sync.Mutex
+	sent []*mysensors.Message
+}
+
+func (t *fakeTransport) Connect(ctx context.Context) error  { return nil }
+func (t *fakeTransport) Disconnect() error                  { return nil }
+func (t *fakeTransport) Receive() <-chan *mysensors.Message { return nil }
+func (t *fakeTransport) IsConnected() bool                  { return true }
+
+func (t *fakeTransport) Send(message *mysensors.Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = append(t.sent, message)
+	return nil
+}
+
+func (t *fakeTransport) messages() []*mysensors.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*mysensors.Message(nil), t.sent...)
+}
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// newTestRegistry loads the registry of gateway "gw" from dataDir, like a fresh start of the adapter
+func newTestRegistry(t *testing.T, dataDir string) *NodeRegistry {
+	t.Helper()
+	registry := NewNodeRegistry(dataDir, "gw", testLogger())
+	if err := registry.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	return registry
+}
+
+func newTestGateway(registry *NodeRegistry, start, end int) (*Gateway, *fakeTransport) {
+	gatewayConfig := &config.GatewayConfig{}
+	gatewayConfig.NodeIDRange.Start = start
+	gatewayConfig.NodeIDRange.End = end
+	transport := &fakeTransport{}
+	return NewGateway(gatewayConfig, registry, transport, testLogger()), transport
+}
+
+func TestNodeRegistryReserveRetire(t *testing.T) {
+	dataDir := t.TempDir()
+	registry := newTestRegistry(t, dataDir)
+
+	registry.Reserve(7)
+	if !registry.IsAssigned(7) {
+		t.Fatal("reserved node 7 is not assigned")
+	}
+	if record, _ := registry.Get(7); !record.Reserved || !record.LastSeen.IsZero() {
+		t.Errorf("record = %+v, want reserved and never seen", record)
+	}
+
+	// Reservations are persisted
+	if !newTestRegistry(t, dataDir).IsAssigned(7) {
+		t.Fatal("reserved node 7 was lost after a restart")
+	}
+
+	if !registry.Retire(7) {
+		t.Fatal("Retire(7) = false, want true")
+	}
+	if registry.Retire(7) {
+		t.Error("second Retire(7) = true, want false")
+	}
+	if registry.IsAssigned(7) {
+		t.Error("retired node 7 is still assigned")
+	}
+	if newTestRegistry(t, dataDir).IsAssigned(7) {
+		t.Error("retired node 7 came back after a restart")
+	}
+}
+
+func TestNodeRegistryChangeHandler(t *testing.T) {
+	registry := newTestRegistry(t, t.TempDir())
+	var snapshots [][]NodeRecord
+	registry.SetChangeHandler(func(nodes []NodeRecord) {
+		snapshots = append(snapshots, nodes)
+	})
+
+	registry.Reserve(3)
+	registry.Reserve(3)
+	registry.Touch(1)
+	registry.Retire(3)
+
+	if len(snapshots) != 3 {
+		t.Fatalf("handler called %d times, want 3", len(snapshots))
+	}
+	if nodes := snapshots[1]; len(nodes) != 2 || nodes[0].NodeID != 1 || nodes[1].NodeID != 3 {
+		t.Errorf("snapshot = %+v, want nodes 1 and 3 sorted by ID", nodes)
+	}
+	if nodes := snapshots[2]; len(nodes) != 1 || nodes[0].NodeID != 1 {
+		t.Errorf("snapshot = %+v, want node 1 only", nodes)
+	}
+}
+
+func TestNodeIDTakenAcrossRestart(t *testing.T) {
+	dataDir := t.TempDir()
+	gw, _ := newTestGateway(newTestRegistry(t, dataDir), 1, 254)
+	for _, nodeID := range []int{1, 2} {
+		if err := gw.HandleMessage(mysensors.NewSetMessage(nodeID, 0, mysensors.V_STATUS, "1")); err != nil {
+			t.Fatalf("HandleMessage() error = %v", err)
+		}
+	}
+	if err := gw.ReserveNodeID(3); err != nil {
+		t.Fatalf("ReserveNodeID(3) error = %v", err)
+	}
+
+	// After a restart the session has seen no nodes, only the registry knows them
+	restarted, transport := newTestGateway(newTestRegistry(t, dataDir), 1, 254)
+	for nodeID := 1; nodeID <= 3; nodeID++ {
+		if !restarted.isNodeIDTaken(nodeID) {
+			t.Errorf("node %d is free after a restart", nodeID)
+		}
+	}
+	if restarted.isNodeIDTaken(4) {
+		t.Error("unused node 4 is taken")
+	}
+
+	if err := restarted.HandleMessage(mysensors.NewInternalMessage(255, mysensors.I_ID_REQUEST, "")); err != nil {
+		t.Fatalf("ID request error = %v", err)
+	}
+	sent := transport.messages()
+	if len(sent) != 1 || sent[0].GetInternalType() != mysensors.I_ID_RESPONSE || sent[0].Payload != "4" {
+		t.Fatalf("sent %v, want an ID response with node 4", sent)
+	}
+
+	// A retired ID is handed out again
+	if err := restarted.RetireNodeID(2); err != nil {
+		t.Fatalf("RetireNodeID(2) error = %v", err)
+	}
+	if nodeID := restarted.assignNodeID(); nodeID != 2 {
+		t.Errorf("assignNodeID() = %d, want 2", nodeID)
+	}
+}
+
+func TestNodeIDExhaustion(t *testing.T) {
+	for _, random := range []bool{false, true} {
+		registry := newTestRegistry(t, t.TempDir())
+		gw, transport := newTestGateway(registry, 1, 254)
+		gw.gatewayConfig.RandomIDAssignment = &random
+		for nodeID := 1; nodeID < 254; nodeID++ {
+			registry.Reserve(nodeID)
+		}
+
+		if nodeID := gw.assignNodeID(); nodeID != 254 {
+			t.Errorf("random %t: assignNodeID() = %d, want the last free ID 254", random, nodeID)
+		}
+
+		registry.Reserve(254)
+		if nodeID := gw.assignNodeID(); nodeID != -1 {
+			t.Errorf("random %t: assignNodeID() = %d with all IDs taken, want -1", random, nodeID)
+		}
+		if err := gw.HandleMessage(mysensors.NewInternalMessage(255, mysensors.I_ID_REQUEST, "")); err == nil {
+			t.Errorf("random %t: ID request with all IDs taken succeeded", random)
+		}
+		if sent := transport.messages(); len(sent) != 0 {
+			t.Errorf("random %t: sent %v, want no ID response", random, sent)
+		}
+	}
+}
+
+func TestReserveNodeIDRange(t *testing.T) {
+	gw, _ := newTestGateway(newTestRegistry(t, t.TempDir()), 1, 254)
+	for _, nodeID := range []int{0, 255} {
+		if err := gw.ReserveNodeID(nodeID); err == nil {
+			t.Errorf("ReserveNodeID(%d) succeeded, want an error", nodeID)
+		}
+	}
+	if err := gw.RetireNodeID(9); err == nil {
+		t.Error("RetireNodeID(9) of an unknown node succeeded, want an error")
+	}
+}
@@ -0,0 +1,208 @@
+package gateway
+
+import (
+	"log/slog"
+	"ms-mqtt-adapter/internal/mysensors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// QueuedMessage is a SET waiting for a sleeping node to wake up
+type QueuedMessage struct {
+	ChildID  int       `json:"child_id"`
+	Type     string    `json:"type"`
+	Payload  string    `json:"payload"`
+	QueuedAt time.Time `json:"queued_at"`
+
+	message *mysensors.Message
+}
+
+// SleepingNode describes the sleep state and pending messages of a smart-sleep node
+type SleepingNode struct {
+	NodeID    int             `json:"node_id"`
+	Asleep    bool            `json:"asleep"`
+	LastSleep time.Time       `json:"last_sleep,omitempty"`
+	LastWake  time.Time       `json:"last_wake,omitempty"`
+	Pending   []QueuedMessage `json:"pending"`
+}
+
+// SleepQueueHandler is called with a snapshot of all smart-sleep nodes after the queue changed
+type SleepQueueHandler func(nodes []SleepingNode)
+
+type sleepState struct {
+	asleep    bool
+	lastSleep time.Time
+	lastWake  time.Time
+	pending   []QueuedMessage // Latest value per child and variable type, in arrival order
+}
+
+// SleepQueue holds outgoing SETs for nodes that announced smart sleep until they are listening again
+type SleepQueue struct {
+	transport Sender
+	logger    *slog.Logger
+	nodes     map[int]*sleepState
+	mu        sync.Mutex
+	onChange  SleepQueueHandler
+}
+
+// Sender delivers messages to a MySensors network
+type Sender interface {
+	Send(message *mysensors.Message) error
+}
+
+func NewSleepQueue(transport Sender, logger *slog.Logger) *SleepQueue {
+	return &SleepQueue{
+		transport: transport,
+		logger:    logger,
+		nodes:     make(map[int]*sleepState),
+	}
+}
+
+// SetChangeHandler registers a callback invoked whenever a node sleeps, wakes or its queue changes
+func (q *SleepQueue) SetChangeHandler(handler SleepQueueHandler) {
+	q.mu.Lock()
+	q.onChange = handler
+	q.mu.Unlock()
+}
+
+// Send transmits a message, or queues it if it is a SET for a sleeping node
+func (q *SleepQueue) Send(message *mysensors.Message) error {
+	q.mu.Lock()
+	state, exists := q.nodes[message.NodeID]
+	if !exists || !state.asleep || !message.IsSet() {
+		q.mu.Unlock()
+		return q.transport.Send(message)
+	}
+
+	queued := QueuedMessage{
+		ChildID:  message.ChildID,
+		Type:     message.GetVariableType().String(),
+		Payload:  message.Payload,
+		QueuedAt: time.Now(),
+		message:  message,
+	}
+
+	// A newer value for the same child and variable replaces the queued one
+	replaced := false
+	for i, pending := range state.pending {
+		if pending.message.ChildID == message.ChildID && pending.message.SubType == message.SubType {
+			state.pending = append(state.pending[:i], state.pending[i+1:]...)
+			replaced = true
+			break
+		}
+	}
+	state.pending = append(state.pending, queued)
+	depth := len(state.pending)
+	q.mu.Unlock()
+
+	q.logger.Info("Queued message for sleeping node", "node_id", message.NodeID, "child_id", message.ChildID,
+		"replaced", replaced, "depth", depth, "message", message.String())
+	q.notify()
+	return nil
+}
+
+// IsQueued reports whether a message is waiting for its node to wake up
+func (q *SleepQueue) IsQueued(message *mysensors.Message) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	state, exists := q.nodes[message.NodeID]
+	if !exists {
+		return false
+	}
+	for _, pending := range state.pending {
+		if pending.message.ChildID == message.ChildID && pending.message.SubType == message.SubType &&
+			pending.message.Payload == message.Payload {
+			return true
+		}
+	}
+	return false
+}
+
+// HandlePreSleep is called when a node announces it is about to sleep. The node keeps
+// listening for a short while, so pending messages are delivered before it is marked asleep.
+func (q *SleepQueue) HandlePreSleep(nodeID int) {
+	pending := q.take(nodeID, true)
+	q.flush(nodeID, pending)
+	q.logger.Debug("Node entering smart sleep", "node_id", nodeID, "flushed", len(pending))
+	q.notify()
+}
+
+// HandlePostSleep is called when a node reports it woke up
+func (q *SleepQueue) HandlePostSleep(nodeID int) {
+	pending := q.take(nodeID, false)
+	q.flush(nodeID, pending)
+	q.logger.Debug("Node woke up from smart sleep", "node_id", nodeID, "flushed", len(pending))
+	q.notify()
+}
+
+// take removes and returns the pending messages of a node and records its new sleep state
+func (q *SleepQueue) take(nodeID int, asleep bool) []QueuedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	state, exists := q.nodes[nodeID]
+	if !exists {
+		state = &sleepState{}
+		q.nodes[nodeID] = state
+	}
+
+	if asleep {
+		state.lastSleep = time.Now()
+	} else {
+		state.lastWake = time.Now()
+	}
+	state.asleep = asleep
+
+	pending := state.pending
+	state.pending = nil
+	return pending
+}
+
+func (q *SleepQueue) flush(nodeID int, pending []QueuedMessage) {
+	for _, queued := range pending {
+		if err := q.transport.Send(queued.message); err != nil {
+			q.logger.Error("Failed to deliver queued message", "node_id", nodeID, "error", err,
+				"message", queued.message.String())
+			continue
+		}
+		q.logger.Info("Delivered queued message", "node_id", nodeID, "queued_for", time.Since(queued.QueuedAt),
+			"message", queued.message.String())
+	}
+}
+
+// Nodes returns a snapshot of all smart-sleep nodes sorted by node ID
+func (q *SleepQueue) Nodes() []SleepingNode {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.snapshot()
+}
+
+func (q *SleepQueue) snapshot() []SleepingNode {
+	nodes := make([]SleepingNode, 0, len(q.nodes))
+	for nodeID, state := range q.nodes {
+		node := SleepingNode{
+			NodeID:    nodeID,
+			Asleep:    state.asleep,
+			LastSleep: state.lastSleep,
+			LastWake:  state.lastWake,
+			Pending:   make([]QueuedMessage, len(state.pending)),
+		}
+		copy(node.Pending, state.pending)
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeID < nodes[j].NodeID })
+	return nodes
+}
+
+func (q *SleepQueue) notify() {
+	q.mu.Lock()
+	handler := q.onChange
+	nodes := q.snapshot()
+	q.mu.Unlock()
+
+	if handler != nil {
+		handler(nodes)
+	}
+}
@@ -0,0 +1,158 @@
+package gateway
+
+import (
+	"ms-mqtt-adapter/internal/mysensors"
+	"ms-mqtt-adapter/pkg/config"
+	"testing"
+)
+
+func TestSleepQueueSendsToAwakeNodes(t *testing.T) {
+	transport := &fakeTransport{}
+	queue := NewSleepQueue(transport, testLogger())
+
+	// Nodes that never announced sleep get their messages right away
+	message := mysensors.NewSetMessage(5, 1, mysensors.V_STATUS, "1")
+	if err := queue.Send(message); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if sent := transport.messages(); len(sent) != 1 || sent[0] != message {
+		t.Fatalf("sent %v, want the message right away", sent)
+	}
+	if queue.IsQueued(message) {
+		t.Error("IsQueued() = true for a delivered message")
+	}
+}
+
+func TestSleepQueueKeepsLatestPerChildAndType(t *testing.T) {
+	transport := &fakeTransport{}
+	queue := NewSleepQueue(transport, testLogger())
+	queue.HandlePreSleep(5)
+
+	on := mysensors.NewSetMessage(5, 1, mysensors.V_STATUS, "1")
+	off := mysensors.NewSetMessage(5, 1, mysensors.V_STATUS, "0")
+	level := mysensors.NewSetMessage(5, 1, mysensors.V_PERCENTAGE, "50")
+	other := mysensors.NewSetMessage(5, 2, mysensors.V_STATUS, "1")
+	for _, message := range []*mysensors.Message{on, level, other, off} {
+		if err := queue.Send(message); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	if sent := transport.messages(); len(sent) != 0 {
+		t.Fatalf("sent %v to a sleeping node, want them queued", sent)
+	}
+
+	nodes := queue.Nodes()
+	if len(nodes) != 1 || !nodes[0].Asleep || len(nodes[0].Pending) != 3 {
+		t.Fatalf("nodes = %+v, want node 5 asleep with 3 pending messages", nodes)
+	}
+	if !queue.IsQueued(off) {
+		t.Error("IsQueued() = false for the latest V_STATUS of child 1")
+	}
+	if queue.IsQueued(on) {
+		t.Error("IsQueued() = true for a replaced value")
+	}
+	if !queue.IsQueued(level) || !queue.IsQueued(other) {
+		t.Error("IsQueued() = false for another variable or child")
+	}
+
+	// Only SETs are held back
+	request := mysensors.NewReqMessage(5, 1, mysensors.V_STATUS)
+	if err := queue.Send(request); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if sent := transport.messages(); len(sent) != 1 || sent[0] != request {
+		t.Fatalf("sent %v, want only the request", sent)
+	}
+
+	// The replaced value goes last, in the order it arrived
+	queue.HandlePostSleep(5)
+	sent := transport.messages()[1:]
+	want := []*mysensors.Message{level, other, off}
+	if len(sent) != len(want) {
+		t.Fatalf("flushed %v, want %v", sent, want)
+	}
+	for i := range want {
+		if sent[i] != want[i] {
+			t.Errorf("flushed message %d = %s, want %s", i, sent[i], want[i])
+		}
+	}
+	if queue.IsQueued(off) {
+		t.Error("IsQueued() = true after the node woke up")
+	}
+	if nodes := queue.Nodes(); nodes[0].Asleep || len(nodes[0].Pending) != 0 || nodes[0].LastWake.IsZero() {
+		t.Errorf("node = %+v, want awake with nothing pending", nodes[0])
+	}
+
+	// An awake node gets its messages right away again
+	if err := queue.Send(on); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if sent := transport.messages(); sent[len(sent)-1] != on {
+		t.Error("message for an awake node was not sent")
+	}
+}
+
+func TestSleepQueueFlushesOnPreSleep(t *testing.T) {
+	transport := &fakeTransport{}
+	queue := NewSleepQueue(transport, testLogger())
+	var changes int
+	queue.SetChangeHandler(func(nodes []SleepingNode) { changes++ })
+
+	queue.HandlePreSleep(5)
+	message := mysensors.NewSetMessage(5, 1, mysensors.V_STATUS, "1")
+	if err := queue.Send(message); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	// A node that missed its wake notification still listens briefly before the next sleep
+	queue.HandlePreSleep(5)
+	if sent := transport.messages(); len(sent) != 1 || sent[0] != message {
+		t.Fatalf("sent %v, want the queued message on pre-sleep", sent)
+	}
+	if queue.IsQueued(message) {
+		t.Error("IsQueued() = true after the flush")
+	}
+	if nodes := queue.Nodes(); !nodes[0].Asleep {
+		t.Error("node is awake after a pre-sleep notification")
+	}
+	if changes != 3 {
+		t.Errorf("change handler called %d times, want 3", changes)
+	}
+}
+
+func TestGatewaySendThroughSleepQueue(t *testing.T) {
+	gw, transport := newTestGateway(nil, 1, 254)
+	message := mysensors.NewSetMessage(5, 1, mysensors.V_STATUS, "1")
+
+	if err := gw.HandleMessage(mysensors.NewInternalMessage(5, mysensors.I_PRE_SLEEP_NOTIFICATION, "500")); err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if err := gw.Send(message); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if !gw.IsQueued(message) || len(transport.messages()) != 0 {
+		t.Fatal("message for a sleeping node was not queued")
+	}
+
+	if err := gw.HandleMessage(mysensors.NewInternalMessage(5, mysensors.I_POST_SLEEP_NOTIFICATION, "")); err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if gw.IsQueued(message) || len(transport.messages()) != 1 {
+		t.Error("queued message was not delivered on wake up")
+	}
+
+	// With smart sleep disabled everything goes out right away
+	disabled := false
+	gatewayConfig := &config.GatewayConfig{SmartSleep: config.SmartSleepConfig{Enabled: &disabled}}
+	transport = &fakeTransport{}
+	gw = NewGateway(gatewayConfig, nil, transport, testLogger())
+	if err := gw.HandleMessage(mysensors.NewInternalMessage(5, mysensors.I_PRE_SLEEP_NOTIFICATION, "500")); err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if err := gw.Send(message); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if gw.IsQueued(message) || len(transport.messages()) != 1 {
+		t.Error("message was queued with smart sleep disabled")
+	}
+}
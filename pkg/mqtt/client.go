@@ -2,66 +2,149 @@ package mqtt
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"ms-mqtt-adapter/internal/mysensors"
 	"ms-mqtt-adapter/pkg/config"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
 type Client struct {
-	client     mqtt.Client
+	conn       connection
 	config     *config.MQTTConfig
 	adapterCfg *config.AdapterConfig
 	logger     *slog.Logger
 	devices    []config.Device
+	devicesMu  sync.RWMutex
 	states     map[string]string
 	stateMu    sync.RWMutex
 	handlers   map[string]StateChangeHandler
+	handlersMu sync.RWMutex
+
+	// Adapter topics registered with Subscribe, restored after a reconnect
+	subscriptions   map[string]receiveHandler
+	subscriptionsMu sync.RWMutex
+
+	// Adapter control commands by name, see control.go
+	controlHandlers map[string]ControlHandler
+	controlMu       sync.Mutex
+
+	connects    atomic.Int64
+	onReconnect func()
+
+	// Publishes handed to the network but not yet completed, and those that failed afterwards.
+	// publishesDone is closed once the last in-flight publish completed.
+	inFlight        int
+	publishesDone   chan struct{}
+	inFlightMu      sync.Mutex
+	publishFailures atomic.Int64
+
+	// Publishes held back while the broker is unreachable (nil if buffering is disabled)
+	queue *outboundQueue
 }
 
-type StateChangeHandler func(deviceName, componentName string, state string)
+// ResponseTarget is where the outcome of one MQTT 5 command is sent
+type ResponseTarget struct {
+	Topic           string
+	CorrelationData []byte
+}
 
-func NewClient(cfg *config.MQTTConfig, adapterCfg *config.AdapterConfig, devices []config.Device, logger *slog.Logger) *Client {
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", cfg.Broker, cfg.Port))
-	opts.SetClientID(cfg.ClientID)
-	if cfg.Username != "" {
-		opts.SetUsername(cfg.Username)
-	}
-	if cfg.Password != "" {
-		opts.SetPassword(cfg.Password)
-	}
-	opts.SetAutoReconnect(true)
-	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
-		logger.Error("MQTT connection lost", "error", err)
-	})
-	opts.SetReconnectingHandler(func(client mqtt.Client, opts *mqtt.ClientOptions) {
-		logger.Info("MQTT reconnecting...")
-	})
-	opts.SetOnConnectHandler(func(client mqtt.Client) {
-		logger.Info("MQTT connected")
-	})
+// CommandResponse is the reply to an MQTT 5 command that carried a response topic
+type CommandResponse struct {
+	Status string `json:"status"` // "confirmed", "sent", "superseded" or "failed"
+	State  string `json:"state,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// publishTimeout is how long a publish may stay incomplete before it is reported as slow
+const publishTimeout = 10 * time.Second
 
-	return &Client{
-		client:     mqtt.NewClient(opts),
+// StateChangeHandler receives commands. The response target is nil unless the command asked for
+// its outcome, which is then passed to RespondToCommand.
+type StateChangeHandler func(deviceName, componentName string, state string, response *ResponseTarget)
+
+// MessageHandler receives messages from topics subscribed with Subscribe
+type MessageHandler func(topic, payload string)
+
+func NewClient(cfg *config.MQTTConfig, adapterCfg *config.AdapterConfig, devices []config.Device, logger *slog.Logger) (*Client, error) {
+	c := &Client{
 		config:     cfg,
 		adapterCfg: adapterCfg,
 		logger:     logger,
 		devices:    devices,
 		states:     make(map[string]string),
 		handlers:   make(map[string]StateChangeHandler),
+
+		subscriptions:   make(map[string]receiveHandler),
+		controlHandlers: make(map[string]ControlHandler),
+	}
+
+	if cfg.Buffer.Enabled == nil || *cfg.Buffer.Enabled {
+		path := ""
+		if cfg.Buffer.Persist {
+			path = filepath.Join(adapterCfg.DataDir, "mqtt-buffer.json")
+		}
+		c.queue = newOutboundQueue(path, cfg.Buffer.MaxMessages, logger)
+		if err := c.queue.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.UsesTLS() {
+		var err error
+		if tlsConfig, err = newTLSConfig(cfg, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	// The broker marks the adapter offline if the connection drops without a clean disconnect
+	will := &outgoingMessage{Topic: c.adapterStatusTopic(), Payload: "offline", QoS: 1, Retain: true}
+	handlers := connectionHandlers{
+		onConnect: c.handleConnect,
+		onConnectionLost: func(err error) {
+			logger.Error("MQTT connection lost", "error", err)
+		},
+	}
+
+	if cfg.ProtocolVersion == 5 {
+		conn, err := newMQTT5Connection(cfg, tlsConfig, will, handlers, logger)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+	} else {
+		c.conn = newMQTT3Connection(cfg, tlsConfig, will, handlers, logger)
+	}
+	return c, nil
+}
+
+// handleConnect runs after every successful connection to the broker
+func (c *Client) handleConnect() {
+	connects := c.connects.Add(1)
+	c.logger.Info("MQTT connected", "reconnects", connects-1, "protocol_version", c.config.ProtocolVersion)
+	if err := c.publishAdapterAvailability(true); err != nil {
+		c.logger.Error("Failed to publish adapter status", "error", err)
+	}
+	c.replayQueue()
+
+	// The initial subscriptions are made by Connect
+	if connects > 1 {
+		c.restoreSession()
 	}
 }
 
 func (c *Client) Connect(ctx context.Context) error {
-	token := c.client.Connect()
+	token := c.conn.Connect()
 	if !token.WaitTimeout(10 * time.Second) {
 		return fmt.Errorf("MQTT connection timeout")
 	}
@@ -82,73 +165,269 @@ func (c *Client) Connect(ctx context.Context) error {
 	return nil
 }
 
-func (c *Client) Disconnect() {
-	c.client.Disconnect(250)
-	c.logger.Info("MQTT client disconnected")
+// SetReconnectHandler registers a callback run after subscriptions were restored on a reconnect
+func (c *Client) SetReconnectHandler(handler func()) {
+	c.handlersMu.Lock()
+	c.onReconnect = handler
+	c.handlersMu.Unlock()
 }
 
-func (c *Client) IsConnected() bool {
-	return c.client.IsConnected()
+// ReconnectCount returns how many times the connection to the broker was re-established
+func (c *Client) ReconnectCount() int64 {
+	connects := c.connects.Load()
+	if connects == 0 {
+		return 0
+	}
+	return connects - 1
 }
 
-func (c *Client) subscribeToDevices() error {
-	for _, device := range c.devices {
-		// Subscribe to entity command topics
+// restoreSession re-creates subscriptions lost with a clean session and republishes entity states.
+// States are republished before state topics are subscribed again, so stale retained values
+// from before the disconnect do not overwrite newer states.
+func (c *Client) restoreSession() {
+	if err := c.subscribeToDevices(); err != nil {
+		c.logger.Error("Failed to restore device subscriptions", "error", err)
+	}
+
+	c.RepublishStates()
+	c.waitForPublishes(5 * time.Second)
+
+	if err := c.subscribeToStateTopic(); err != nil {
+		c.logger.Error("Failed to restore state subscriptions", "error", err)
+	}
+
+	c.subscriptionsMu.RLock()
+	subscriptions := make(map[string]receiveHandler, len(c.subscriptions))
+	for topic, handler := range c.subscriptions {
+		subscriptions[topic] = handler
+	}
+	c.subscriptionsMu.RUnlock()
+	for topic, handler := range subscriptions {
+		if err := c.subscribe(topic, handler); err != nil {
+			c.logger.Error("Failed to restore subscription", "topic", topic, "error", err)
+		}
+	}
+
+	reconnects := c.ReconnectCount()
+	if err := c.Publish(fmt.Sprintf("%s/adapter/mqtt_reconnects", c.adapterCfg.TopicPrefix), strconv.FormatInt(reconnects, 10), true); err != nil {
+		c.logger.Error("Failed to publish reconnect count", "error", err)
+	}
+	c.logger.Info("MQTT session restored after reconnect", "reconnects", reconnects)
+
+	c.handlersMu.RLock()
+	handler := c.onReconnect
+	c.handlersMu.RUnlock()
+	if handler != nil {
+		handler()
+	}
+}
+
+// RepublishStates publishes every known entity state to its retained state topic
+func (c *Client) RepublishStates() {
+	for _, device := range c.getDevices() {
 		for _, entity := range device.Entities {
-			// Only subscribe to command topics for entities that can receive commands
-			if !entity.CanReceiveCommands() {
+			if !entity.CanReportState() {
 				continue
 			}
-			
-			// Subscribe to device-specific topic using device_id/entity/subdevice_id format
-			topic := fmt.Sprintf("%s/devices/%s/entity/%s/set", c.adapterCfg.TopicPrefix, device.ID, entity.ID)
-			// Create composite key for uniqueness across devices
+			for _, attribute := range entity.Attributes() {
+				attributeKey := fmt.Sprintf("%s_%s_entity_%s", device.ID, entity.ID, attribute.Name)
+				if state, exists := c.GetState(attributeKey); exists {
+					if err := c.PublishAttributeState(device, entity, attribute.Name, state); err != nil {
+						c.logger.Error("Failed to republish entity state", "device", device.Name, "entity", entity.Name, "attribute", attribute.Name, "error", err)
+					}
+				}
+			}
 			compositeKey := fmt.Sprintf("%s_%s_entity", device.ID, entity.ID)
-			token := c.client.Subscribe(topic, 0, c.createEntityHandler(device.Name, entity.Name, compositeKey, device.ID, entity.ID, entity.EntityType))
-			if !token.WaitTimeout(5 * time.Second) {
-				return fmt.Errorf("subscription timeout for topic %s", topic)
+			if state, exists := c.GetState(compositeKey); exists {
+				if err := c.PublishEntityState(device, entity, state); err != nil {
+					c.logger.Error("Failed to republish entity state", "device", device.Name, "entity", entity.Name, "error", err)
+				}
 			}
-			if token.Error() != nil {
-				return fmt.Errorf("subscription failed for topic %s: %w", topic, token.Error())
+		}
+	}
+}
+
+func (c *Client) Disconnect() {
+	// The last will is not sent on a clean disconnect, so publish offline ourselves
+	if c.conn.IsConnected() {
+		c.waitForPublishes(2 * time.Second)
+		if err := c.publishAdapterAvailability(false); err != nil {
+			c.logger.Error("Failed to publish adapter status", "error", err)
+		}
+	}
+	c.conn.Disconnect(250 * time.Millisecond)
+	if c.queue != nil {
+		c.queue.flush()
+	}
+	c.logger.Info("MQTT client disconnected")
+}
+
+func (c *Client) IsConnected() bool {
+	return c.conn.IsConnected()
+}
+
+func (c *Client) subscribeToDevices() error {
+	for _, device := range c.getDevices() {
+		if err := c.subscribeToDeviceCommands(device); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) subscribeToDeviceCommands(device config.Device) error {
+	// Subscribe to entity command topics
+	for _, entity := range device.Entities {
+		// Only subscribe to command topics for entities that can receive commands
+		if !entity.CanReceiveCommands() {
+			continue
+		}
+		qos := c.subscribeQoS(c.adapterCfg.GetEntityQoS(&entity))
+
+		// Entities with several variables take commands per attribute
+		if attributes := entity.Attributes(); attributes != nil {
+			for i := range attributes {
+				attribute := &attributes[i]
+				if !attribute.Command {
+					continue
+				}
+				topic := c.attributeTopic(device, entity, attribute.Name) + "/set"
+				attributeKey := fmt.Sprintf("%s_%s_entity_%s", device.ID, entity.ID, attribute.Name)
+				if err := c.subscribeEntityTopic(topic, qos, c.createEntityHandler(device.Name, entity.Name, attributeKey, device.ID, entity.ID, entity.EntityType, attribute)); err != nil {
+					return err
+				}
 			}
-			c.logger.Debug("Subscribed to entity topic", "topic", topic)
+			continue
+		}
+		
+		// Subscribe to device-specific topic using device_id/entity/subdevice_id format
+		topic := c.commandTopic(device, entity)
+		// Create composite key for uniqueness across devices
+		compositeKey := fmt.Sprintf("%s_%s_entity", device.ID, entity.ID)
+		if err := c.subscribeEntityTopic(topic, qos, c.createEntityHandler(device.Name, entity.Name, compositeKey, device.ID, entity.ID, entity.EntityType, nil)); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+func (c *Client) subscribeEntityTopic(topic string, qos byte, handler receiveHandler) error {
+	token := c.conn.Subscribe(topic, qos, handler)
+	if !token.WaitTimeout(5 * time.Second) {
+		return fmt.Errorf("subscription timeout for topic %s", topic)
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("subscription failed for topic %s: %w", topic, token.Error())
+	}
+	c.logger.Debug("Subscribed to entity topic", "topic", topic)
+	return nil
+}
+
 func (c *Client) subscribeToStateTopic() error {
-	for _, device := range c.devices {
-		// Subscribe to entity state topics
-		for _, entity := range device.Entities {
-			// Only subscribe to state topics for entities that can report state
-			if !entity.CanReportState() {
-				continue
-			}
-			
-			stateTopic := fmt.Sprintf("%s/devices/%s/entity/%s/state", c.adapterCfg.TopicPrefix, device.ID, entity.ID)
-			compositeKey := fmt.Sprintf("%s_%s_entity", device.ID, entity.ID)
-			token := c.client.Subscribe(stateTopic, 0, c.createEntityStateHandler(compositeKey, entity.EntityType))
-			if !token.WaitTimeout(5 * time.Second) {
-				return fmt.Errorf("subscription timeout for entity state topic %s", stateTopic)
-			}
-			if token.Error() != nil {
-				return fmt.Errorf("subscription failed for entity state topic %s: %w", stateTopic, token.Error())
+	for _, device := range c.getDevices() {
+		if err := c.subscribeToDeviceStates(device); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) subscribeToDeviceStates(device config.Device) error {
+	// Subscribe to entity state topics
+	for _, entity := range device.Entities {
+		// Only subscribe to state topics for entities that can report state
+		if !entity.CanReportState() {
+			continue
+		}
+
+		for _, attribute := range entity.Attributes() {
+			stateTopic := c.attributeTopic(device, entity, attribute.Name) + "/state"
+			attributeKey := fmt.Sprintf("%s_%s_entity_%s", device.ID, entity.ID, attribute.Name)
+			if err := c.subscribeEntityTopic(stateTopic, c.subscribeQoS(c.adapterCfg.GetEntityQoS(&entity)), c.createEntityStateHandler(attributeKey, entity.EntityType, &attribute)); err != nil {
+				return err
 			}
-			c.logger.Debug("Subscribed to entity state topic", "topic", stateTopic)
 		}
+		if entity.Attributes() != nil {
+			continue
+		}
+		
+		stateTopic := c.stateTopic(device, entity)
+		compositeKey := fmt.Sprintf("%s_%s_entity", device.ID, entity.ID)
+		token := c.conn.Subscribe(stateTopic, c.subscribeQoS(c.adapterCfg.GetEntityQoS(&entity)), c.createEntityStateHandler(compositeKey, entity.EntityType, nil))
+		if !token.WaitTimeout(5 * time.Second) {
+			return fmt.Errorf("subscription timeout for entity state topic %s", stateTopic)
+		}
+		if token.Error() != nil {
+			return fmt.Errorf("subscription failed for entity state topic %s: %w", stateTopic, token.Error())
+		}
+		c.logger.Debug("Subscribed to entity state topic", "topic", stateTopic)
 	}
 	return nil
 }
 
-func (c *Client) createStateHandler(uniqueID string) mqtt.MessageHandler {
-	return func(client mqtt.Client, msg mqtt.Message) {
-		payload := string(msg.Payload())
-		c.logger.Debug("Received retained state message", "topic", msg.Topic(), "payload", payload)
+// subscribeQoS returns the QoS for a subscription, at least QoS 1 with a persistent session
+// so the broker queues commands while disconnected
+func (c *Client) subscribeQoS(qos byte) byte {
+	if qos == 0 && c.config.CleanSession != nil && !*c.config.CleanSession {
+		return 1
+	}
+	return qos
+}
+
+// getDevices returns a snapshot of the devices known to the client
+func (c *Client) getDevices() []config.Device {
+	c.devicesMu.RLock()
+	defer c.devicesMu.RUnlock()
+	devices := make([]config.Device, len(c.devices))
+	copy(devices, c.devices)
+	return devices
+}
+
+// AddDevice adds or replaces a device at runtime, subscribes to its topics and publishes its discovery
+// A device whose topics would collide with those of another device is rejected.
+func (c *Client) AddDevice(device config.Device) error {
+	c.devicesMu.Lock()
+	devices := make([]config.Device, 0, len(c.devices)+1)
+	replaced := false
+	for _, existing := range c.devices {
+		if existing.ID == device.ID {
+			existing = device
+			replaced = true
+		}
+		devices = append(devices, existing)
+	}
+	if !replaced {
+		devices = append(devices, device)
+	}
+	if err := c.adapterCfg.CheckEntityTopics(devices); err != nil {
+		c.devicesMu.Unlock()
+		return fmt.Errorf("device %s rejected: %w", device.ID, err)
+	}
+	c.devices = devices
+	c.devicesMu.Unlock()
+
+	if !c.IsConnected() {
+		// Subscriptions and discovery happen on connect
+		return nil
+	}
+
+	if err := c.subscribeToDeviceCommands(device); err != nil {
+		return fmt.Errorf("failed to subscribe to device topics: %w", err)
+	}
+	if err := c.subscribeToDeviceStates(device); err != nil {
+		return fmt.Errorf("failed to subscribe to state topics: %w", err)
+	}
+	return c.PublishHomeAssistantDiscovery(device)
+}
+
+func (c *Client) createStateHandler(uniqueID string) receiveHandler {
+	return func(msg *receivedMessage) {
+		payload := string(msg.Payload)
+		c.logger.Debug("Received retained state message", "topic", msg.Topic, "payload", payload)
 
 		// Skip empty payloads (might be cleared retained messages)
 		if len(payload) == 0 {
-			c.logger.Debug("Skipping empty payload (cleared retained message)", "topic", msg.Topic())
+			c.logger.Debug("Skipping empty payload (cleared retained message)", "topic", msg.Topic)
 			return
 		}
 
@@ -161,7 +440,7 @@ func (c *Client) createStateHandler(uniqueID string) mqtt.MessageHandler {
 		} else {
 			// For binary sensor topics, validate payload is 0 or 1
 			if payload != "0" && payload != "1" {
-				c.logger.Warn("Invalid state payload, expected 0 or 1", "payload", payload, "topic", msg.Topic())
+				c.logger.Warn("Invalid state payload, expected 0 or 1", "payload", payload, "topic", msg.Topic)
 				return
 			}
 
@@ -174,28 +453,57 @@ func (c *Client) createStateHandler(uniqueID string) mqtt.MessageHandler {
 }
 
 
-func (c *Client) createEntityHandler(deviceName, entityName, compositeKey, deviceID, entityID, entityType string) mqtt.MessageHandler {
-	return func(client mqtt.Client, msg mqtt.Message) {
-		payload := string(msg.Payload())
-		c.logger.Debug("MQTT RX", "topic", msg.Topic(), "payload", payload)
+// createEntityHandler handles commands for an entity, or for one attribute of an entity with several variables
+func (c *Client) createEntityHandler(deviceName, entityName, compositeKey, deviceID, entityID, entityType string, attribute *config.EntityAttribute) receiveHandler {
+	return func(msg *receivedMessage) {
+		payload := string(msg.Payload)
+		c.logger.Debug("MQTT RX", "topic", msg.Topic, "payload", payload)
+
+		// MQTT 5 commands may ask for the outcome on a response topic
+		var response *ResponseTarget
+		if msg.ResponseTopic != "" {
+			response = &ResponseTarget{Topic: msg.ResponseTopic, CorrelationData: msg.CorrelationData}
+		}
 
 		// Validate payload based on entity type
-		if !c.validateEntityPayload(entityType, payload) {
+		valid := false
+		state := payload
+		if attribute != nil {
+			// The state is the decoded command, so equivalent payloads publish the same state
+			var encoded string
+			if encoded, valid = attribute.Encode(payload); valid {
+				state = attribute.Decode(encoded)
+			}
+		} else {
+			valid = c.validateEntityPayload(entityType, payload)
+		}
+		if !valid {
 			c.logger.Warn("Invalid entity payload", "entityType", entityType, "payload", payload)
+			if err := c.RespondToCommand(deviceID, entityID, response, CommandResponse{Status: "failed", Error: "invalid payload"}); err != nil {
+				c.logger.Error("Failed to publish command response", "device", deviceName, "entity", entityName, "error", err)
+			}
 			return
 		}
 
 		// Check if entity is configured for optimistic mode
 		optimistic := c.getEffectiveOptimisticModeForEntity(deviceID, entityID)
 
-		if optimistic {
+		// Commands with a variable per value, like opening a cover, are actions rather than states
+		if optimistic && attribute != nil && attribute.CommandVariables != nil {
+			c.logger.Debug("Optimistic mode: waiting for device state of action", "device", deviceName, "entity", entityName, "command", payload)
+		} else if optimistic {
 			// Optimistic mode: update MQTT state immediately (assume command will succeed)
-			deviceStateTopic := fmt.Sprintf("%s/devices/%s/entity/%s/state", c.adapterCfg.TopicPrefix, deviceID, entityID)
-			c.Publish(deviceStateTopic, payload, true)
-
-			c.stateMu.Lock()
-			c.states[compositeKey] = payload
-			c.stateMu.Unlock()
+			if device, entity, exists := c.findEntity(deviceID, entityID); exists {
+				var err error
+				if attribute != nil {
+					err = c.PublishAttributeState(device, entity, attribute.Name, state)
+				} else {
+					err = c.PublishEntityState(device, entity, payload)
+				}
+				if err != nil {
+					c.logger.Error("Failed to publish optimistic state", "device", deviceName, "entity", entityName, "error", err)
+				}
+			}
 
 			c.logger.Debug("Optimistic mode: updated MQTT state immediately", "device", deviceName, "entity", entityName, "state", payload)
 		} else {
@@ -204,31 +512,38 @@ func (c *Client) createEntityHandler(deviceName, entityName, compositeKey, devic
 		}
 
 		// Always notify the handler to send MySensors command
-		if handler, exists := c.handlers[compositeKey]; exists {
-			handler(deviceName, entityName, payload)
+		c.handlersMu.RLock()
+		handler, exists := c.handlers[compositeKey]
+		c.handlersMu.RUnlock()
+		if exists {
+			handler(deviceName, entityName, payload, response)
 		}
 	}
 }
 
 func (c *Client) RegisterStateChangeHandler(uniqueID string, handler StateChangeHandler) {
+	c.handlersMu.Lock()
 	c.handlers[uniqueID] = handler
+	c.handlersMu.Unlock()
 }
 
 
-func (c *Client) createEntityStateHandler(uniqueID string, entityType string) mqtt.MessageHandler {
-	return func(client mqtt.Client, msg mqtt.Message) {
-		payload := string(msg.Payload())
-		c.logger.Debug("Received retained entity state message", "topic", msg.Topic(), "payload", payload, "entityType", entityType)
+// createEntityStateHandler stores retained states. Attribute states are stored as published,
+// they are Home Assistant values rather than commands for the entity type.
+func (c *Client) createEntityStateHandler(uniqueID string, entityType string, attribute *config.EntityAttribute) receiveHandler {
+	return func(msg *receivedMessage) {
+		payload := string(msg.Payload)
+		c.logger.Debug("Received retained entity state message", "topic", msg.Topic, "payload", payload, "entityType", entityType)
 
 		// Skip empty payloads (might be cleared retained messages)
 		if len(payload) == 0 {
-			c.logger.Debug("Skipping empty payload (cleared retained message)", "topic", msg.Topic())
+			c.logger.Debug("Skipping empty payload (cleared retained message)", "topic", msg.Topic)
 			return
 		}
 
 		// Validate payload based on entity type
-		if !c.validateEntityPayload(entityType, payload) {
-			c.logger.Warn("Invalid retained entity state payload", "entityType", entityType, "payload", payload, "topic", msg.Topic())
+		if attribute == nil && !c.validateEntityPayload(entityType, payload) {
+			c.logger.Warn("Invalid retained entity state payload", "entityType", entityType, "payload", payload, "topic", msg.Topic)
 			return
 		}
 
@@ -271,10 +586,25 @@ func (c *Client) validateEntityPayload(entityType, payload string) bool {
 	}
 }
 
+// findEntity returns the configuration of an entity by device and entity ID
+func (c *Client) findEntity(deviceID, entityID string) (config.Device, config.Entity, bool) {
+	for _, device := range c.getDevices() {
+		if device.ID != deviceID {
+			continue
+		}
+		for _, entity := range device.Entities {
+			if entity.ID == entityID {
+				return device, entity, true
+			}
+		}
+	}
+	return config.Device{}, config.Entity{}, false
+}
+
 // getEffectiveOptimisticModeForEntity determines the effective optimistic mode for a specific entity
 func (c *Client) getEffectiveOptimisticModeForEntity(deviceID, entityID string) bool {
 	// Find the device and entity configuration
-	for _, device := range c.devices {
+	for _, device := range c.getDevices() {
 		if device.ID == deviceID {
 			for _, entity := range device.Entities {
 				if entity.ID == entityID {
@@ -303,19 +633,196 @@ func (c *Client) getEffectiveOptimisticModeForEntity(deviceID, entityID string)
 
 
 
-func (c *Client) Publish(topic, payload string, retain bool) error {
-	token := c.client.Publish(topic, 0, retain, payload)
+// Subscribe registers a handler for an adapter topic that is not tied to a device
+func (c *Client) Subscribe(topic string, handler MessageHandler) error {
+	return c.addSubscription(topic, func(msg *receivedMessage) {
+		handler(msg.Topic, string(msg.Payload))
+	})
+}
+
+// addSubscription subscribes a topic and restores the subscription after a reconnect
+func (c *Client) addSubscription(topic string, handler receiveHandler) error {
+	c.subscriptionsMu.Lock()
+	c.subscriptions[topic] = handler
+	c.subscriptionsMu.Unlock()
+
+	return c.subscribe(topic, handler)
+}
+
+func (c *Client) subscribe(topic string, handler receiveHandler) error {
+	token := c.conn.Subscribe(topic, c.subscribeQoS(0), func(msg *receivedMessage) {
+		c.logger.Debug("MQTT RX", "topic", msg.Topic, "payload", string(msg.Payload))
+		handler(msg)
+	})
 	if !token.WaitTimeout(5 * time.Second) {
-		return fmt.Errorf("publish timeout for topic %s", topic)
+		return fmt.Errorf("subscription timeout for topic %s", topic)
 	}
 	if token.Error() != nil {
-		return fmt.Errorf("publish failed for topic %s: %w", topic, token.Error())
+		return fmt.Errorf("subscription failed for topic %s: %w", topic, token.Error())
+	}
+
+	c.logger.Debug("Subscribed to topic", "topic", topic)
+	return nil
+}
+
+// IsOptimistic reports whether commands for an entity update its state without waiting for the node
+func (c *Client) IsOptimistic(deviceID, entityID string) bool {
+	return c.getEffectiveOptimisticModeForEntity(deviceID, entityID)
+}
+
+func (c *Client) Publish(topic, payload string, retain bool) error {
+	return c.publish(&outgoingMessage{Topic: topic, Payload: payload, Retain: retain})
+}
+
+// publish hands a message to the network without waiting for the broker, so slow QoS 1/2
+// acknowledgements never block the caller. Errors known up front, such as not being
+// connected, are returned; later failures are logged when the publish completes.
+func (c *Client) publish(message *outgoingMessage) error {
+	if c.queue != nil && !c.conn.IsConnectionOpen() {
+		c.enqueue(message, time.Now())
+		return nil
 	}
 
-	c.logger.Debug("MQTT TX", "topic", topic, "payload", payload)
+	token := c.conn.Publish(message)
+	select {
+	case <-token.Done():
+		if token.Error() != nil {
+			if c.queue != nil && !c.conn.IsConnectionOpen() {
+				c.enqueue(message, time.Now())
+				return nil
+			}
+			return fmt.Errorf("publish failed for topic %s: %w", message.Topic, token.Error())
+		}
+	default:
+		c.publishStarted()
+		go c.trackPublish(token, message)
+	}
+
+	c.logger.Debug("MQTT TX", "topic", message.Topic, "payload", message.Payload, "qos", message.QoS, "retain", message.Retain)
 	return nil
 }
 
+// trackPublish waits for a publish to complete and records its outcome
+func (c *Client) trackPublish(token operationToken, message *outgoingMessage) {
+	defer c.publishCompleted()
+
+	if !token.WaitTimeout(publishTimeout) {
+		c.logger.Warn("MQTT publish not yet acknowledged", "topic", message.Topic, "qos", message.QoS, "in_flight", c.publishesInFlight())
+		<-token.Done()
+	}
+	if err := token.Error(); err != nil {
+		// The connection was lost before the publish completed, send it again after reconnecting
+		if c.queue != nil && !c.conn.IsConnectionOpen() {
+			c.enqueue(message, time.Now())
+			return
+		}
+		failures := c.publishFailures.Add(1)
+		c.logger.Error("MQTT publish failed", "topic", message.Topic, "qos", message.QoS, "error", err, "failures", failures)
+	}
+}
+
+// enqueue holds back a publish until the broker is reachable again
+func (c *Client) enqueue(message *outgoingMessage, queuedAt time.Time) {
+	kept := c.queue.push(&queuedMessage{outgoingMessage: *message, QueuedAt: queuedAt})
+	if !kept {
+		stats := c.queue.stats()
+		c.logger.Warn("MQTT buffer full, dropped oldest message", "depth", stats.Depth, "dropped", stats.Dropped)
+	}
+	c.logger.Debug("MQTT TX buffered", "topic", message.Topic, "payload", message.Payload)
+}
+
+// replayQueue publishes the messages buffered while disconnected in their original order
+// and reports the buffer statistics. Messages whose expiry passed while queued are skipped.
+func (c *Client) replayQueue() {
+	if c.queue == nil {
+		return
+	}
+
+	messages := c.queue.drain()
+	expired := 0
+	for _, queued := range messages {
+		message := queued.outgoingMessage
+		if message.Properties != nil && message.Properties.MessageExpiry > 0 {
+			remaining := message.Properties.MessageExpiry - time.Since(queued.QueuedAt)
+			if remaining <= 0 {
+				expired++
+				continue
+			}
+			properties := *message.Properties
+			properties.MessageExpiry = remaining
+			message.Properties = &properties
+		}
+		if err := c.publish(&message); err != nil {
+			c.logger.Error("Failed to replay buffered message", "topic", message.Topic, "error", err)
+		}
+	}
+
+	stats := c.queue.stats()
+	stats.Replayed = len(messages) - expired
+	if len(messages) > 0 || stats.Dropped > 0 {
+		c.logger.Info("Replayed buffered MQTT messages", "replayed", stats.Replayed, "expired", expired, "dropped", stats.Dropped, "depth", stats.Depth)
+	}
+	payload, err := json.Marshal(stats)
+	if err != nil {
+		c.logger.Error("Failed to marshal mqtt buffer stats", "error", err)
+		return
+	}
+	if err := c.Publish(fmt.Sprintf("%s/adapter/mqtt_buffer", c.adapterCfg.TopicPrefix), string(payload), true); err != nil {
+		c.logger.Error("Failed to publish mqtt buffer stats", "error", err)
+	}
+}
+
+// QueueStats returns the depth of the outbound buffer and the number of messages dropped from it
+func (c *Client) QueueStats() QueueStats {
+	if c.queue == nil {
+		return QueueStats{}
+	}
+	return c.queue.stats()
+}
+
+func (c *Client) publishStarted() {
+	c.inFlightMu.Lock()
+	defer c.inFlightMu.Unlock()
+	if c.inFlight == 0 {
+		c.publishesDone = make(chan struct{})
+	}
+	c.inFlight++
+}
+
+func (c *Client) publishCompleted() {
+	c.inFlightMu.Lock()
+	defer c.inFlightMu.Unlock()
+	c.inFlight--
+	if c.inFlight == 0 {
+		close(c.publishesDone)
+	}
+}
+
+func (c *Client) publishesInFlight() int {
+	c.inFlightMu.Lock()
+	defer c.inFlightMu.Unlock()
+	return c.inFlight
+}
+
+// waitForPublishes waits until all in-flight publishes completed or the timeout expired
+func (c *Client) waitForPublishes(timeout time.Duration) {
+	c.inFlightMu.Lock()
+	done := c.publishesDone
+	idle := c.inFlight == 0
+	c.inFlightMu.Unlock()
+	if idle {
+		return
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		c.logger.Warn("MQTT publishes still in flight", "in_flight", c.publishesInFlight())
+	}
+}
+
 func (c *Client) GetState(uniqueID string) (string, bool) {
 	c.stateMu.RLock()
 	defer c.stateMu.RUnlock()
@@ -376,8 +883,9 @@ func (c *Client) PublishHomeAssistantDiscovery(device config.Device) error {
 			return fmt.Errorf("failed to publish entity discovery: %w", err)
 		}
 
-		// Publish initial state for entities that can report state if no state already exists
-		if entity.CanReportState() {
+		// Publish initial state for entities that can report state if no state already exists.
+		// Entities with several variables wait for their node to report each of them.
+		if entity.CanReportState() && entity.Attributes() == nil {
 			compositeKey := fmt.Sprintf("%s_%s_entity", device.ID, entity.ID)
 			if existingState, exists := c.GetState(compositeKey); !exists {
 				initialValue := entity.InitialValue
@@ -417,16 +925,98 @@ func (c *Client) PublishHomeAssistantDiscovery(device config.Device) error {
 
 // PublishEntityState publishes the state of an entity
 func (c *Client) PublishEntityState(device config.Device, entity config.Entity, value string) error {
-	// Publish to device-specific state topic
-	deviceStateTopic := fmt.Sprintf("%s/devices/%s/entity/%s/state", c.adapterCfg.TopicPrefix, device.ID, entity.ID)
-	
-	// Update internal state tracking
 	compositeKey := fmt.Sprintf("%s_%s_entity", device.ID, entity.ID)
+	return c.publishState(device, entity, c.stateTopic(device, entity), compositeKey, value)
+}
+
+// PublishAttributeState publishes the state of one attribute of an entity with several variables
+func (c *Client) PublishAttributeState(device config.Device, entity config.Entity, attribute, value string) error {
+	attributeKey := fmt.Sprintf("%s_%s_entity_%s", device.ID, entity.ID, attribute)
+	return c.publishState(device, entity, c.attributeTopic(device, entity, attribute)+"/state", attributeKey, value)
+}
+
+func (c *Client) publishState(device config.Device, entity config.Entity, topic, stateKey, value string) error {
+	// Update internal state tracking
 	c.stateMu.Lock()
-	c.states[compositeKey] = value
+	c.states[stateKey] = value
 	c.stateMu.Unlock()
-	
-	return c.Publish(deviceStateTopic, value, true)
+
+	return c.publish(&outgoingMessage{
+		Topic:      topic,
+		Payload:    value,
+		QoS:        c.adapterCfg.GetEntityQoS(&entity),
+		Retain:     c.adapterCfg.GetEntityRetain(&entity),
+		Properties: stateProperties(c.adapterCfg, device, entity),
+	})
+}
+
+// stateProperties returns the MQTT 5 properties of a state, the user properties identify its
+// MySensors source
+func stateProperties(adapterCfg *config.AdapterConfig, device config.Device, entity config.Entity) *publishProperties {
+	nodeID := device.NodeID
+	if entity.NodeID != nil {
+		nodeID = *entity.NodeID
+	}
+	return &publishProperties{
+		UserProperties: map[string]string{
+			"gateway":  adapterCfg.GetEffectiveGateway(device.Gateway, entity.Gateway),
+			"node_id":  strconv.Itoa(nodeID),
+			"child_id": strconv.Itoa(entity.ChildID),
+		},
+		MessageExpiry: entity.MessageExpiry,
+	}
+}
+
+// RespondToCommand replies to an MQTT 5 command of an entity on its response topic. It does
+// nothing if the command did not ask for a response.
+func (c *Client) RespondToCommand(deviceID, entityID string, target *ResponseTarget, response CommandResponse) error {
+	if target == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("failed to marshal command response: %w", err)
+	}
+
+	var qos byte
+	if _, entity, found := c.findEntity(deviceID, entityID); found {
+		qos = c.adapterCfg.GetEntityQoS(&entity)
+	}
+	return c.publish(&outgoingMessage{
+		Topic:      target.Topic,
+		Payload:    string(payload),
+		QoS:        qos,
+		Properties: &publishProperties{CorrelationData: target.CorrelationData},
+	})
+}
+
+// PublishCommandResult publishes the delivery status of the last command sent to an entity
+func (c *Client) PublishCommandResult(deviceID, entityID string, result interface{}) error {
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal command result: %w", err)
+	}
+
+	device, entity, exists := c.findEntity(deviceID, entityID)
+	if !exists {
+		return fmt.Errorf("unknown entity %s of device %s", entityID, deviceID)
+	}
+	return c.Publish(c.adapterCfg.EntityTopic(&device, &entity)+"/command_result", string(payload), false)
+}
+
+// PublishRelayCommandResult publishes the delivery status of a relay switch of a relay_cover entity
+func (c *Client) PublishRelayCommandResult(deviceID, entityID string, childID int, result interface{}) error {
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal command result: %w", err)
+	}
+
+	device, entity, exists := c.findEntity(deviceID, entityID)
+	if !exists {
+		return fmt.Errorf("unknown entity %s of device %s", entityID, deviceID)
+	}
+	return c.Publish(config.RelayCommandResultTopic(c.adapterCfg.EntityTopic(&device, &entity), childID), string(payload), false)
 }
 
 // createEntityDiscoveryConfig creates Home Assistant discovery configuration for entities
@@ -435,13 +1025,30 @@ func (c *Client) createEntityDiscoveryConfig(device config.Device, entity config
 	discoveryConfig := map[string]interface{}{
 		"name":        entity.Name,
 		"unique_id":   fmt.Sprintf("%s_%s_entity", device.ID, entity.ID),
-		"state_topic": fmt.Sprintf("%s/devices/%s/entity/%s/state", c.adapterCfg.TopicPrefix, device.ID, entity.ID),
+		"state_topic": c.stateTopic(device, entity),
 		"device":      deviceInfo,
 	}
 
 	// Add command topic only for entities that can receive commands
 	if entity.CanReceiveCommands() {
-		discoveryConfig["command_topic"] = fmt.Sprintf("%s/devices/%s/entity/%s/set", c.adapterCfg.TopicPrefix, device.ID, entity.ID)
+		discoveryConfig["command_topic"] = c.commandTopic(device, entity)
+	}
+
+	// Entities with several variables announce the topics of each attribute, the entity
+	// topics belong to the state attribute
+	if attributes := entity.Attributes(); attributes != nil {
+		if _, exists := entity.Attribute(config.StateAttribute); !exists {
+			delete(discoveryConfig, "state_topic")
+			delete(discoveryConfig, "command_topic")
+		}
+		for _, attribute := range attributes {
+			if attribute.StateField != "" && entity.CanReportState() {
+				discoveryConfig[attribute.StateField] = c.attributeTopic(device, entity, attribute.Name) + "/state"
+			}
+			if attribute.CommandField != "" && attribute.Command && entity.CanReceiveCommands() {
+				discoveryConfig[attribute.CommandField] = c.attributeTopic(device, entity, attribute.Name) + "/set"
+			}
+		}
 	}
 
 	// Map entity type to Home Assistant entity type and configure appropriately
@@ -466,8 +1073,15 @@ func (c *Client) createEntityDiscoveryConfig(device config.Device, entity config
 			discoveryConfig["state_off"] = entity.StateOff
 		}
 
-	case "light":
+	case "light", "dimmer", "rgb_light", "rgbw_light":
 		haEntityType = "light"
+		if entity.Attributes() != nil {
+			discoveryConfig["supported_color_modes"] = lightColorModes(&entity)
+			break
+		}
+		if entity.EntityType != "light" {
+			break
+		}
 		// Set payload values with defaults
 		if entity.PayloadOn != "" {
 			discoveryConfig["payload_on"] = entity.PayloadOn
@@ -486,15 +1100,6 @@ func (c *Client) createEntityDiscoveryConfig(device config.Device, entity config
 			discoveryConfig["state_off"] = entity.StateOff
 		}
 
-	case "dimmer":
-		haEntityType = "light"
-		if entity.MinValue != nil {
-			discoveryConfig["min_mireds"] = *entity.MinValue
-		}
-		if entity.MaxValue != nil {
-			discoveryConfig["max_mireds"] = *entity.MaxValue
-		}
-
 	case "text":
 		if entity.IsReadOnly() {
 			// For read-only text entities, use sensor instead of text
@@ -524,24 +1129,12 @@ func (c *Client) createEntityDiscoveryConfig(device config.Device, entity config
 			discoveryConfig["options"] = entity.Options
 		}
 
-	case "cover":
+	case "cover", "relay_cover":
 		haEntityType = "cover"
 		// Cover-specific payloads
-		if entity.PayloadOpen != "" {
-			discoveryConfig["payload_open"] = entity.PayloadOpen
-		} else {
-			discoveryConfig["payload_open"] = "OPEN"
-		}
-		if entity.PayloadClose != "" {
-			discoveryConfig["payload_close"] = entity.PayloadClose
-		} else {
-			discoveryConfig["payload_close"] = "CLOSE"
-		}
-		if entity.PayloadStop != "" {
-			discoveryConfig["payload_stop"] = entity.PayloadStop
-		} else {
-			discoveryConfig["payload_stop"] = "STOP"
-		}
+		discoveryConfig["payload_open"] = entity.GetPayloadOpen()
+		discoveryConfig["payload_close"] = entity.GetPayloadClose()
+		discoveryConfig["payload_stop"] = entity.GetPayloadStop()
 		if entity.StateOpen != "" {
 			discoveryConfig["state_open"] = entity.StateOpen
 		}
@@ -549,6 +1142,55 @@ func (c *Client) createEntityDiscoveryConfig(device config.Device, entity config
 			discoveryConfig["state_closed"] = entity.StateClosed
 		}
 
+	case "climate":
+		haEntityType = "climate"
+		hasAttribute := func(name string) bool {
+			_, exists := entity.Attribute(name)
+			return exists
+		}
+		if hasAttribute("current_temperature") {
+			discoveryConfig["current_temperature_topic"] = c.attributeTopic(device, entity, "current_temperature") + "/state"
+		}
+
+		modes := entity.GetClimateModes()
+		if hasAttribute("mode") {
+			discoveryConfig["modes"] = modes
+			discoveryConfig["mode_state_topic"] = c.attributeTopic(device, entity, "mode") + "/state"
+			discoveryConfig["mode_command_topic"] = c.attributeTopic(device, entity, "mode") + "/set"
+		}
+
+		// The single target temperature is the cool setpoint only for devices that cannot heat
+		target := "heat_setpoint"
+		if !hasAttribute(target) || (!slices.Contains(modes, "heat") && !slices.Contains(modes, "heat_cool") && slices.Contains(modes, "cool")) {
+			target = "cool_setpoint"
+		}
+		if hasAttribute(target) {
+			discoveryConfig["temperature_state_topic"] = c.attributeTopic(device, entity, target) + "/state"
+			discoveryConfig["temperature_command_topic"] = c.attributeTopic(device, entity, target) + "/set"
+		}
+		if slices.Contains(modes, "heat_cool") && hasAttribute("heat_setpoint") && hasAttribute("cool_setpoint") {
+			discoveryConfig["temperature_low_state_topic"] = c.attributeTopic(device, entity, "heat_setpoint") + "/state"
+			discoveryConfig["temperature_low_command_topic"] = c.attributeTopic(device, entity, "heat_setpoint") + "/set"
+			discoveryConfig["temperature_high_state_topic"] = c.attributeTopic(device, entity, "cool_setpoint") + "/state"
+			discoveryConfig["temperature_high_command_topic"] = c.attributeTopic(device, entity, "cool_setpoint") + "/set"
+		}
+
+		if len(entity.FanModes) > 0 && hasAttribute("fan_mode") {
+			discoveryConfig["fan_modes"] = entity.FanModes
+			discoveryConfig["fan_mode_state_topic"] = c.attributeTopic(device, entity, "fan_mode") + "/state"
+			discoveryConfig["fan_mode_command_topic"] = c.attributeTopic(device, entity, "fan_mode") + "/set"
+		}
+
+		if entity.MinValue != nil {
+			discoveryConfig["min_temp"] = *entity.MinValue
+		}
+		if entity.MaxValue != nil {
+			discoveryConfig["max_temp"] = *entity.MaxValue
+		}
+		if entity.Step != nil {
+			discoveryConfig["temp_step"] = *entity.Step
+		}
+
 	case "binary_sensor":
 		haEntityType = "binary_sensor"
 		// Set payload values with defaults
@@ -605,16 +1247,8 @@ func (c *Client) createEntityDiscoveryConfig(device config.Device, entity config
 	if entity.EnabledByDefault != nil {
 		discoveryConfig["enabled_by_default"] = *entity.EnabledByDefault
 	}
-	if entity.QOS != nil {
-		discoveryConfig["qos"] = *entity.QOS
-	} else {
-		discoveryConfig["qos"] = 0
-	}
-	if entity.Retain != nil {
-		discoveryConfig["retain"] = *entity.Retain
-	} else {
-		discoveryConfig["retain"] = true
-	}
+	discoveryConfig["qos"] = c.adapterCfg.GetEntityQoS(&entity)
+	discoveryConfig["retain"] = c.adapterCfg.GetEntityRetain(&entity)
 	if entity.Optimistic != nil {
 		discoveryConfig["optimistic"] = *entity.Optimistic
 	} else if c.adapterCfg.Optimistic != nil {
@@ -623,20 +1257,42 @@ func (c *Client) createEntityDiscoveryConfig(device config.Device, entity config
 		discoveryConfig["optimistic"] = false
 	}
 
-	// Availability configuration
+	// Availability configuration: the entity is available only if the adapter, its gateway,
+	// its tracked node and any entity-specific availability topic all report online
+	var availability []map[string]interface{}
 	if entity.AvailabilityTopic != "" {
-		discoveryConfig["availability_topic"] = entity.AvailabilityTopic
+		entityAvailability := map[string]interface{}{
+			"topic":                 entity.AvailabilityTopic,
+			"payload_available":     "online",
+			"payload_not_available": "offline",
+		}
 		if entity.PayloadAvailable != "" {
-			discoveryConfig["payload_available"] = entity.PayloadAvailable
-		} else {
-			discoveryConfig["payload_available"] = "online"
+			entityAvailability["payload_available"] = entity.PayloadAvailable
 		}
 		if entity.PayloadNotAvailable != "" {
-			discoveryConfig["payload_not_available"] = entity.PayloadNotAvailable
-		} else {
-			discoveryConfig["payload_not_available"] = "offline"
+			entityAvailability["payload_not_available"] = entity.PayloadNotAvailable
 		}
+		availability = append(availability, entityAvailability)
+	}
+	availability = append(availability, map[string]interface{}{
+		"topic":                 c.adapterStatusTopic(),
+		"payload_available":     "online",
+		"payload_not_available": "offline",
+	})
+	availability = append(availability, map[string]interface{}{
+		"topic":                 c.gatewayStatusTopic(c.adapterCfg.GetEffectiveGateway(device.Gateway, entity.Gateway)),
+		"payload_available":     "online",
+		"payload_not_available": "offline",
+	})
+	if _, tracked := c.adapterCfg.GetAvailabilityTimeout(&device); tracked {
+		availability = append(availability, map[string]interface{}{
+			"topic":                 c.availabilityTopic(device, entity),
+			"payload_available":     "online",
+			"payload_not_available": "offline",
+		})
 	}
+	discoveryConfig["availability"] = availability
+	discoveryConfig["availability_mode"] = "all"
 
 	// Template configuration
 	if entity.JSONAttributesTopic != "" {
@@ -691,3 +1347,121 @@ func (c *Client) PublishGatewayAdapterStatus(topicPrefix, gatewayName string, no
 
 	return c.Publish(topic, nodeIDList, true)
 }
+
+
+// PublishGatewayNodeRegistry publishes the persistent node registry of a gateway as retained JSON
+func (c *Client) PublishGatewayNodeRegistry(topicPrefix, gatewayName string, nodes interface{}) error {
+	payload, err := json.Marshal(nodes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal node registry: %w", err)
+	}
+
+	topic := fmt.Sprintf("%s/gateway/%s/nodes", topicPrefix, gatewayName)
+	return c.Publish(topic, string(payload), true)
+}
+
+// PublishGatewaySleepQueue publishes the queue depth and smart-sleep nodes of a gateway
+func (c *Client) PublishGatewaySleepQueue(topicPrefix, gatewayName string, nodes interface{}) error {
+	payload, err := json.Marshal(nodes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sleep queue: %w", err)
+	}
+
+	topic := fmt.Sprintf("%s/gateway/%s/sleep_queue", topicPrefix, gatewayName)
+	return c.Publish(topic, string(payload), true)
+}
+
+// PublishFirmwareStatus publishes the firmware a node runs and the progress of its update
+func (c *Client) PublishFirmwareStatus(topicPrefix, gatewayName string, nodeID int, status interface{}) error {
+	payload, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("failed to marshal firmware status: %w", err)
+	}
+
+	topic := fmt.Sprintf("%s/gateway/%s/firmware/%d", topicPrefix, gatewayName, nodeID)
+	return c.Publish(topic, string(payload), true)
+}
+
+// PublishRawMessage mirrors a MySensors message in the MySensors MQTT gateway topic format
+func (c *Client) PublishRawMessage(prefix string, message *mysensors.Message) error {
+	return c.Publish(message.MQTTTopic(prefix), message.Payload, false)
+}
+
+// stateTopic returns the topic the state of an entity is published to
+func (c *Client) stateTopic(device config.Device, entity config.Entity) string {
+	return c.adapterCfg.EntityTopic(&device, &entity) + "/state"
+}
+
+// lightColorModes returns the Home Assistant color modes of a light with several variables
+func lightColorModes(entity *config.Entity) []string {
+	var modes []string
+	for _, mode := range []string{"rgb", "rgbw"} {
+		if _, exists := entity.Attribute(mode); exists {
+			modes = append(modes, mode)
+		}
+	}
+	if len(modes) > 0 {
+		return modes
+	}
+	if _, exists := entity.Attribute("brightness"); exists {
+		return []string{"brightness"}
+	}
+	return []string{"onoff"}
+}
+
+// attributeTopic returns the base topic of an attribute of an entity with several variables
+func (c *Client) attributeTopic(device config.Device, entity config.Entity, attribute string) string {
+	return config.AttributeTopic(c.adapterCfg.EntityTopic(&device, &entity), attribute)
+}
+
+// commandTopic returns the topic commands for an entity are received on
+func (c *Client) commandTopic(device config.Device, entity config.Entity) string {
+	return c.adapterCfg.EntityTopic(&device, &entity) + "/set"
+}
+
+// availabilityTopic is where the node availability of an entity is published, below its base topic
+func (c *Client) availabilityTopic(device config.Device, entity config.Entity) string {
+	return c.adapterCfg.EntityTopic(&device, &entity) + "/availability"
+}
+
+// PublishEntityAvailability publishes whether the node of an entity is currently reachable
+func (c *Client) PublishEntityAvailability(deviceID, entityID string, online bool) error {
+	device, entity, exists := c.findEntity(deviceID, entityID)
+	if !exists {
+		return fmt.Errorf("unknown entity %s of device %s", entityID, deviceID)
+	}
+	payload := "offline"
+	if online {
+		payload = "online"
+	}
+	return c.Publish(c.availabilityTopic(device, entity), payload, true)
+}
+
+func (c *Client) adapterStatusTopic() string {
+	return fmt.Sprintf("%s/status", c.adapterCfg.TopicPrefix)
+}
+
+func (c *Client) gatewayStatusTopic(gatewayName string) string {
+	return fmt.Sprintf("%s/gateway/%s/status", c.adapterCfg.TopicPrefix, gatewayName)
+}
+
+func (c *Client) publishAdapterAvailability(online bool) error {
+	payload := "offline"
+	if online {
+		payload = "online"
+	}
+	token := c.conn.Publish(&outgoingMessage{Topic: c.adapterStatusTopic(), Payload: payload, QoS: 1, Retain: true})
+	if !token.WaitTimeout(5 * time.Second) {
+		return fmt.Errorf("publish timeout for topic %s", c.adapterStatusTopic())
+	}
+	return token.Error()
+}
+
+// PublishGatewayAvailability publishes whether the transport of a gateway is connected
+func (c *Client) PublishGatewayAvailability(gatewayName string, online bool) error {
+	payload := "offline"
+	if online {
+		payload = "online"
+	}
+	return c.Publish(c.gatewayStatusTopic(gatewayName), payload, true)
+}
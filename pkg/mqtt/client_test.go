@@ -0,0 +1,46 @@
+package mqtt
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestWaitForPublishes(t *testing.T) {
+	c := &Client{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	// Nothing in flight returns right away
+	start := time.Now()
+	c.waitForPublishes(time.Second)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("waitForPublishes() without publishes took %v", elapsed)
+	}
+
+	// Returns as soon as the last publish completed
+	c.publishStarted()
+	c.publishStarted()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.publishCompleted()
+		time.Sleep(10 * time.Millisecond)
+		c.publishCompleted()
+	}()
+	start = time.Now()
+	c.waitForPublishes(5 * time.Second)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waitForPublishes() took %v after the publishes completed", elapsed)
+	}
+	if inFlight := c.publishesInFlight(); inFlight != 0 {
+		t.Errorf("in flight = %d, want 0", inFlight)
+	}
+
+	// Gives up at the deadline
+	c.publishStarted()
+	start = time.Now()
+	c.waitForPublishes(20 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > time.Second {
+		t.Errorf("waitForPublishes() with a stuck publish took %v, want the timeout", elapsed)
+	}
+	c.publishCompleted()
+}
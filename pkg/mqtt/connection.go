@@ -0,0 +1,118 @@
+package mqtt
+
+import (
+	"strings"
+	"time"
+)
+
+// connection is the protocol specific link to the broker, implemented for MQTT 3.1.1 and MQTT 5.
+// Both implementations reconnect automatically once connected.
+type connection interface {
+	Connect() operationToken
+	IsConnected() bool      // Connected, or reconnecting automatically
+	IsConnectionOpen() bool // Connected right now
+	Disconnect(quiesce time.Duration)
+	Subscribe(topic string, qos byte, handler receiveHandler) operationToken
+	Publish(message *outgoingMessage) operationToken
+}
+
+// operationToken tracks the completion of an asynchronous broker operation
+type operationToken interface {
+	Done() <-chan struct{}
+	WaitTimeout(time.Duration) bool
+	Error() error
+}
+
+// connectionHandlers are called by a connection when its state changes. Both run in their own goroutine.
+type connectionHandlers struct {
+	onConnect        func()
+	onConnectionLost func(err error)
+}
+
+// receivedMessage is a message delivered for a subscription. The MQTT 5 properties are empty with MQTT 3.1.1.
+type receivedMessage struct {
+	Topic           string
+	Payload         []byte
+	ResponseTopic   string
+	CorrelationData []byte
+}
+
+type receiveHandler func(message *receivedMessage)
+
+// outgoingMessage is a message to publish
+type outgoingMessage struct {
+	Topic      string             `json:"topic"`
+	Payload    string             `json:"payload"`
+	QoS        byte               `json:"qos"`
+	Retain     bool               `json:"retain"`
+	Properties *publishProperties `json:"properties,omitempty"`
+}
+
+// publishProperties are MQTT 5 publish properties, they are dropped with MQTT 3.1.1
+type publishProperties struct {
+	UserProperties  map[string]string `json:"user_properties,omitempty"`
+	MessageExpiry   time.Duration     `json:"message_expiry,omitempty"`
+	CorrelationData []byte            `json:"correlation_data,omitempty"`
+}
+
+// asyncToken is an operationToken completed by a goroutine
+type asyncToken struct {
+	done chan struct{}
+	err  error
+}
+
+func newAsyncToken() *asyncToken {
+	return &asyncToken{done: make(chan struct{})}
+}
+
+func (t *asyncToken) complete(err error) {
+	t.err = err
+	close(t.done)
+}
+
+func (t *asyncToken) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *asyncToken) WaitTimeout(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Error returns the result of the operation, only valid once Done is closed
+func (t *asyncToken) Error() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+// topicMatches reports whether a topic matches a subscription filter with + and # wildcards
+func topicMatches(filter, topic string) bool {
+	// Wildcards in the first level do not match topics starting with $, such as $SYS
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
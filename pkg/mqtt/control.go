@@ -0,0 +1,104 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ControlHandler runs an adapter control command with the payload published to
+// <prefix>/adapter/cmd/<name>. The returned value is published as the result of the command.
+type ControlHandler func(payload []byte) (interface{}, error)
+
+// ControlResult is published as JSON to <prefix>/adapter/cmd/<name>/result
+type ControlResult struct {
+	Command   string      `json:"command"`
+	RequestID string      `json:"request_id,omitempty"` // Copied from the command payload
+	Status    string      `json:"status"`               // "ok" or "error"
+	Result    interface{} `json:"result,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// RegisterControlCommand adds a command to the <prefix>/adapter/cmd/ namespace
+func (c *Client) RegisterControlCommand(name string, handler ControlHandler) {
+	c.controlMu.Lock()
+	c.controlHandlers[name] = handler
+	c.controlMu.Unlock()
+}
+
+// StartControl subscribes to the control command topics
+func (c *Client) StartControl() error {
+	return c.addSubscription(c.controlTopic("+"), c.handleControlMessage)
+}
+
+func (c *Client) controlTopic(name string) string {
+	return fmt.Sprintf("%s/adapter/cmd/%s", c.adapterCfg.TopicPrefix, name)
+}
+
+// handleControlMessage runs a control command off the receive path so that slow commands
+// do not hold up other messages. Commands run one at a time.
+func (c *Client) handleControlMessage(msg *receivedMessage) {
+	name := msg.Topic[strings.LastIndex(msg.Topic, "/")+1:]
+	go func() {
+		result := c.runControlCommand(name, msg.Payload)
+		if err := c.publishControlResult(result, msg); err != nil {
+			c.logger.Error("Failed to publish control result", "command", name, "error", err)
+		}
+	}()
+}
+
+func (c *Client) runControlCommand(name string, payload []byte) ControlResult {
+	result := ControlResult{Command: name, Status: "ok"}
+
+	// The request ID lets callers match results to their commands
+	var request struct {
+		RequestID string `json:"request_id"`
+	}
+	if json.Unmarshal(payload, &request) == nil {
+		result.RequestID = request.RequestID
+	}
+
+	c.controlMu.Lock()
+	defer c.controlMu.Unlock()
+
+	handler, exists := c.controlHandlers[name]
+	if !exists {
+		result.Status = "error"
+		result.Error = fmt.Sprintf("unknown command %q", name)
+		c.logger.Warn("Unknown control command", "command", name)
+		return result
+	}
+
+	c.logger.Info("Running control command", "command", name)
+	value, err := handler(payload)
+	if err != nil {
+		result.Status = "error"
+		result.Error = err.Error()
+		c.logger.Warn("Control command failed", "command", name, "error", err)
+		return result
+	}
+	result.Result = value
+	return result
+}
+
+// publishControlResult publishes the result to the result topic of the command and, for MQTT 5
+// commands that carried a response topic, to the response topic as well
+func (c *Client) publishControlResult(result ControlResult, msg *receivedMessage) error {
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal control result: %w", err)
+	}
+
+	if err := c.publish(&outgoingMessage{Topic: c.controlTopic(result.Command) + "/result", Payload: string(payload), QoS: 1}); err != nil {
+		return err
+	}
+	if msg.ResponseTopic == "" {
+		return nil
+	}
+	return c.publish(&outgoingMessage{
+		Topic:      msg.ResponseTopic,
+		Payload:    string(payload),
+		QoS:        1,
+		Properties: &publishProperties{CorrelationData: msg.CorrelationData},
+	})
+}
@@ -0,0 +1,75 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"ms-mqtt-adapter/pkg/config"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqtt3Connection connects with MQTT 3.1.1 using the paho.mqtt.golang client
+type mqtt3Connection struct {
+	client mqtt.Client
+}
+
+func newMQTT3Connection(cfg *config.MQTTConfig, tlsConfig *tls.Config, will *outgoingMessage, handlers connectionHandlers, logger *slog.Logger) *mqtt3Connection {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(cfg.BrokerURL())
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	opts.SetClientID(cfg.ClientID)
+	if cfg.CleanSession != nil && !*cfg.CleanSession {
+		// Keep subscriptions and queued QoS 1 commands on the broker while disconnected
+		opts.SetCleanSession(false)
+		opts.SetResumeSubs(true)
+	}
+	if cfg.Username != "" {
+		opts.SetUsername(cfg.Username)
+	}
+	if cfg.Password != "" {
+		opts.SetPassword(cfg.Password)
+	}
+	opts.SetWill(will.Topic, will.Payload, will.QoS, will.Retain)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		handlers.onConnectionLost(err)
+	})
+	opts.SetReconnectingHandler(func(client mqtt.Client, opts *mqtt.ClientOptions) {
+		logger.Info("MQTT reconnecting...")
+	})
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		handlers.onConnect()
+	})
+
+	return &mqtt3Connection{client: mqtt.NewClient(opts)}
+}
+
+func (m *mqtt3Connection) Connect() operationToken {
+	return m.client.Connect()
+}
+
+func (m *mqtt3Connection) IsConnected() bool {
+	return m.client.IsConnected()
+}
+
+func (m *mqtt3Connection) IsConnectionOpen() bool {
+	return m.client.IsConnectionOpen()
+}
+
+func (m *mqtt3Connection) Disconnect(quiesce time.Duration) {
+	m.client.Disconnect(uint(quiesce.Milliseconds()))
+}
+
+func (m *mqtt3Connection) Subscribe(topic string, qos byte, handler receiveHandler) operationToken {
+	return m.client.Subscribe(topic, qos, func(client mqtt.Client, msg mqtt.Message) {
+		handler(&receivedMessage{Topic: msg.Topic(), Payload: msg.Payload()})
+	})
+}
+
+// Publish sends a message, MQTT 5 properties are dropped
+func (m *mqtt3Connection) Publish(message *outgoingMessage) operationToken {
+	return m.client.Publish(message.Topic, message.QoS, message.Retain, message.Payload)
+}
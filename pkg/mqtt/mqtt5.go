@@ -0,0 +1,255 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"math"
+	"ms-mqtt-adapter/pkg/config"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// mqtt5Connection connects with MQTT 5 using the paho.golang connection manager
+type mqtt5Connection struct {
+	clientConfig autopaho.ClientConfig
+	logger       *slog.Logger
+
+	mu      sync.Mutex
+	manager *autopaho.ConnectionManager // Created by Connect, dropped by Disconnect
+	ctx     context.Context             // Lives as long as the manager
+	cancel  context.CancelFunc
+	open    atomic.Bool
+
+	routes   map[string]receiveHandler // Subscription filter -> handler
+	routesMu sync.RWMutex
+}
+
+func newMQTT5Connection(cfg *config.MQTTConfig, tlsConfig *tls.Config, will *outgoingMessage, handlers connectionHandlers, logger *slog.Logger) (*mqtt5Connection, error) {
+	brokerURL, err := url.Parse(cfg.BrokerURL())
+	if err != nil {
+		return nil, fmt.Errorf("invalid mqtt broker URL: %w", err)
+	}
+
+	m := &mqtt5Connection{
+		logger: logger,
+		routes: make(map[string]receiveHandler),
+	}
+	cleanSession := cfg.CleanSession == nil || *cfg.CleanSession
+	m.clientConfig = autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{brokerURL},
+		TlsCfg:                        tlsConfig,
+		KeepAlive:                     30,
+		CleanStartOnInitialConnection: cleanSession,
+		ConnectUsername:               cfg.Username,
+		ConnectPassword:               []byte(cfg.Password),
+		WillMessage: &paho.WillMessage{
+			Topic:   will.Topic,
+			Payload: []byte(will.Payload),
+			QoS:     will.QoS,
+			Retain:  will.Retain,
+		},
+		OnConnectionUp: func(*autopaho.ConnectionManager, *paho.Connack) {
+			m.open.Store(true)
+			go handlers.onConnect()
+		},
+		OnConnectError: func(err error) {
+			logger.Warn("MQTT connection attempt failed", "error", err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID:          cfg.ClientID,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){m.route},
+			OnClientError: func(err error) {
+				logger.Debug("MQTT client error", "error", err)
+				m.connectionDown(handlers, err)
+			},
+			OnServerDisconnect: func(disconnect *paho.Disconnect) {
+				reason := ""
+				if disconnect.Properties != nil {
+					reason = disconnect.Properties.ReasonString
+				}
+				logger.Warn("MQTT broker closed the connection", "reason_code", disconnect.ReasonCode, "reason", reason)
+				m.connectionDown(handlers, autopaho.ConnectionDownError)
+			},
+		},
+	}
+	if !cleanSession {
+		// Keep subscriptions and queued QoS 1 commands on the broker while disconnected
+		m.clientConfig.SessionExpiryInterval = math.MaxUint32
+	}
+
+	return m, nil
+}
+
+// connectionDown reports a lost connection once, the client and the broker may both report it
+func (m *mqtt5Connection) connectionDown(handlers connectionHandlers, err error) {
+	if m.open.Swap(false) {
+		go handlers.onConnectionLost(err)
+	}
+}
+
+// Connect starts a connection manager unless one is running and waits for the connection to come up
+func (m *mqtt5Connection) Connect() operationToken {
+	token := newAsyncToken()
+
+	m.mu.Lock()
+	if m.manager == nil {
+		// A fresh context for every manager, Disconnect cancels the one of the previous manager
+		ctx, cancel := context.WithCancel(context.Background())
+		manager, err := autopaho.NewConnection(ctx, m.clientConfig)
+		if err != nil {
+			cancel()
+			m.mu.Unlock()
+			token.complete(fmt.Errorf("failed to start MQTT 5 connection: %w", err))
+			return token
+		}
+		m.manager, m.ctx, m.cancel = manager, ctx, cancel
+	}
+	manager, managerCtx := m.manager, m.ctx
+	m.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(managerCtx, 10*time.Second)
+		defer cancel()
+		token.complete(manager.AwaitConnection(ctx))
+	}()
+	return token
+}
+
+func (m *mqtt5Connection) IsConnected() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.manager != nil
+}
+
+func (m *mqtt5Connection) IsConnectionOpen() bool {
+	return m.open.Load()
+}
+
+// Disconnect stops the connection manager, a later Connect starts a new one
+func (m *mqtt5Connection) Disconnect(quiesce time.Duration) {
+	m.mu.Lock()
+	manager, cancelManager := m.manager, m.cancel
+	m.manager, m.ctx, m.cancel = nil, nil, nil
+	m.mu.Unlock()
+
+	if manager != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), quiesce+time.Second)
+		defer cancel()
+		if err := manager.Disconnect(ctx); err != nil {
+			m.logger.Debug("MQTT disconnect did not complete", "error", err)
+		}
+		cancelManager()
+	}
+	m.open.Store(false)
+}
+
+func (m *mqtt5Connection) Subscribe(topic string, qos byte, handler receiveHandler) operationToken {
+	m.routesMu.Lock()
+	m.routes[topic] = handler
+	m.routesMu.Unlock()
+
+	token := newAsyncToken()
+	manager, ctx := m.currentManager()
+	if manager == nil {
+		token.complete(autopaho.ConnectionDownError)
+		return token
+	}
+
+	go func() {
+		suback, err := manager.Subscribe(ctx, &paho.Subscribe{
+			Subscriptions: []paho.SubscribeOptions{{Topic: topic, QoS: qos}},
+		})
+		if err == nil && len(suback.Reasons) > 0 && suback.Reasons[0] >= 0x80 {
+			err = fmt.Errorf("broker refused subscription with reason code 0x%02X", suback.Reasons[0])
+		}
+		token.complete(err)
+	}()
+	return token
+}
+
+func (m *mqtt5Connection) Publish(message *outgoingMessage) operationToken {
+	token := newAsyncToken()
+	manager, ctx := m.currentManager()
+	if manager == nil {
+		token.complete(autopaho.ConnectionDownError)
+		return token
+	}
+
+	publish := &paho.Publish{
+		Topic:   message.Topic,
+		QoS:     message.QoS,
+		Retain:  message.Retain,
+		Payload: []byte(message.Payload),
+	}
+	if message.Properties != nil {
+		publish.Properties = toPahoProperties(message.Properties)
+	}
+
+	go func() {
+		_, err := manager.Publish(ctx, publish)
+		token.complete(err)
+	}()
+	return token
+}
+
+// toPahoProperties converts the properties of an outgoing message to MQTT 5 publish properties
+func toPahoProperties(properties *publishProperties) *paho.PublishProperties {
+	publish := &paho.PublishProperties{CorrelationData: properties.CorrelationData}
+	if properties.MessageExpiry > 0 {
+		// Whole seconds on the wire, rounded up so that a short expiry never becomes none
+		expiry := uint32(math.Ceil(properties.MessageExpiry.Seconds()))
+		publish.MessageExpiry = &expiry
+	}
+	// Sorted so that the properties are sent in a stable order
+	keys := make([]string, 0, len(properties.UserProperties))
+	for key := range properties.UserProperties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		publish.User.Add(key, properties.UserProperties[key])
+	}
+	return publish
+}
+
+// currentManager returns the running connection manager and its context
+func (m *mqtt5Connection) currentManager() (*autopaho.ConnectionManager, context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.manager, m.ctx
+}
+
+// route delivers a received message to the handlers of all matching subscriptions
+func (m *mqtt5Connection) route(received paho.PublishReceived) (bool, error) {
+	packet := received.Packet
+	message := &receivedMessage{
+		Topic:   packet.Topic,
+		Payload: packet.Payload,
+	}
+	if packet.Properties != nil {
+		message.ResponseTopic = packet.Properties.ResponseTopic
+		message.CorrelationData = packet.Properties.CorrelationData
+	}
+
+	m.routesMu.RLock()
+	var handlers []receiveHandler
+	for filter, handler := range m.routes {
+		if topicMatches(filter, packet.Topic) {
+			handlers = append(handlers, handler)
+		}
+	}
+	m.routesMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(message)
+	}
+	return len(handlers) > 0, nil
+}
@@ -0,0 +1,154 @@
+package mqtt
+
+import (
+	"bytes"
+	"ms-mqtt-adapter/pkg/config"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+func TestToPahoProperties(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties *publishProperties
+		wantUser   paho.UserProperties
+		wantExpiry *uint32
+	}{
+		{
+			name:       "empty",
+			properties: &publishProperties{},
+		},
+		{
+			name: "user properties sorted by key",
+			properties: &publishProperties{UserProperties: map[string]string{
+				"node_id": "5", "child_id": "1", "gateway": "main",
+			}},
+			wantUser: paho.UserProperties{{Key: "child_id", Value: "1"}, {Key: "gateway", Value: "main"}, {Key: "node_id", Value: "5"}},
+		},
+		{
+			name:       "expiry in whole seconds",
+			properties: &publishProperties{MessageExpiry: 30 * time.Second},
+			wantExpiry: uint32Ptr(30),
+		},
+		{
+			name:       "expiry rounded up",
+			properties: &publishProperties{MessageExpiry: 1500 * time.Millisecond},
+			wantExpiry: uint32Ptr(2),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := toPahoProperties(tt.properties)
+			if len(got.User) != len(tt.wantUser) {
+				t.Fatalf("user properties = %v, want %v", got.User, tt.wantUser)
+			}
+			for i := range tt.wantUser {
+				if got.User[i] != tt.wantUser[i] {
+					t.Errorf("user properties = %v, want %v", got.User, tt.wantUser)
+					break
+				}
+			}
+			switch {
+			case tt.wantExpiry == nil && got.MessageExpiry != nil:
+				t.Errorf("message expiry = %d, want none", *got.MessageExpiry)
+			case tt.wantExpiry != nil && (got.MessageExpiry == nil || *got.MessageExpiry != *tt.wantExpiry):
+				t.Errorf("message expiry = %v, want %d", got.MessageExpiry, *tt.wantExpiry)
+			}
+		})
+	}
+}
+
+func TestToPahoPropertiesCorrelationData(t *testing.T) {
+	got := toPahoProperties(&publishProperties{CorrelationData: []byte("request-1")})
+	if !bytes.Equal(got.CorrelationData, []byte("request-1")) {
+		t.Errorf("correlation data = %q, want %q", got.CorrelationData, "request-1")
+	}
+}
+
+func TestStateProperties(t *testing.T) {
+	entityNode := 7
+	tests := []struct {
+		name   string
+		device config.Device
+		entity config.Entity
+		want   map[string]string
+	}{
+		{
+			name:   "device node and default gateway",
+			device: config.Device{NodeID: 5},
+			entity: config.Entity{ChildID: 1},
+			want:   map[string]string{"gateway": "default", "node_id": "5", "child_id": "1"},
+		},
+		{
+			name:   "entity overrides",
+			device: config.Device{NodeID: 5, Gateway: "upstairs"},
+			entity: config.Entity{ChildID: 2, NodeID: &entityNode, Gateway: "cellar"},
+			want:   map[string]string{"gateway": "cellar", "node_id": "7", "child_id": "2"},
+		},
+		{
+			name:   "device gateway",
+			device: config.Device{NodeID: 5, Gateway: "upstairs"},
+			entity: config.Entity{ChildID: 3},
+			want:   map[string]string{"gateway": "upstairs", "node_id": "5", "child_id": "3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stateProperties(&config.AdapterConfig{}, tt.device, tt.entity).UserProperties
+			if len(got) != len(tt.want) {
+				t.Fatalf("user properties = %v, want %v", got, tt.want)
+			}
+			for key, value := range tt.want {
+				if got[key] != value {
+					t.Errorf("user property %s = %q, want %q", key, got[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestMQTT5Route(t *testing.T) {
+	m := &mqtt5Connection{routes: make(map[string]receiveHandler)}
+	var received []string
+	var responseTopic string
+	var correlationData []byte
+	m.routes["devices/+/set"] = func(msg *receivedMessage) {
+		received = append(received, "set:"+msg.Topic)
+		responseTopic = msg.ResponseTopic
+		correlationData = msg.CorrelationData
+	}
+	m.routes["devices/#"] = func(msg *receivedMessage) {
+		received = append(received, "all:"+msg.Topic)
+	}
+
+	handled, err := m.route(paho.PublishReceived{Packet: &paho.Publish{
+		Topic:   "devices/lamp/set",
+		Payload: []byte("ON"),
+		Properties: &paho.PublishProperties{
+			ResponseTopic:   "replies/lamp",
+			CorrelationData: []byte("42"),
+		},
+	}})
+	if err != nil || !handled {
+		t.Fatalf("route() = %t, %v, want handled", handled, err)
+	}
+	if len(received) != 2 {
+		t.Errorf("handlers called = %v, want both", received)
+	}
+	if responseTopic != "replies/lamp" || string(correlationData) != "42" {
+		t.Errorf("response target = %q %q, want replies/lamp 42", responseTopic, correlationData)
+	}
+
+	handled, _ = m.route(paho.PublishReceived{Packet: &paho.Publish{Topic: "other/topic"}})
+	if handled {
+		t.Error("route() handled a topic without subscription")
+	}
+}
+
+func uint32Ptr(value uint32) *uint32 {
+	return &value
+}
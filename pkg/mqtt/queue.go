@@ -0,0 +1,188 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// queueSaveDelay batches writes of the persisted queue while many messages arrive
+const queueSaveDelay = time.Second
+
+// queuedMessage is a publish held back while the broker is unreachable
+type queuedMessage struct {
+	outgoingMessage
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+// QueueStats describes the outbound queue
+type QueueStats struct {
+	Depth    int   `json:"depth"`
+	Dropped  int64 `json:"dropped"`
+	Replayed int   `json:"replayed"`
+}
+
+// outboundQueue keeps the latest message per topic in publish order, dropping the oldest
+// message once the limit is reached. It is optionally persisted so that a restart during
+// a broker outage does not lose the queued messages.
+type outboundQueue struct {
+	path      string // Empty if the queue is kept in memory only
+	limit     int
+	logger    *slog.Logger
+	mu        sync.Mutex
+	messages  []*queuedMessage // Oldest first
+	dropped   int64
+	saveTimer *time.Timer
+}
+
+func newOutboundQueue(path string, limit int, logger *slog.Logger) *outboundQueue {
+	return &outboundQueue{
+		path:   path,
+		limit:  limit,
+		logger: logger,
+	}
+}
+
+// load reads messages left from a previous run. A missing file is not an error.
+func (q *outboundQueue) load() error {
+	if q.path == "" {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	data, err := os.ReadFile(q.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read mqtt buffer: %w", err)
+	}
+
+	var messages []*queuedMessage
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return fmt.Errorf("failed to parse mqtt buffer %s: %w", q.path, err)
+	}
+	if len(messages) > q.limit {
+		q.dropped += int64(len(messages) - q.limit)
+		messages = messages[len(messages)-q.limit:]
+	}
+	q.messages = messages
+
+	if len(messages) > 0 {
+		q.logger.Info("Loaded buffered MQTT messages", "path", q.path, "messages", len(messages))
+	}
+	return nil
+}
+
+// push queues a message, replacing an older message for the same topic. It returns false
+// if the oldest message had to be dropped to make room.
+func (q *outboundQueue) push(message *queuedMessage) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, queued := range q.messages {
+		if queued.Topic == message.Topic {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			break
+		}
+	}
+
+	kept := true
+	if len(q.messages) >= q.limit {
+		drop := len(q.messages) - q.limit + 1
+		q.messages = q.messages[drop:]
+		q.dropped += int64(drop)
+		kept = false
+	}
+	q.messages = append(q.messages, message)
+
+	q.scheduleSave()
+	return kept
+}
+
+// drain removes and returns all queued messages, oldest first
+func (q *outboundQueue) drain() []*queuedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	messages := q.messages
+	q.messages = nil
+	if len(messages) > 0 {
+		q.scheduleSave()
+	}
+	return messages
+}
+
+func (q *outboundQueue) stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return QueueStats{Depth: len(q.messages), Dropped: q.dropped}
+}
+
+// scheduleSave writes the queue to disk shortly after a change. Must be called with q.mu held.
+func (q *outboundQueue) scheduleSave() {
+	if q.path == "" || q.saveTimer != nil {
+		return
+	}
+	q.saveTimer = time.AfterFunc(queueSaveDelay, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.saveTimer = nil
+		if err := q.save(); err != nil {
+			q.logger.Error("Failed to save mqtt buffer", "path", q.path, "error", err)
+		}
+	})
+}
+
+// flush writes pending changes to disk immediately
+func (q *outboundQueue) flush() {
+	if q.path == "" {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.saveTimer == nil {
+		return
+	}
+	q.saveTimer.Stop()
+	q.saveTimer = nil
+	if err := q.save(); err != nil {
+		q.logger.Error("Failed to save mqtt buffer", "path", q.path, "error", err)
+	}
+}
+
+// save must be called with q.mu held
+func (q *outboundQueue) save() error {
+	if len(q.messages) == 0 {
+		if err := os.Remove(q.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove mqtt buffer: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(q.messages)
+	if err != nil {
+		return fmt.Errorf("failed to marshal mqtt buffer: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(q.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	// Write to a temporary file and rename so that a crash never leaves a truncated buffer
+	tmpPath := q.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write mqtt buffer: %w", err)
+	}
+	if err := os.Rename(tmpPath, q.path); err != nil {
+		return fmt.Errorf("failed to replace mqtt buffer: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package mqtt
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestQueue(t *testing.T, path string, limit int) *outboundQueue {
+	t.Helper()
+	q := newOutboundQueue(path, limit, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := q.load(); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	return q
+}
+
+func queued(topic, payload string) *queuedMessage {
+	return &queuedMessage{
+		outgoingMessage: outgoingMessage{Topic: topic, Payload: payload, QoS: 1, Retain: true},
+		QueuedAt:        time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func queueTopics(messages []*queuedMessage) []string {
+	topics := make([]string, len(messages))
+	for i, message := range messages {
+		topics[i] = message.Topic + "=" + message.Payload
+	}
+	return topics
+}
+
+func checkTopics(t *testing.T, got []*queuedMessage, want ...string) {
+	t.Helper()
+	topics := queueTopics(got)
+	if len(topics) != len(want) {
+		t.Fatalf("messages = %v, want %v", topics, want)
+	}
+	for i := range want {
+		if topics[i] != want[i] {
+			t.Fatalf("messages = %v, want %v", topics, want)
+		}
+	}
+}
+
+func TestOutboundQueuePushReplacesTopic(t *testing.T) {
+	q := newTestQueue(t, "", 10)
+	q.push(queued("a", "1"))
+	q.push(queued("b", "1"))
+	if !q.push(queued("a", "2")) {
+		t.Error("push() of a replacement reported a drop")
+	}
+
+	// The replacement moves to the end so that the publish order is kept
+	checkTopics(t, q.drain(), "b=1", "a=2")
+	if stats := q.stats(); stats.Depth != 0 || stats.Dropped != 0 {
+		t.Errorf("stats = %+v, want empty queue without drops", stats)
+	}
+}
+
+func TestOutboundQueueOverflowDropsOldest(t *testing.T) {
+	q := newTestQueue(t, "", 2)
+	if !q.push(queued("a", "1")) || !q.push(queued("b", "1")) {
+		t.Fatal("push() dropped a message below the limit")
+	}
+	if q.push(queued("c", "1")) {
+		t.Error("push() over the limit did not report the drop")
+	}
+	if stats := q.stats(); stats.Depth != 2 || stats.Dropped != 1 {
+		t.Errorf("stats = %+v, want depth 2 and 1 dropped", stats)
+	}
+
+	// Replacing a queued topic at the limit makes room without dropping
+	if !q.push(queued("b", "2")) {
+		t.Error("push() of a replacement at the limit reported a drop")
+	}
+	checkTopics(t, q.drain(), "c=1", "b=2")
+	if stats := q.stats(); stats.Dropped != 1 {
+		t.Errorf("dropped = %d, want 1", stats.Dropped)
+	}
+}
+
+func TestOutboundQueuePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mqtt-buffer.json")
+
+	q := newTestQueue(t, path, 10)
+	q.push(queued("a", "1"))
+	message := queued("b", "1")
+	message.Properties = &publishProperties{
+		UserProperties:  map[string]string{"node_id": "5"},
+		MessageExpiry:   time.Minute,
+		CorrelationData: []byte("42"),
+	}
+	q.push(message)
+	q.flush()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("buffer not written: %v", err)
+	}
+
+	// A restart loads the messages in order with their properties
+	restored := newTestQueue(t, path, 10)
+	messages := restored.drain()
+	checkTopics(t, messages, "a=1", "b=1")
+	if got := messages[1]; got.QoS != 1 || !got.Retain || !got.QueuedAt.Equal(message.QueuedAt) {
+		t.Errorf("restored message = %+v, want %+v", got, message)
+	}
+	if properties := messages[1].Properties; properties == nil || properties.UserProperties["node_id"] != "5" ||
+		properties.MessageExpiry != time.Minute || string(properties.CorrelationData) != "42" {
+		t.Errorf("restored properties = %+v, want %+v", properties, message.Properties)
+	}
+
+	// Replayed messages are removed from disk
+	restored.flush()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("buffer still on disk after replay: %v", err)
+	}
+	if again := newTestQueue(t, path, 10); again.stats().Depth != 0 {
+		t.Errorf("depth after replay and restart = %d, want 0", again.stats().Depth)
+	}
+}
+
+func TestOutboundQueueLoadOverLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mqtt-buffer.json")
+	q := newTestQueue(t, path, 10)
+	for _, topic := range []string{"a", "b", "c"} {
+		q.push(queued(topic, "1"))
+	}
+	q.flush()
+
+	// A smaller limit after a restart keeps the newest messages
+	restored := newTestQueue(t, path, 2)
+	if stats := restored.stats(); stats.Depth != 2 || stats.Dropped != 1 {
+		t.Errorf("stats = %+v, want depth 2 and 1 dropped", stats)
+	}
+	checkTopics(t, restored.drain(), "b=1", "c=1")
+}
+
+func TestOutboundQueueLoadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mqtt-buffer.json")
+	if err := os.WriteFile(path, []byte("not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	q := newOutboundQueue(path, 10, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := q.load(); err == nil {
+		t.Error("load() of an invalid buffer succeeded")
+	}
+}
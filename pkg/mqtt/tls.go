@@ -0,0 +1,159 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"ms-mqtt-adapter/pkg/config"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader re-reads the CA bundle and client key pair when their files change, so rotated
+// certificates are picked up on the next connection without restarting the adapter
+type certReloader struct {
+	cfg    *config.MQTTTLSConfig
+	logger *slog.Logger
+
+	mu          sync.Mutex
+	caPool      *x509.CertPool
+	caModTime   time.Time
+	cert        *tls.Certificate
+	certModTime time.Time
+}
+
+// newTLSConfig builds the TLS configuration for the broker connection. Files are loaded once
+// up front so that configuration mistakes are reported at startup.
+func newTLSConfig(cfg *config.MQTTConfig, logger *slog.Logger) (*tls.Config, error) {
+	reloader := &certReloader{cfg: &cfg.TLS, logger: logger}
+
+	serverName := cfg.TLS.ServerName
+	if serverName == "" {
+		serverName = cfg.Broker
+		if brokerURL, err := url.Parse(cfg.BrokerURL()); err == nil && brokerURL.Hostname() != "" {
+			serverName = brokerURL.Hostname()
+		}
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+
+	if cfg.TLS.CAFile != "" {
+		if _, err := reloader.certPool(); err != nil {
+			return nil, err
+		}
+		// Verification is done in VerifyConnection against the current CA bundle
+		tlsConfig.InsecureSkipVerify = true
+		if !cfg.TLS.InsecureSkipVerify {
+			tlsConfig.VerifyConnection = reloader.verifyConnection(serverName)
+		}
+	} else {
+		tlsConfig.InsecureSkipVerify = cfg.TLS.InsecureSkipVerify
+	}
+
+	if cfg.TLS.CertFile != "" {
+		if _, err := reloader.clientCertificate(); err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return reloader.clientCertificate()
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// certPool returns the CA pool, reloading it if the file changed
+func (r *certReloader) certPool() (*x509.CertPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.cfg.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mqtt tls ca_file: %w", err)
+	}
+	if r.caPool != nil && info.ModTime().Equal(r.caModTime) {
+		return r.caPool, nil
+	}
+
+	data, err := os.ReadFile(r.cfg.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mqtt tls ca_file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in mqtt tls ca_file %s", r.cfg.CAFile)
+	}
+
+	if r.caPool != nil {
+		r.logger.Info("Reloaded MQTT CA certificates", "file", r.cfg.CAFile)
+	}
+	r.caPool = pool
+	r.caModTime = info.ModTime()
+	return pool, nil
+}
+
+// clientCertificate returns the client key pair, reloading it if either file changed
+func (r *certReloader) clientCertificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certInfo, err := os.Stat(r.cfg.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mqtt tls cert_file: %w", err)
+	}
+	keyInfo, err := os.Stat(r.cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mqtt tls key_file: %w", err)
+	}
+	modTime := certInfo.ModTime()
+	if keyInfo.ModTime().After(modTime) {
+		modTime = keyInfo.ModTime()
+	}
+	if r.cert != nil && modTime.Equal(r.certModTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mqtt client certificate: %w", err)
+	}
+
+	if r.cert != nil {
+		r.logger.Info("Reloaded MQTT client certificate", "file", r.cfg.CertFile)
+	}
+	r.cert = &cert
+	r.certModTime = modTime
+	return r.cert, nil
+}
+
+// verifyConnection verifies the broker certificate chain against the current CA pool
+func (r *certReloader) verifyConnection(serverName string) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return fmt.Errorf("broker presented no certificate")
+		}
+
+		pool, err := r.certPool()
+		if err != nil {
+			return err
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err = state.PeerCertificates[0].Verify(x509.VerifyOptions{
+			Roots:         pool,
+			Intermediates: intermediates,
+			DNSName:       serverName,
+		})
+		return err
+	}
+}